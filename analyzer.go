@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"strings"
 )
 
 // analyzeRawFrame analyzes non-CBOR frames for patterns and returns true if heartbeat detected
@@ -11,13 +10,13 @@ func analyzeRawFrame(frame *CANFrame, verbose bool) bool {
 
 	// Analyze specific CAN IDs for known patterns
 	switch {
-	case frame.ID == "14609460":
+	case frame.ID.Value == 0x14609460:
 		// This ID appears frequently
 		if verbose && len(frame.Data) >= 4 {
 			fmt.Printf("   📊 Telemetry? Bytes[1:4]: %02X %02X %02X %02X\n",
 				frame.Data[0], frame.Data[1], frame.Data[2], frame.Data[3])
 		}
-	case strings.HasPrefix(frame.ID, "01111"):
+	case isHeartbeatID(frame.ID):
 		// IDs starting with 01111 seem to be heartbeats (all zeros)
 		allZero := true
 		for _, b := range frame.Data {
@@ -32,7 +31,7 @@ func analyzeRawFrame(frame *CANFrame, verbose bool) bool {
 			}
 			isHeartbeat = true
 		}
-	case frame.ID == "18209820":
+	case frame.ID.Value == 0x18209820:
 		if verbose && len(frame.Data) >= 1 {
 			fmt.Printf("   🔢 Status byte: %02X\n", frame.Data[0])
 		}
@@ -41,9 +40,15 @@ func analyzeRawFrame(frame *CANFrame, verbose bool) bool {
 	return isHeartbeat
 }
 
+// isHeartbeatID reports whether an ID is in the heartbeat range: extended
+// IDs whose hex form starts with 01111, i.e. 0x01111000-0x01111FFF
+func isHeartbeatID(id CANID) bool {
+	return id.Extended && id.Value>>12 == 0x01111
+}
+
 // checkIfHeartbeat checks if a frame is a heartbeat/keep-alive frame
 func checkIfHeartbeat(frame *CANFrame) bool {
-	if !strings.HasPrefix(frame.ID, "01111") {
+	if !isHeartbeatID(frame.ID) {
 		return false
 	}
 	// Check if all bytes are zero
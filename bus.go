@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// busNames maps bus numbers to the names given with -bus-names. It is set
+// once at startup, before any frames are processed.
+var busNames = map[int]string{}
+
+// parseBusNames parses a bus name mapping such as "0=main,1=battery"
+func parseBusNames(spec string) (map[int]string, error) {
+	names := make(map[int]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		num, name, ok := strings.Cut(entry, "=")
+		bus, err := strconv.Atoi(strings.TrimSpace(num))
+		name = strings.TrimSpace(name)
+		if !ok || err != nil || bus < 0 || name == "" {
+			return nil, fmt.Errorf("invalid bus name %q (use NUMBER=NAME)", entry)
+		}
+		if strings.ContainsAny(name, " @,:") {
+			return nil, fmt.Errorf("bus name %q must not contain spaces, @, , or :", name)
+		}
+		names[bus] = name
+	}
+	return names, nil
+}
+
+// busName returns the configured name of a bus, or busN
+func busName(bus int) string {
+	if name, ok := busNames[bus]; ok {
+		return name
+	}
+	return fmt.Sprintf("bus%d", bus)
+}
+
+// busNumber resolves a bus name from -bus-names or a busN name to its number
+func busNumber(name string) (int, bool) {
+	for bus, n := range busNames {
+		if strings.EqualFold(n, name) {
+			return bus, true
+		}
+	}
+	if rest, ok := strings.CutPrefix(strings.ToLower(name), "bus"); ok {
+		if bus, err := strconv.Atoi(rest); err == nil && bus >= 0 {
+			return bus, true
+		}
+	}
+	return 0, false
+}
+
+// parseBus parses a bus given as a number or a name, e.g. in "7E8@1" or
+// "7E8@battery"
+func parseBus(text string) (int, error) {
+	if bus, err := strconv.Atoi(text); err == nil && bus >= 0 {
+		return bus, nil
+	}
+	if bus, ok := busNumber(text); ok {
+		return bus, nil
+	}
+	return 0, fmt.Errorf("unknown bus %q", text)
+}
+
+// busQualifiedID returns a CAN ID together with its bus, e.g. 123@battery,
+// so that the same ID on different buses is kept apart. IDs on an unnamed
+// bus 0 stay unqualified, which keeps single-bus output unchanged.
+func busQualifiedID(id CANID, bus int) string {
+	if _, named := busNames[bus]; bus == 0 && !named {
+		return id.String()
+	}
+	return id.String() + "@" + busName(bus)
+}
+
+// frameSourceID returns the bus-qualified CAN ID of a frame
+func frameSourceID(frame *CANFrame) string {
+	return busQualifiedID(frame.ID, frame.Bus)
+}
+
+// SourceID returns the bus-qualified CAN ID of a message
+func (m *Message) SourceID() string {
+	return busQualifiedID(m.ID, m.Bus)
+}
+
+// BusStats counts the traffic of one bus
+type BusStats struct {
+	Bus        int
+	Frames     int
+	Messages   int
+	Heartbeats int
+	IDs        map[CANID]bool
+}
+
+// BusStatsTable collects per-bus statistics as frames stream in
+type BusStatsTable map[int]*BusStats
+
+// Add counts a frame and, if not nil, the message it completed
+func (t BusStatsTable) Add(info *FrameInfo, msg *Message) {
+	s, ok := t[info.Frame.Bus]
+	if !ok {
+		s = &BusStats{Bus: info.Frame.Bus, IDs: make(map[CANID]bool)}
+		t[info.Frame.Bus] = s
+	}
+	s.Frames++
+	s.IDs[info.Frame.ID] = true
+	if info.IsHeartbeat {
+		s.Heartbeats++
+	}
+	if msg != nil {
+		s.Messages++
+	}
+}
+
+// display prints one line per bus; nothing for a single unnamed bus
+func (t BusStatsTable) display() {
+	if len(t) == 0 || len(t) == 1 && len(busNames) == 0 {
+		return
+	}
+	buses := make([]int, 0, len(t))
+	for bus := range t {
+		buses = append(buses, bus)
+	}
+	sort.Ints(buses)
+	fmt.Println("   Per bus:")
+	for _, bus := range buses {
+		s := t[bus]
+		fmt.Printf("     %d (%s): %d frames, %d IDs, %d CBOR messages, %d heartbeats\n",
+			bus, busName(bus), s.Frames, len(s.IDs), s.Messages, s.Heartbeats)
+	}
+}
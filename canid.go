@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Largest standard (11-bit) and extended (29-bit) identifiers
+const (
+	canStdMaxID = 0x7FF
+	canExtMaxID = 0x1FFFFFFF
+)
+
+// CANID is a CAN identifier with its frame format
+type CANID struct {
+	Value    uint32
+	Extended bool // 29-bit extended format instead of 11-bit standard
+}
+
+// ParseCANID parses a hex CAN ID such as "123", "0x18209820" or the ASC
+// style "18209820x". As in candump, IDs written with more than three digits
+// or too large for 11 bits are extended.
+func ParseCANID(text string) (CANID, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(text), "0x"), "0X")
+	digits, extended := strings.CutSuffix(strings.ToLower(digits), "x")
+	id, err := parseCANIDValue(digits, extended || len(digits) > 3)
+	if err != nil {
+		return CANID{}, err
+	}
+	if id.Value > canStdMaxID {
+		id.Extended = true
+	}
+	return id, nil
+}
+
+// parseCANIDAs parses a hex CAN ID whose format is known, e.g. from the
+// Extended column of SavvyCAN CSV
+func parseCANIDAs(text string, extended bool) (CANID, error) {
+	text = strings.TrimSpace(text)
+	digits := strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")
+	id, err := parseCANIDValue(digits, extended)
+	if err != nil {
+		return CANID{}, err
+	}
+	if id.Value > canStdMaxID {
+		id.Extended = true
+	}
+	return id, nil
+}
+
+// parseCANIDValue parses hex digits and checks the range of the format
+func parseCANIDValue(digits string, extended bool) (CANID, error) {
+	n, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || n > canExtMaxID {
+		return CANID{}, fmt.Errorf("invalid CAN ID %q", digits)
+	}
+	return CANID{Value: uint32(n), Extended: extended}, nil
+}
+
+// String formats the ID like candump: 3 hex digits for standard and 8 for
+// extended IDs
+func (id CANID) String() string {
+	if id.Extended {
+		return fmt.Sprintf("%08X", id.Value)
+	}
+	return fmt.Sprintf("%03X", id.Value)
+}
+
+// Bits returns the identifier width, 11 or 29
+func (id CANID) Bits() int {
+	if id.Extended {
+		return 29
+	}
+	return 11
+}
+
+// IDField is a bit field of an extended identifier
+type IDField struct {
+	Name  string
+	Shift int
+	Bits  int
+}
+
+// IDLayout decomposes 29-bit identifiers into named fields. Fields may
+// overlap, e.g. a PGN spanning several J1939 fields.
+type IDLayout struct {
+	Name   string
+	Fields []IDField
+}
+
+// idLayouts are the built-in layouts for -id-layout
+var idLayouts = map[string]*IDLayout{
+	"j1939": {Name: "j1939", Fields: []IDField{
+		{"priority", 26, 3},
+		{"pgn", 8, 18},
+		{"pf", 16, 8},
+		{"ps", 8, 8}, // destination address when pf < F0
+		{"source", 0, 8},
+	}},
+}
+
+// idLayout is the layout chosen with -id-layout, or nil. It is set once at
+// startup, before any frames are processed.
+var idLayout *IDLayout
+
+// parseIDLayout returns a built-in layout by name or parses a custom one as
+// a comma-separated list of name:shift:bits, e.g.
+// "priority:26:3,function:16:10,source:8:8,dest:0:8"
+func parseIDLayout(spec string) (*IDLayout, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	if layout, ok := idLayouts[strings.ToLower(spec)]; ok {
+		return layout, nil
+	}
+	if !strings.Contains(spec, ":") {
+		return nil, fmt.Errorf("unknown ID layout %q (use %s or name:shift:bits,...)", spec, strings.Join(layoutNames(), ", "))
+	}
+	layout := &IDLayout{Name: "custom"}
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("ID layout: expected name:shift:bits, got %q", entry)
+		}
+		shift, err1 := strconv.Atoi(parts[1])
+		bits, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || shift < 0 || bits < 1 || shift+bits > 29 {
+			return nil, fmt.Errorf("ID layout: field %q must fit in 29 bits", entry)
+		}
+		name := strings.ToLower(parts[0])
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("ID layout: missing or duplicate field name in %q", entry)
+		}
+		seen[name] = true
+		layout.Fields = append(layout.Fields, IDField{Name: name, Shift: shift, Bits: bits})
+	}
+	return layout, nil
+}
+
+// layoutNames returns the names of the built-in layouts
+func layoutNames() []string {
+	names := make([]string, 0, len(idLayouts))
+	for name := range idLayouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Field returns the value of a named field of an extended ID
+func (l *IDLayout) Field(id CANID, name string) (uint32, bool) {
+	if l == nil || !id.Extended {
+		return 0, false
+	}
+	for _, f := range l.Fields {
+		if f.Name == name {
+			return id.Value >> f.Shift & (1<<f.Bits - 1), true
+		}
+	}
+	return 0, false
+}
+
+// Format describes the fields of an extended ID, e.g. "priority=6 source=20",
+// or returns "" for standard IDs
+func (l *IDLayout) Format(id CANID) string {
+	if l == nil || !id.Extended {
+		return ""
+	}
+	parts := make([]string, len(l.Fields))
+	for i, f := range l.Fields {
+		parts[i] = fmt.Sprintf("%s=%X", f.Name, id.Value>>f.Shift&(1<<f.Bits-1))
+	}
+	return strings.Join(parts, " ")
+}
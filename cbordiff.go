@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// cborMaxDistinct caps the distinct values tracked per field and file
+const cborMaxDistinct = 1000
+
+// FieldSummary describes the values of one decoded CBOR field in one capture
+type FieldSummary struct {
+	Count    int
+	Numeric  bool // all values were numbers
+	Min      float64
+	Max      float64
+	Values   map[string]int
+	Overflow bool // more than cborMaxDistinct distinct values
+}
+
+// add records one value of the field
+func (s *FieldSummary) add(v interface{}) {
+	n, numeric := fieldNumber(v)
+	if s.Count == 0 {
+		s.Numeric, s.Min, s.Max = numeric, n, n
+	}
+	s.Count++
+	if !numeric {
+		s.Numeric = false
+	} else {
+		s.Min, s.Max = math.Min(s.Min, n), math.Max(s.Max, n)
+	}
+	text := formatFieldValue(v)
+	if _, ok := s.Values[text]; ok || len(s.Values) < cborMaxDistinct {
+		s.Values[text]++
+	} else {
+		s.Overflow = true
+	}
+}
+
+// describe summarizes the field values, e.g. "always 3" or "0..25, 12 distinct"
+func (s *FieldSummary) describe() string {
+	if len(s.Values) == 1 && !s.Overflow {
+		for v := range s.Values {
+			return fmt.Sprintf("always %s (%d×)", v, s.Count)
+		}
+	}
+	values := make([]string, 0, len(s.Values))
+	for v := range s.Values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if s.Values[values[i]] != s.Values[values[j]] {
+			return s.Values[values[i]] > s.Values[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	var top []string
+	for _, v := range values[:min(len(values), 4)] {
+		top = append(top, fmt.Sprintf("%s×%d", v, s.Values[v]))
+	}
+	distinct := fmt.Sprintf("%d distinct", len(s.Values))
+	if s.Overflow {
+		distinct = fmt.Sprintf("over %d distinct", cborMaxDistinct)
+	}
+	if s.Numeric {
+		return fmt.Sprintf("%s..%s, %s, %d values, top %s", formatNumber(s.Min), formatNumber(s.Max),
+			distinct, s.Count, strings.Join(top, " "))
+	}
+	return fmt.Sprintf("%s, %d values, top %s", distinct, s.Count, strings.Join(top, " "))
+}
+
+// sameValues reports whether two summaries saw the same set of values
+func (s *FieldSummary) sameValues(o *FieldSummary) bool {
+	if s.Overflow || o.Overflow || len(s.Values) != len(o.Values) {
+		return false
+	}
+	for v := range s.Values {
+		if _, ok := o.Values[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// formatNumber prints integral values without a fraction
+func formatNumber(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return fmt.Sprintf("%.0f", f)
+	}
+	return fmt.Sprintf("%g", f)
+}
+
+// arrayIndex matches array index segments of a field path
+var arrayIndex = regexp.MustCompile(`\[\d+\]`)
+
+// messageShape describes a decoded message by its field paths, with array
+// indices collapsed so that arrays of different lengths share a shape
+func messageShape(item interface{}) string {
+	seen := make(map[string]bool)
+	var paths []string
+	walkFields(item, "", func(path string, _ interface{}) {
+		path = arrayIndex.ReplaceAllString(path, "[]")
+		if path == "" {
+			path = "(scalar)"
+		}
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	})
+	return "{" + strings.Join(paths, " ") + "}"
+}
+
+// captureMessages returns the distinct decoded messages of a capture in order
+func captureMessages(frames []*FrameInfo) []*Message {
+	var messages []*Message
+	seen := make(map[*Message]bool)
+	for _, f := range frames {
+		if f.Message != nil && !seen[f.Message] {
+			seen[f.Message] = true
+			messages = append(messages, f.Message)
+		}
+	}
+	return messages
+}
+
+// CBORComparison collects decoded CBOR messages of several captures one at
+// a time, so that captures need not be held in memory
+type CBORComparison struct {
+	files       []string
+	messages    []int            // per file
+	last        []*Message       // last message added per file
+	shapeCounts map[string][]int // shape -> count per file
+	shapes      []string
+	fields      map[string][]*FieldSummary // "ID path" -> summary per file
+	fieldIDs    map[string][]string        // ID -> field paths in order of appearance
+	ids         []string
+}
+
+// NewCBORComparison returns a comparison of the given files
+func NewCBORComparison(filenames []string) *CBORComparison {
+	return &CBORComparison{
+		files:       filenames,
+		messages:    make([]int, len(filenames)),
+		last:        make([]*Message, len(filenames)),
+		shapeCounts: make(map[string][]int),
+		fields:      make(map[string][]*FieldSummary),
+		fieldIDs:    make(map[string][]string),
+	}
+}
+
+// AddFrame adds the message of a frame of file i, once per message
+func (c *CBORComparison) AddFrame(i int, f *FrameInfo) {
+	if f.Message == nil || f.Message == c.last[i] {
+		return
+	}
+	c.last[i] = f.Message
+	c.Add(i, f.Message)
+}
+
+// Add adds a decoded message of file i
+func (c *CBORComparison) Add(i int, msg *Message) {
+	c.messages[i]++
+	id := msg.SourceID()
+	shape := "ID:0x" + id + " " + messageShape(msg.Item)
+	if _, ok := c.shapeCounts[shape]; !ok {
+		c.shapeCounts[shape] = make([]int, len(c.files))
+		c.shapes = append(c.shapes, shape)
+	}
+	c.shapeCounts[shape][i]++
+
+	walkFields(msg.Item, "", func(path string, value interface{}) {
+		if path == "" {
+			path = "(scalar)"
+		}
+		key := id + " " + path
+		if _, ok := c.fields[key]; !ok {
+			c.fields[key] = make([]*FieldSummary, len(c.files))
+			if _, ok := c.fieldIDs[id]; !ok {
+				c.ids = append(c.ids, id)
+			}
+			c.fieldIDs[id] = append(c.fieldIDs[id], path)
+		}
+		if c.fields[key][i] == nil {
+			c.fields[key][i] = &FieldSummary{Values: make(map[string]int)}
+		}
+		c.fields[key][i].add(value)
+	})
+}
+
+// Merge adds the first file of another comparison as file i, keeping the
+// order in which shapes and fields first appeared
+func (c *CBORComparison) Merge(i int, o *CBORComparison) {
+	c.messages[i] += o.messages[0]
+	for _, shape := range o.shapes {
+		if _, ok := c.shapeCounts[shape]; !ok {
+			c.shapeCounts[shape] = make([]int, len(c.files))
+			c.shapes = append(c.shapes, shape)
+		}
+		c.shapeCounts[shape][i] += o.shapeCounts[shape][0]
+	}
+	for _, id := range o.ids {
+		if _, ok := c.fieldIDs[id]; !ok {
+			c.ids = append(c.ids, id)
+		}
+		for _, path := range o.fieldIDs[id] {
+			key := id + " " + path
+			if _, ok := c.fields[key]; !ok {
+				c.fields[key] = make([]*FieldSummary, len(c.files))
+				c.fieldIDs[id] = append(c.fieldIDs[id], path)
+			}
+			c.fields[key][i] = o.fields[key][0]
+		}
+	}
+}
+
+// CompareCBORMessages diffs the decoded CBOR messages of several captures:
+// message shapes (ID + field set) per file, and per field the values seen in
+// each file, flagging fields whose values differ
+func CompareCBORMessages(c *CBORComparison) {
+	filenames := c.files
+	shapeCounts, shapes := c.shapeCounts, c.shapes
+	fields, fieldIDs, ids := c.fields, c.fieldIDs, c.ids
+
+	fmt.Println("\n===================================================")
+	fmt.Println("🧬 DECODED CBOR COMPARISON")
+	fmt.Println("===================================================")
+	fmt.Println("Files analyzed:")
+	for i, fn := range filenames {
+		fmt.Printf("  [%d] %s (%d CBOR messages)\n", i+1, fn, c.messages[i])
+	}
+	sort.Strings(shapes)
+	sort.Strings(ids)
+
+	// --- Message shapes ---
+	fmt.Printf("\n🧩 Message shapes (%d):\n", len(shapes))
+	fmt.Println(strings.Repeat("-", 70))
+	exclusiveShapes := 0
+	for _, shape := range shapes {
+		counts := make([]string, len(filenames))
+		present := 0
+		for i, n := range shapeCounts[shape] {
+			counts[i] = fmt.Sprintf("%d", n)
+			if n > 0 {
+				present++
+			}
+		}
+		marker := "  "
+		if present < len(filenames) {
+			marker = "🔸"
+			exclusiveShapes++
+		}
+		fmt.Printf("%s %s\n", marker, shape)
+		fmt.Printf("    Occurrences: [%s]\n", strings.Join(counts, ", "))
+	}
+
+	// --- Field values ---
+	fmt.Println("\n🔑 Field values per file:")
+	fmt.Println(strings.Repeat("-", 70))
+	missing, differing, same := 0, 0, 0
+	for _, id := range ids {
+		fmt.Printf("  ID:0x%s\n", id)
+		for _, path := range fieldIDs[id] {
+			summaries := fields[id+" "+path]
+			status := "="
+			present := 0
+			for _, s := range summaries {
+				if s != nil {
+					present++
+				}
+			}
+			switch {
+			case present < len(filenames):
+				status = "🔸"
+				missing++
+			case !allSameValues(summaries):
+				status = "≠"
+				differing++
+			default:
+				same++
+			}
+			fmt.Printf("    %s %s\n", status, path)
+			for i, s := range summaries {
+				if s == nil {
+					fmt.Printf("        [%d] -\n", i+1)
+				} else {
+					fmt.Printf("        [%d] %s\n", i+1, s.describe())
+				}
+			}
+		}
+	}
+
+	fmt.Println("\n===================================================")
+	fmt.Printf("📈 Summary:\n")
+	fmt.Printf("   Message shapes: %d (%d not in every file)\n", len(shapes), exclusiveShapes)
+	fmt.Printf("   Fields with differing values (≠): %d\n", differing)
+	fmt.Printf("   Fields missing from some files (🔸): %d\n", missing)
+	fmt.Printf("   Fields with identical value sets (=): %d\n", same)
+	fmt.Println("===================================================")
+}
+
+// allSameValues reports whether every file saw the same set of field values
+func allSameValues(summaries []*FieldSummary) bool {
+	for _, s := range summaries[1:] {
+		if !summaries[0].sameValues(s) {
+			return false
+		}
+	}
+	return true
+}
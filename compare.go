@@ -8,90 +8,198 @@ import (
 
 // UnaccountedFrame represents a unique unaccounted frame pattern
 type UnaccountedFrame struct {
-	ID          string
-	Header      byte
-	DataHex     string
-	Occurrences map[string]int // filename -> count
+	ID          string         `json:"id"`
+	Header      byte           `json:"header"`
+	DataHex     string         `json:"data"`
+	Category    string         `json:"category"`    // common, unique or partial
+	Occurrences map[string]int `json:"occurrences"` // filename -> count
 }
 
-// CompareUnaccountedFrames compares unaccounted frames across multiple files
-func CompareUnaccountedFrames(fileFrames map[string][]*FrameInfo) {
-	// Collect all unique unaccounted frame patterns
-	framePatterns := make(map[string]*UnaccountedFrame)
+// Pattern categories of a comparison
+const (
+	categoryCommon  = "common"  // present in all files
+	categoryUnique  = "unique"  // present in exactly one file
+	categoryPartial = "partial" // present in some files
+)
 
-	for filename, frames := range fileFrames {
-		for _, f := range frames {
-			if f.IsCBOR || f.IsHeartbeat {
-				continue
-			}
+// ComparedFile is one input file of a comparison
+type ComparedFile struct {
+	Name              string `json:"name"`
+	UnaccountedFrames int    `json:"unaccounted_frames"`
+	TotalFrames       int    `json:"total_frames"`
+}
 
-			// Create unique key from ID + header + data
-			dataHex := fmt.Sprintf("%X", f.Frame.Data)
-			key := fmt.Sprintf("%s:%02X:%s", f.Frame.ID, f.Header, dataHex)
+// ComparisonResult is the outcome of comparing unaccounted frames across files
+type ComparisonResult struct {
+	Files    []ComparedFile      `json:"files"` // sorted by name
+	Mask     string              `json:"mask,omitempty"`
+	Patterns []*UnaccountedFrame `json:"patterns"` // sorted by ID, header and data
+}
 
-			if _, exists := framePatterns[key]; !exists {
-				framePatterns[key] = &UnaccountedFrame{
-					ID:          f.Frame.ID,
-					Header:      f.Header,
-					DataHex:     dataHex,
-					Occurrences: make(map[string]int),
-				}
-			}
-			framePatterns[key].Occurrences[filename]++
+// Counts returns the occurrences of a pattern per file, in file order
+func (r *ComparisonResult) Counts(p *UnaccountedFrame) []int {
+	counts := make([]int, len(r.Files))
+	for i, f := range r.Files {
+		counts[i] = p.Occurrences[f.Name]
+	}
+	return counts
+}
+
+// ByCategory returns the patterns of one category
+func (r *ComparisonResult) ByCategory(category string) []*UnaccountedFrame {
+	var patterns []*UnaccountedFrame
+	for _, p := range r.Patterns {
+		if p.Category == category {
+			patterns = append(patterns, p)
 		}
 	}
+	return patterns
+}
+
+// UniqueTo returns the patterns found only in the given file
+func (r *ComparisonResult) UniqueTo(filename string) []*UnaccountedFrame {
+	var patterns []*UnaccountedFrame
+	for _, p := range r.ByCategory(categoryUnique) {
+		if _, ok := p.Occurrences[filename]; ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// ComparisonBuilder counts unaccounted frame patterns per file one frame at
+// a time, so that captures need not be held in memory
+type ComparisonBuilder struct {
+	mask     ByteMask
+	files    map[string]*ComparedFile
+	patterns map[string]*UnaccountedFrame // by ID + header + masked data
+}
+
+// NewComparisonBuilder returns a builder that masks bytes with mask
+func NewComparisonBuilder(mask ByteMask) *ComparisonBuilder {
+	return &ComparisonBuilder{
+		mask:     mask,
+		files:    make(map[string]*ComparedFile),
+		patterns: make(map[string]*UnaccountedFrame),
+	}
+}
+
+// AddFile registers a file, so that it is listed even without frames
+func (b *ComparisonBuilder) AddFile(filename string) *ComparedFile {
+	f, ok := b.files[filename]
+	if !ok {
+		f = &ComparedFile{Name: filename}
+		b.files[filename] = f
+	}
+	return f
+}
+
+// Add counts one frame of a file. Masked bytes are replaced by XX so that
+// e.g. counters do not split patterns.
+func (b *ComparisonBuilder) Add(filename string, f *FrameInfo) {
+	file := b.AddFile(filename)
+	file.TotalFrames++
+	if f.IsCBOR || f.IsHeartbeat {
+		return
+	}
+	file.UnaccountedFrames++
+
+	// Create unique key from ID + header + data
+	dataHex := b.mask.Format(f.Frame.ID, f.Frame.Bus, f.Frame.Data)
+	id := frameSourceID(f.Frame)
+	key := fmt.Sprintf("%s:%02X:%s", id, f.Header, dataHex)
+
+	if _, exists := b.patterns[key]; !exists {
+		b.patterns[key] = &UnaccountedFrame{
+			ID:          id,
+			Header:      f.Header,
+			DataHex:     dataHex,
+			Occurrences: make(map[string]int),
+		}
+	}
+	b.patterns[key].Occurrences[filename]++
+}
+
+// Merge adds the files and pattern counts of another builder
+func (b *ComparisonBuilder) Merge(o *ComparisonBuilder) {
+	for name, f := range o.files {
+		file := b.AddFile(name)
+		file.TotalFrames += f.TotalFrames
+		file.UnaccountedFrames += f.UnaccountedFrames
+	}
+	for key, pattern := range o.patterns {
+		existing, ok := b.patterns[key]
+		if !ok {
+			b.patterns[key] = pattern
+			continue
+		}
+		for name, n := range pattern.Occurrences {
+			existing.Occurrences[name] += n
+		}
+	}
+}
+
+// Result categorizes the patterns counted so far
+func (b *ComparisonBuilder) Result() *ComparisonResult {
+	result := &ComparisonResult{Mask: b.mask.String()}
 
 	// Get sorted list of filenames for consistent output
 	var filenames []string
-	for fn := range fileFrames {
+	for fn := range b.files {
 		filenames = append(filenames, fn)
 	}
 	sort.Strings(filenames)
+	for _, fn := range filenames {
+		result.Files = append(result.Files, *b.files[fn])
+	}
 
-	// Display comparison results
+	// Categorize frames
+	for _, pattern := range b.patterns {
+		switch {
+		case len(pattern.Occurrences) == len(filenames):
+			pattern.Category = categoryCommon
+		case len(pattern.Occurrences) == 1:
+			pattern.Category = categoryUnique
+		default:
+			pattern.Category = categoryPartial
+		}
+		result.Patterns = append(result.Patterns, pattern)
+	}
+	sortFramesByID(result.Patterns)
+	return result
+}
+
+// CompareUnaccountedFrames compares unaccounted frames across multiple files,
+// prints the report and returns the result for export
+func CompareUnaccountedFrames(b *ComparisonBuilder) *ComparisonResult {
+	result := b.Result()
+	displayComparison(result)
+	return result
+}
+
+// displayComparison prints a comparison result as a text report
+func displayComparison(result *ComparisonResult) {
 	fmt.Println("\n===================================================")
 	fmt.Println("📊 UNACCOUNTED FRAMES COMPARISON")
 	fmt.Println("===================================================")
 
 	// Show file summary
 	fmt.Println("Files analyzed:")
-	for i, fn := range filenames {
-		totalFrames := len(fileFrames[fn])
-		unaccountedCount := 0
-		for _, f := range fileFrames[fn] {
-			if !f.IsCBOR && !f.IsHeartbeat {
-				unaccountedCount++
-			}
-		}
-		fmt.Printf("  [%d] %s (%d unaccounted / %d total frames)\n", i+1, fn, unaccountedCount, totalFrames)
+	for i, f := range result.Files {
+		fmt.Printf("  [%d] %s (%d unaccounted / %d total frames)\n", i+1, f.Name, f.UnaccountedFrames, f.TotalFrames)
 	}
 
-	// Categorize frames
-	commonToAll := make([]*UnaccountedFrame, 0)
-	uniqueFrames := make(map[string][]*UnaccountedFrame)
-	partialFrames := make([]*UnaccountedFrame, 0)
-
-	for _, pattern := range framePatterns {
-		if len(pattern.Occurrences) == len(filenames) {
-			commonToAll = append(commonToAll, pattern)
-		} else if len(pattern.Occurrences) == 1 {
-			for fn := range pattern.Occurrences {
-				uniqueFrames[fn] = append(uniqueFrames[fn], pattern)
-			}
-		} else {
-			partialFrames = append(partialFrames, pattern)
-		}
-	}
+	commonToAll := result.ByCategory(categoryCommon)
+	partialFrames := result.ByCategory(categoryPartial)
 
 	// Display common frames
 	if len(commonToAll) > 0 {
 		fmt.Printf("\n🔗 Frames Common to ALL Files (%d patterns):\n", len(commonToAll))
 		fmt.Println(strings.Repeat("-", 70))
-		sortFramesByID(commonToAll)
 		for _, p := range commonToAll {
-			counts := make([]string, len(filenames))
-			for i, fn := range filenames {
-				counts[i] = fmt.Sprintf("%d", p.Occurrences[fn])
+			counts := make([]string, len(result.Files))
+			for i, n := range result.Counts(p) {
+				counts[i] = fmt.Sprintf("%d", n)
 			}
 			fmt.Printf("  ID:0x%s Hdr:%02X Data:%s\n", p.ID, p.Header, p.DataHex)
 			fmt.Printf("    Occurrences: [%s]\n", strings.Join(counts, ", "))
@@ -99,15 +207,16 @@ func CompareUnaccountedFrames(fileFrames map[string][]*FrameInfo) {
 	}
 
 	// Display unique frames per file
-	for _, fn := range filenames {
-		frames := uniqueFrames[fn]
+	uniqueCount := 0
+	for _, f := range result.Files {
+		frames := result.UniqueTo(f.Name)
+		uniqueCount += len(frames)
 		if len(frames) > 0 {
-			fmt.Printf("\n🔸 Frames UNIQUE to %s (%d patterns):\n", fn, len(frames))
+			fmt.Printf("\n🔸 Frames UNIQUE to %s (%d patterns):\n", f.Name, len(frames))
 			fmt.Println(strings.Repeat("-", 70))
-			sortFramesByID(frames)
 			for _, p := range frames {
 				fmt.Printf("  ID:0x%s Hdr:%02X Data:%s (count: %d)\n",
-					p.ID, p.Header, p.DataHex, p.Occurrences[fn])
+					p.ID, p.Header, p.DataHex, p.Occurrences[f.Name])
 			}
 		}
 	}
@@ -116,11 +225,10 @@ func CompareUnaccountedFrames(fileFrames map[string][]*FrameInfo) {
 	if len(partialFrames) > 0 {
 		fmt.Printf("\n🔀 Frames in SOME Files (%d patterns):\n", len(partialFrames))
 		fmt.Println(strings.Repeat("-", 70))
-		sortFramesByID(partialFrames)
 		for _, p := range partialFrames {
 			presentIn := make([]string, 0)
-			for i, fn := range filenames {
-				if count, ok := p.Occurrences[fn]; ok {
+			for i, count := range result.Counts(p) {
+				if count > 0 {
 					presentIn = append(presentIn, fmt.Sprintf("[%d]:%d", i+1, count))
 				}
 			}
@@ -132,9 +240,9 @@ func CompareUnaccountedFrames(fileFrames map[string][]*FrameInfo) {
 	// Summary statistics
 	fmt.Println("\n===================================================")
 	fmt.Printf("📈 Summary:\n")
-	fmt.Printf("   Total unique patterns: %d\n", len(framePatterns))
+	fmt.Printf("   Total unique patterns: %d\n", len(result.Patterns))
 	fmt.Printf("   Common to all files: %d\n", len(commonToAll))
-	fmt.Printf("   Unique to one file: %d\n", countUniqueFrames(uniqueFrames))
+	fmt.Printf("   Unique to one file: %d\n", uniqueCount)
 	fmt.Printf("   In some files: %d\n", len(partialFrames))
 	fmt.Println("===================================================")
 }
@@ -150,11 +258,3 @@ func sortFramesByID(frames []*UnaccountedFrame) {
 		return frames[i].ID < frames[j].ID
 	})
 }
-
-func countUniqueFrames(uniqueFrames map[string][]*UnaccountedFrame) int {
-	total := 0
-	for _, frames := range uniqueFrames {
-		total += len(frames)
-	}
-	return total
-}
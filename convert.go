@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// convertFormats maps output format names to their writers. Each writer gets
+// the frames with their (possibly rebased) timestamps and the interface name
+// prefix to which the bus number is appended.
+var convertFormats = map[string]func(w io.Writer, frames []*FrameInfo, iface string) error{
+	"csv":     writeSavvyCSV,
+	"candump": writeCandumpLog,
+	"screen":  writeCandumpScreen,
+	"asc":     writeASC,
+	"pcapng":  writePcapng,
+}
+
+// convertFormatForPath picks an output format from a file extension
+func convertFormatForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".asc":
+		return "asc"
+	case ".pcapng":
+		return "pcapng"
+	case ".txt":
+		return "screen"
+	}
+	return "candump"
+}
+
+// frameDirection returns the frame direction as Rx or Tx, defaulting to Rx
+func frameDirection(frame *CANFrame) string {
+	if strings.EqualFold(frame.Direction, "tx") {
+		return "Tx"
+	}
+	return "Rx"
+}
+
+// busInterface returns the interface name of a bus, e.g. can1
+func busInterface(iface string, bus int) string {
+	return fmt.Sprintf("%s%d", iface, bus)
+}
+
+// writeSavvyCSV writes frames as SavvyCAN CSV with microsecond timestamps
+func writeSavvyCSV(w io.Writer, frames []*FrameInfo, iface string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "Time Stamp,ID,Extended,Dir,Bus,LEN,D1,D2,D3,D4,D5,D6,D7,D8")
+	for _, f := range frames {
+		fr := f.Frame
+		fmt.Fprintf(bw, "%d,%s,%t,%s,%d,%d", int64(math.Round(f.TimestampFloat*1_000_000)),
+			fr.ID, fr.ID.Extended, frameDirection(fr), fr.Bus, len(fr.Data))
+		for i := 0; i < 8; i++ {
+			if i < len(fr.Data) {
+				fmt.Fprintf(bw, ",%02X", fr.Data[i])
+			} else {
+				bw.WriteString(",")
+			}
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// writeCandumpLog writes frames in candump -L log format
+func writeCandumpLog(w io.Writer, frames []*FrameInfo, iface string) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range frames {
+		fmt.Fprintln(bw, formatCandumpLine(f.Frame, f.TimestampFloat, busInterface(iface, f.Frame.Bus)))
+	}
+	return bw.Flush()
+}
+
+// writeCandumpScreen writes frames like candump -ta -x prints them on screen:
+// (timestamp)  interface  RX - -  ID   [len]  bytes
+func writeCandumpScreen(w io.Writer, frames []*FrameInfo, iface string) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range frames {
+		fr := f.Frame
+		bytes := make([]string, len(fr.Data))
+		for i, b := range fr.Data {
+			bytes[i] = fmt.Sprintf("%02X", b)
+		}
+		fmt.Fprintf(bw, "(%.6f)  %s  %s - -  %8s   [%d]  %s\n", f.TimestampFloat,
+			busInterface(iface, fr.Bus), strings.ToUpper(frameDirection(fr)), fr.ID, len(fr.Data), strings.Join(bytes, " "))
+	}
+	return bw.Flush()
+}
+
+// writeASC writes frames as a Vector ASC log. ASC timestamps are relative to
+// the start of measurement, which is taken from the first frame; channels
+// are numbered from 1, so bus 0 is channel 1.
+func writeASC(w io.Writer, frames []*FrameInfo, iface string) error {
+	bw := bufio.NewWriter(w)
+	start := 0.0
+	if len(frames) > 0 {
+		start = frames[0].TimestampFloat
+	}
+	sec, frac := math.Modf(start)
+	date := time.Unix(int64(sec), int64(frac*1e9)).UTC().Format("Mon Jan 2 03:04:05.000 pm 2006")
+
+	fmt.Fprintf(bw, "date %s\n", date)
+	fmt.Fprintln(bw, "base hex  timestamps absolute")
+	fmt.Fprintln(bw, "internal events logged")
+	fmt.Fprintf(bw, "// version 7.0.0\n// exported by vanmoof-canbus %s\n", Version)
+	fmt.Fprintf(bw, "Begin Triggerblock %s\n", date)
+	fmt.Fprintf(bw, "%11.6f Start of measurement\n", 0.0)
+	for _, f := range frames {
+		fr := f.Frame
+		id := fmt.Sprintf("%X", fr.ID.Value)
+		if fr.ID.Extended {
+			id += "x"
+		}
+		bytes := make([]string, len(fr.Data))
+		for i, b := range fr.Data {
+			bytes[i] = fmt.Sprintf("%02X", b)
+		}
+		fmt.Fprintf(bw, "%11.6f %d  %-15s %s   d %d %s\n", f.TimestampFloat-start,
+			fr.Bus+1, id, frameDirection(fr), len(fr.Data), strings.Join(bytes, " "))
+	}
+	fmt.Fprintln(bw, "End TriggerBlock")
+	return bw.Flush()
+}
+
+// pcapng block types, options and the SocketCAN link type used by writePcapng
+const (
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterface      = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngOptIfName      = 2
+	pcapngOptIfTsresol   = 9
+	pcapngOptEpbFlags    = 2
+	linkTypeSocketCAN    = 227
+	socketCANEFFFlag     = 0x80000000
+)
+
+// pcapngBlock appends one block with the given body and options, padding
+// both to 32 bits and framing them with the block length
+func pcapngBlock(out []byte, blockType uint32, body []byte, options [][]byte) []byte {
+	var payload []byte
+	payload = append(payload, body...)
+	for len(payload)%4 != 0 {
+		payload = append(payload, 0)
+	}
+	for _, opt := range options {
+		payload = append(payload, opt...)
+	}
+	if len(options) > 0 {
+		payload = append(payload, 0, 0, 0, 0) // opt_endofopt
+	}
+	length := uint32(12 + len(payload))
+	out = binary.LittleEndian.AppendUint32(out, blockType)
+	out = binary.LittleEndian.AppendUint32(out, length)
+	out = append(out, payload...)
+	return binary.LittleEndian.AppendUint32(out, length)
+}
+
+// pcapngOption encodes one option padded to 32 bits
+func pcapngOption(code uint16, value []byte) []byte {
+	opt := binary.LittleEndian.AppendUint16(nil, code)
+	opt = binary.LittleEndian.AppendUint16(opt, uint16(len(value)))
+	opt = append(opt, value...)
+	for len(opt)%4 != 0 {
+		opt = append(opt, 0)
+	}
+	return opt
+}
+
+// writePcapng writes frames as a pcapng file with one SocketCAN interface per
+// bus, readable by Wireshark. Timestamps have microsecond resolution and the
+// direction is stored in the packet flags.
+func writePcapng(w io.Writer, frames []*FrameInfo, iface string) error {
+	var out []byte
+
+	// Section header: byte order magic, version 1.0, unknown section length
+	shb := binary.LittleEndian.AppendUint32(nil, pcapngByteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, math.MaxUint64)
+	out = pcapngBlock(out, pcapngSectionHeader, shb, nil)
+
+	// One interface description per bus, in order of first appearance
+	interfaces := make(map[int]uint32)
+	for _, f := range frames {
+		if _, ok := interfaces[f.Frame.Bus]; ok {
+			continue
+		}
+		interfaces[f.Frame.Bus] = uint32(len(interfaces))
+		idb := binary.LittleEndian.AppendUint16(nil, linkTypeSocketCAN)
+		idb = binary.LittleEndian.AppendUint16(idb, 0)
+		idb = binary.LittleEndian.AppendUint32(idb, 0) // no snap length limit
+		out = pcapngBlock(out, pcapngInterface, idb, [][]byte{
+			pcapngOption(pcapngOptIfName, []byte(busInterface(iface, f.Frame.Bus))),
+			pcapngOption(pcapngOptIfTsresol, []byte{6}),
+		})
+	}
+
+	for _, f := range frames {
+		fr := f.Frame
+		// SocketCAN pseudo header: big endian ID with flags, length, padding
+		canID := fr.ID.Value
+		if fr.ID.Extended {
+			canID |= socketCANEFFFlag
+		}
+		packet := binary.BigEndian.AppendUint32(nil, canID)
+		packet = append(packet, byte(len(fr.Data)), 0, 0, 0)
+		packet = append(packet, fr.Data...)
+
+		micros := uint64(math.Round(f.TimestampFloat * 1_000_000))
+		epb := binary.LittleEndian.AppendUint32(nil, interfaces[fr.Bus])
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(micros>>32))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(micros))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+		epb = append(epb, packet...)
+
+		direction := uint32(1) // inbound
+		if frameDirection(fr) == "Tx" {
+			direction = 2 // outbound
+		}
+		out = pcapngBlock(out, pcapngEnhancedPacket, epb, [][]byte{
+			pcapngOption(pcapngOptEpbFlags, binary.LittleEndian.AppendUint32(nil, direction)),
+		})
+	}
+
+	_, err := w.Write(out)
+	return err
+}
+
+// runConvert implements the convert subcommand: write a capture in another
+// log format
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default stdout)")
+	format := fs.String("to", "", "output format: csv, candump, screen, asc or pcapng (default from the -o extension, else candump)")
+	iface := fs.String("iface", "can", "interface name prefix for candump, screen and pcapng output; the bus number is appended")
+	rebase := fs.Bool("rebase", false, "shift timestamps so the first frame is at 0")
+	shift := fs.Float64("shift", 0, "seconds to add to every timestamp (after -rebase)")
+	ids := fs.String("ids", "", "comma separated CAN IDs to convert (default all)")
+	excludeIDs := fs.String("exclude-ids", "", "comma separated CAN IDs to skip")
+	start := fs.Float64("start", 0, "start offset in seconds from the first frame")
+	end := fs.Float64("end", 0, "end offset in seconds from the first frame (0 = until the end)")
+	filterExpr := fs.String("filter", "", "only convert frames matching a filter expression")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus convert [flags] [capture file]")
+		fmt.Fprintln(fs.Output(), "Reads stdin when no file is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	if *format == "" {
+		*format = convertFormatForPath(*output)
+	}
+	write, ok := convertFormats[*format]
+	if !ok {
+		fail(fmt.Errorf("unknown format %q (use csv, candump, screen, asc or pcapng)", *format))
+	}
+	include, err := parseIDList(*ids)
+	if err != nil {
+		fail(err)
+	}
+	exclude, err := parseIDList(*excludeIDs)
+	if err != nil {
+		fail(err)
+	}
+	filter, err := ParseFilter(*filterExpr)
+	if err != nil {
+		fail(err)
+	}
+
+	var input io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		defer file.Close()
+		input = file
+	}
+	frames, err := readAllFrames(input)
+	if err != nil {
+		fail(err)
+	}
+	captureFirst := 0.0
+	if len(frames) > 0 {
+		captureFirst = frames[0].TimestampFloat
+	}
+	if filter.Active() {
+		attachMessages(frames)
+		frames = filterFrames(frames, filter)
+	}
+	frames = selectReplayFrames(frames, captureFirst, include, exclude, *start, *end)
+
+	// Rebase on copies so the frames keep their original timestamps
+	offset := *shift
+	if *rebase && len(frames) > 0 {
+		offset -= frames[0].TimestampFloat
+	}
+	if offset != 0 {
+		shifted := make([]*FrameInfo, len(frames))
+		for i, f := range frames {
+			c := *f
+			c.TimestampFloat += offset
+			shifted[i] = &c
+		}
+		frames = shifted
+	}
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			fail(err)
+		}
+		out = file
+	}
+	err = write(out, frames, *iface)
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "📝 Converted %d frames to %s (%s)\n", len(frames), *output, *format)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// mixedIDs is a candump capture with standard and extended IDs on two buses
+const mixedIDs = `(1700000000.000100) can0 123#0102
+(1700000000.250000) can1 14609460#A0A3010202820A14
+(1700000001.000001) can0 7E8#55
+(1700000001.500000) can1 00000456#DEADBEEF
+`
+
+// pcapngTestBlock is one block of a pcapng file
+type pcapngTestBlock struct {
+	Type uint32
+	Body []byte // between the leading and trailing length
+}
+
+// splitPcapng splits a pcapng file into blocks, checking that every block
+// length is a multiple of 4 and repeated after the block
+func splitPcapng(t *testing.T, data []byte) []pcapngTestBlock {
+	t.Helper()
+	var blocks []pcapngTestBlock
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated block: %d bytes left", len(data))
+		}
+		typ := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || length < 12 || int(length) > len(data) {
+			t.Fatalf("block type %#x: bad length %d", typ, length)
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4:]); trailer != length {
+			t.Fatalf("block type %#x: length %d, trailing length %d", typ, length, trailer)
+		}
+		blocks = append(blocks, pcapngTestBlock{Type: typ, Body: data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+// pcapngTestOptions returns the options following a block's fixed fields
+func pcapngTestOptions(t *testing.T, opts []byte) map[uint16][]byte {
+	t.Helper()
+	options := make(map[uint16][]byte)
+	for len(opts) >= 4 {
+		code := binary.LittleEndian.Uint16(opts)
+		n := int(binary.LittleEndian.Uint16(opts[2:]))
+		if code == 0 {
+			return options
+		}
+		padded := (n + 3) &^ 3
+		if 4+padded > len(opts) {
+			t.Fatalf("option %d overruns the block", code)
+		}
+		options[code] = opts[4 : 4+n]
+		opts = opts[4+padded:]
+	}
+	t.Fatal("options not terminated by opt_endofopt")
+	return nil
+}
+
+func TestWritePcapngRoundTrip(t *testing.T) {
+	frames, err := readAllFrames(strings.NewReader(mixedIDs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames[3].Frame.Direction = "Tx"
+
+	var buf bytes.Buffer
+	if err := writePcapng(&buf, frames, "can"); err != nil {
+		t.Fatal(err)
+	}
+	blocks := splitPcapng(t, buf.Bytes())
+	if len(blocks) != 1+2+len(frames) {
+		t.Fatalf("got %d blocks, want a section header, 2 interfaces and %d packets", len(blocks), len(frames))
+	}
+
+	shb := blocks[0]
+	if shb.Type != pcapngSectionHeader || binary.LittleEndian.Uint32(shb.Body) != pcapngByteOrderMagic {
+		t.Fatalf("section header: type %#x, body %X", shb.Type, shb.Body)
+	}
+	if major, minor := binary.LittleEndian.Uint16(shb.Body[4:]), binary.LittleEndian.Uint16(shb.Body[6:]); major != 1 || minor != 0 {
+		t.Errorf("version %d.%d, want 1.0", major, minor)
+	}
+
+	// Interfaces in order of first appearance: can0, then can1
+	var names []string
+	for i, idb := range blocks[1:3] {
+		if idb.Type != pcapngInterface {
+			t.Fatalf("block %d: type %#x, want an interface description", i+1, idb.Type)
+		}
+		if lt := binary.LittleEndian.Uint16(idb.Body); lt != 227 {
+			t.Errorf("interface %d: link type %d, want 227 (SocketCAN)", i, lt)
+		}
+		opts := pcapngTestOptions(t, idb.Body[8:])
+		if res := opts[pcapngOptIfTsresol]; !bytes.Equal(res, []byte{6}) {
+			t.Errorf("interface %d: if_tsresol %v, want microseconds", i, res)
+		}
+		names = append(names, string(opts[pcapngOptIfName]))
+	}
+	if strings.Join(names, ",") != "can0,can1" {
+		t.Errorf("interface names %q, want can0,can1", names)
+	}
+
+	// Rebuild the candump log from the packets
+	var lines []string
+	for i, epb := range blocks[3:] {
+		if epb.Type != pcapngEnhancedPacket {
+			t.Fatalf("packet %d: type %#x", i, epb.Type)
+		}
+		body := epb.Body
+		ifIndex := binary.LittleEndian.Uint32(body)
+		micros := uint64(binary.LittleEndian.Uint32(body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:]))
+		captured := binary.LittleEndian.Uint32(body[12:])
+		if original := binary.LittleEndian.Uint32(body[16:]); captured != original {
+			t.Errorf("packet %d: captured %d of %d bytes", i, captured, original)
+		}
+		packet := body[20 : 20+captured]
+		rawID := binary.BigEndian.Uint32(packet)
+		length := int(packet[4])
+		if int(captured) != 8+length {
+			t.Errorf("packet %d: %d bytes for %d data bytes", i, captured, length)
+		}
+
+		id := CANID{Value: rawID &^ socketCANEFFFlag, Extended: rawID&socketCANEFFFlag != 0}
+		if want := frames[i].Frame.ID; id != want {
+			t.Errorf("packet %d: ID %v (raw %08X), want %v", i, id, rawID, want)
+		}
+
+		padded := (int(captured) + 3) &^ 3
+		opts := pcapngTestOptions(t, body[20+padded:])
+		wantFlags := uint32(1)
+		if i == 3 {
+			wantFlags = 2
+		}
+		if flags := binary.LittleEndian.Uint32(opts[pcapngOptEpbFlags]); flags != wantFlags {
+			t.Errorf("packet %d: direction flags %d, want %d", i, flags, wantFlags)
+		}
+
+		frame := &CANFrame{ID: id, Data: packet[8 : 8+length]}
+		lines = append(lines, formatCandumpLine(frame, float64(micros)/1e6, names[ifIndex]))
+	}
+	if got := strings.Join(lines, "\n") + "\n"; got != mixedIDs {
+		t.Errorf("round trip:\n%s\nwant:\n%s", got, mixedIDs)
+	}
+}
+
+func TestWriteASC(t *testing.T) {
+	frames, err := readAllFrames(strings.NewReader(mixedIDs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeASC(&buf, frames, "can"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !strings.HasPrefix(lines[0], "date ") || lines[len(lines)-1] != "End TriggerBlock" {
+		t.Fatalf("unexpected framing:\n%s", buf.String())
+	}
+	want := []string{
+		"   0.000000 1  123             Rx   d 2 01 02",
+		"   0.249900 2  14609460x       Rx   d 8 A0 A3 01 02 02 82 0A 14",
+		"   0.999901 1  7E8             Rx   d 1 55",
+		"   1.499900 2  456x            Rx   d 4 DE AD BE EF",
+	}
+	got := lines[len(lines)-1-len(want) : len(lines)-1]
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: %q, want %q", i, got[i], want[i])
+		}
+	}
+}
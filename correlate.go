@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits for correlation analysis
+const (
+	correlateTopN      = 10     // candidates listed per field or marker label
+	correlateMaxPoints = 200000 // resampled timeline points before the step is widened
+)
+
+// TimeSeries is a numeric value over time, either a decoded CBOR field or a
+// byte/bit of unaccounted frames
+type TimeSeries struct {
+	Name   string
+	Times  []float64
+	Values []float64
+}
+
+// Marker is a user-supplied event on the capture timeline
+type Marker struct {
+	Time  float64
+	Label string
+}
+
+// CorrelationCandidate is a series ranked against a CBOR field or marker label
+type CorrelationCandidate struct {
+	Series *TimeSeries
+	Score  float64 // Pearson r for fields, hit ratio above baseline for markers
+	Hits   int     // markers with a change inside the window
+	Ratio  float64 // share of the timeline within the window of a change
+}
+
+// readMarkers reads a marker file with one "timestamp label" per line.
+// Timestamps use the capture clock; a leading "+" makes them relative to
+// start. Blank lines and lines starting with # are ignored.
+func readMarkers(path string, start float64) ([]Marker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var markers []Marker
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tsText, label, _ := strings.Cut(strings.Replace(line, ",", " ", 1), " ")
+		relative := strings.HasPrefix(tsText, "+")
+		ts, err := strconv.ParseFloat(strings.TrimPrefix(tsText, "+"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid timestamp %q", path, lineNum, tsText)
+		}
+		if relative {
+			ts += start
+		}
+		label = strings.TrimSpace(label)
+		if label == "" {
+			label = "marker"
+		}
+		markers = append(markers, Marker{Time: ts, Label: label})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].Time < markers[j].Time })
+	return markers, nil
+}
+
+// fieldSeries builds one series per numeric field path of the decoded CBOR
+// messages of each CAN ID. Fields that never change are left out.
+func fieldSeries(frames []*FrameInfo) []*TimeSeries {
+	byName := make(map[string]*TimeSeries)
+	var names []string
+	for _, msg := range captureMessages(frames) {
+		walkFields(msg.Item, "", func(path string, value interface{}) {
+			v, ok := fieldNumber(value)
+			if !ok {
+				return
+			}
+			name := fmt.Sprintf("CBOR 0x%s %s", msg.SourceID(), path)
+			s, ok := byName[name]
+			if !ok {
+				s = &TimeSeries{Name: name}
+				byName[name] = s
+				names = append(names, name)
+			}
+			s.Times = append(s.Times, msg.Timestamp)
+			s.Values = append(s.Values, v)
+		})
+	}
+
+	var series []*TimeSeries
+	for _, name := range names {
+		if s := byName[name]; seriesVaries(s) {
+			series = append(series, s)
+		}
+	}
+	return series
+}
+
+// rawSeries builds one series per byte and per bit of the unaccounted frames
+// of each CAN ID and header. Bytes and bits that never change are left out.
+func rawSeries(frames []*FrameInfo) []*TimeSeries {
+	keys, grouped := groupFramesByID(unaccountedFrames(frames), true)
+	var series []*TimeSeries
+	for _, key := range keys {
+		group := grouped[key]
+		prefix := fmt.Sprintf("0x%s Hdr:%02X", frameSourceID(group[0].Frame), group[0].Header)
+		width := 0
+		for _, f := range group {
+			width = max(width, len(f.Frame.Data))
+		}
+		// Byte 0 is the header and part of the group key
+		for i := 1; i < width; i++ {
+			byteSeries := &TimeSeries{Name: fmt.Sprintf("%s byte %d", prefix, i)}
+			var bitSeries [8]*TimeSeries
+			for bit := range bitSeries {
+				bitSeries[bit] = &TimeSeries{Name: fmt.Sprintf("%s byte %d bit %d", prefix, i, bit)}
+			}
+			for _, f := range group {
+				if i >= len(f.Frame.Data) {
+					continue
+				}
+				b := f.Frame.Data[i]
+				byteSeries.Times = append(byteSeries.Times, f.TimestampFloat)
+				byteSeries.Values = append(byteSeries.Values, float64(b))
+				for bit, s := range bitSeries {
+					s.Times = append(s.Times, f.TimestampFloat)
+					s.Values = append(s.Values, float64(b>>bit&1))
+				}
+			}
+			if !seriesVaries(byteSeries) {
+				continue
+			}
+			series = append(series, byteSeries)
+			for _, s := range bitSeries {
+				if seriesVaries(s) {
+					series = append(series, s)
+				}
+			}
+		}
+	}
+	return series
+}
+
+// seriesVaries reports whether a series takes more than one value
+func seriesVaries(s *TimeSeries) bool {
+	for _, v := range s.Values {
+		if v != s.Values[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// resample samples a series at n points from start in steps of step, holding
+// the last value. Points before the first sample take the first value.
+func resample(s *TimeSeries, start, step float64, n int) []float64 {
+	out := make([]float64, n)
+	j := 0
+	for i := range out {
+		t := start + float64(i)*step
+		for j+1 < len(s.Times) && s.Times[j+1] <= t {
+			j++
+		}
+		out[i] = s.Values[j]
+	}
+	return out
+}
+
+// changeTimes returns the timestamps at which a series changes value
+func changeTimes(s *TimeSeries) []float64 {
+	var times []float64
+	for i := 1; i < len(s.Values); i++ {
+		if s.Values[i] != s.Values[i-1] {
+			times = append(times, s.Times[i])
+		}
+	}
+	return times
+}
+
+// rankByCorrelation ranks candidates by the absolute Pearson correlation of
+// their resampled values with the target series
+func rankByCorrelation(target *TimeSeries, candidates []*TimeSeries, start, step float64, n int) []CorrelationCandidate {
+	targetValues := resample(target, start, step, n)
+	var ranked []CorrelationCandidate
+	for _, c := range candidates {
+		r := pearsonFloat(targetValues, resample(c, start, step, n))
+		if r == 0 || math.IsNaN(r) {
+			continue
+		}
+		ranked = append(ranked, CorrelationCandidate{Series: c, Score: r})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return math.Abs(ranked[i].Score) > math.Abs(ranked[j].Score)
+	})
+	return ranked
+}
+
+// rankByMarkers ranks candidates by how many markers have a change of the
+// candidate within ±window, relative to the share of the capture that lies
+// within ±window of any of its changes (the hit ratio expected by chance)
+func rankByMarkers(markers []Marker, candidates []*TimeSeries, window, start, end float64) []CorrelationCandidate {
+	duration := end - start
+	var ranked []CorrelationCandidate
+	for _, c := range candidates {
+		changes := changeTimes(c)
+		if len(changes) == 0 {
+			continue
+		}
+		hits := 0
+		for _, m := range markers {
+			// changes is sorted, find the first change at or after m.Time-window
+			idx := sort.SearchFloat64s(changes, m.Time-window)
+			if idx < len(changes) && changes[idx] <= m.Time+window {
+				hits++
+			}
+		}
+		baseline := 1.0
+		if duration > 0 {
+			baseline = math.Min(1, coveredTime(changes, window, start, end)/duration)
+		}
+		// Changes that hit no more often than chance say nothing about the markers
+		score := float64(hits)/float64(len(markers)) - baseline
+		if score <= 0 {
+			continue
+		}
+		ranked = append(ranked, CorrelationCandidate{Series: c, Score: score, Hits: hits, Ratio: baseline})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked
+}
+
+// coveredTime returns the length of the union of ±window intervals around
+// the sorted times, clipped to [start, end]
+func coveredTime(times []float64, window, start, end float64) float64 {
+	total := 0.0
+	curLo, curHi := math.Inf(-1), math.Inf(-1)
+	for _, t := range times {
+		lo, hi := math.Max(start, t-window), math.Min(end, t+window)
+		if lo > curHi {
+			if curHi > curLo {
+				total += curHi - curLo
+			}
+			curLo, curHi = lo, hi
+		} else if hi > curHi {
+			curHi = hi
+		}
+	}
+	if curHi > curLo {
+		total += curHi - curLo
+	}
+	return total
+}
+
+// displayCorrelations resamples decoded CBOR fields and raw bytes/bits on a
+// common timeline and prints, per field, the raw candidates that correlate
+// best, and per marker label the series whose changes coincide with markers
+func displayCorrelations(frames []*FrameInfo, markers []Marker, fieldPath string, step, window float64) {
+	fields := fieldSeries(frames)
+	if fieldPath != "" {
+		var selected []*TimeSeries
+		for _, s := range fields {
+			if strings.HasSuffix(s.Name, " "+fieldPath) {
+				selected = append(selected, s)
+			}
+		}
+		fields = selected
+	}
+	raws := rawSeries(frames)
+
+	start, end := math.Inf(1), math.Inf(-1)
+	for _, f := range frames {
+		start = math.Min(start, f.TimestampFloat)
+		end = math.Max(end, f.TimestampFloat)
+	}
+	n := int((end-start)/step) + 1
+	if n > correlateMaxPoints {
+		step = (end - start) / float64(correlateMaxPoints-1)
+		n = correlateMaxPoints
+	}
+
+	fmt.Println("\n===================================================")
+	fmt.Println("🔗 CORRELATION ANALYSIS")
+	fmt.Println("===================================================")
+	fmt.Printf("Timeline: %.3f s resampled every %.3f s (%d points)\n", end-start, step, n)
+	fmt.Printf("Series: %d decoded CBOR fields, %d raw bytes/bits, %d markers\n", len(fields), len(raws), len(markers))
+
+	if n < 3 {
+		fmt.Println("\nCapture too short to correlate")
+		fmt.Println("\n===================================================")
+		return
+	}
+
+	for _, field := range fields {
+		fmt.Printf("\n📈 %s (%d samples)\n", field.Name, len(field.Values))
+		fmt.Println(strings.Repeat("-", 60))
+		ranked := rankByCorrelation(field, raws, start, step, n)
+		if len(ranked) == 0 {
+			fmt.Println("  No correlated raw bytes")
+		}
+		for i, c := range ranked[:min(len(ranked), correlateTopN)] {
+			fmt.Printf("  %2d. r=%+.3f  %s\n", i+1, c.Score, c.Series.Name)
+		}
+	}
+
+	// Markers are grouped by label, keeping the order labels first appear in
+	var labels []string
+	byLabel := make(map[string][]Marker)
+	for _, m := range markers {
+		if _, ok := byLabel[m.Label]; !ok {
+			labels = append(labels, m.Label)
+		}
+		byLabel[m.Label] = append(byLabel[m.Label], m)
+	}
+	candidates := append(append([]*TimeSeries{}, raws...), fields...)
+	for _, label := range labels {
+		group := byLabel[label]
+		fmt.Printf("\n📍 Marker %q (%d markers, window ±%.2f s)\n", label, len(group), window)
+		fmt.Println(strings.Repeat("-", 60))
+		ranked := rankByMarkers(group, candidates, window, start, end)
+		if len(ranked) == 0 {
+			fmt.Println("  No changes near these markers beyond chance")
+		}
+		for i, c := range ranked[:min(len(ranked), correlateTopN)] {
+			fmt.Printf("  %2d. %d/%d hits (chance %3.0f%%)  %s\n",
+				i+1, c.Hits, len(group), c.Ratio*100, c.Series.Name)
+		}
+	}
+
+	fmt.Println("\n===================================================")
+}
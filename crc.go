@@ -0,0 +1,152 @@
+package main
+
+import (
+	"hash/adler32"
+	"hash/crc32"
+)
+
+// checksumAlgorithm is a one-byte checksum that signal discovery tests
+// against candidate checksum bytes
+type checksumAlgorithm struct {
+	Name string
+	Sum  func(data []byte) byte
+}
+
+// crc8Params describes a CRC-8 variant in the usual Rocksoft model
+type crc8Params struct {
+	Name   string
+	Poly   byte
+	Init   byte
+	RefIn  bool
+	RefOut bool
+	XorOut byte
+}
+
+// crc8Catalog lists common CRC-8 variants found in automotive and embedded protocols
+var crc8Catalog = []crc8Params{
+	{Name: "CRC-8/SMBUS", Poly: 0x07},
+	{Name: "CRC-8/ITU", Poly: 0x07, XorOut: 0x55},
+	{Name: "CRC-8/ROHC", Poly: 0x07, Init: 0xFF, RefIn: true, RefOut: true},
+	{Name: "CRC-8/SAE-J1850", Poly: 0x1D, Init: 0xFF, XorOut: 0xFF},
+	{Name: "CRC-8/SAE-J1850-ZERO", Poly: 0x1D},
+	{Name: "CRC-8/I-CODE", Poly: 0x1D, Init: 0xFD},
+	{Name: "CRC-8/AUTOSAR", Poly: 0x2F, Init: 0xFF, XorOut: 0xFF},
+	{Name: "CRC-8/MAXIM-DOW", Poly: 0x31, RefIn: true, RefOut: true},
+	{Name: "CRC-8/NRSC-5", Poly: 0x31, Init: 0xFF},
+	{Name: "CRC-8/CDMA2000", Poly: 0x9B, Init: 0xFF},
+	{Name: "CRC-8/WCDMA", Poly: 0x9B, RefIn: true, RefOut: true},
+	{Name: "CRC-8/DVB-S2", Poly: 0xD5},
+}
+
+// crc8 computes a CRC-8 over data
+func crc8(p crc8Params, data []byte) byte {
+	crc := p.Init
+	for _, b := range data {
+		if p.RefIn {
+			b = reverseBits(b)
+		}
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ p.Poly
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	if p.RefOut {
+		crc = reverseBits(crc)
+	}
+	return crc ^ p.XorOut
+}
+
+func reverseBits(b byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = r<<1 | b&1
+		b >>= 1
+	}
+	return r
+}
+
+// checksumAlgorithms returns the simple checksums followed by the CRC-8 catalog
+func checksumAlgorithms() []checksumAlgorithm {
+	algs := []checksumAlgorithm{
+		{Name: "XOR", Sum: func(data []byte) byte {
+			var x byte
+			for _, b := range data {
+				x ^= b
+			}
+			return x
+		}},
+		{Name: "SUM8", Sum: func(data []byte) byte {
+			var s byte
+			for _, b := range data {
+				s += b
+			}
+			return s
+		}},
+		{Name: "SUM8 two's complement", Sum: func(data []byte) byte {
+			var s byte
+			for _, b := range data {
+				s += b
+			}
+			return -s
+		}},
+	}
+	for _, p := range crc8Catalog {
+		p := p
+		algs = append(algs, checksumAlgorithm{Name: p.Name, Sum: func(data []byte) byte { return crc8(p, data) }})
+	}
+	return algs
+}
+
+// imageChecksum is a checksum over a whole transferred image, 16 or 32 bits
+// wide, that transfer reconstruction looks for in the protocol
+type imageChecksum struct {
+	Name string
+	Size int // bytes
+	Sum  func(data []byte) uint32
+}
+
+// imageChecksums returns the checksums commonly used by bootloaders
+func imageChecksums() []imageChecksum {
+	castagnoli := crc32.MakeTable(crc32.Castagnoli)
+	return []imageChecksum{
+		{Name: "CRC-32", Size: 4, Sum: crc32.ChecksumIEEE},
+		{Name: "CRC-32C", Size: 4, Sum: func(data []byte) uint32 { return crc32.Checksum(data, castagnoli) }},
+		{Name: "Adler-32", Size: 4, Sum: adler32.Checksum},
+		{Name: "SUM32", Size: 4, Sum: func(data []byte) uint32 {
+			var s uint32
+			for _, b := range data {
+				s += uint32(b)
+			}
+			return s
+		}},
+		{Name: "CRC-16/CCITT-FALSE", Size: 2, Sum: func(data []byte) uint32 { return uint32(crc16CCITT(data, 0xFFFF)) }},
+		{Name: "CRC-16/XMODEM", Size: 2, Sum: func(data []byte) uint32 { return uint32(crc16CCITT(data, 0)) }},
+		{Name: "SUM16", Size: 2, Sum: func(data []byte) uint32 {
+			var s uint16
+			for _, b := range data {
+				s += uint16(b)
+			}
+			return uint32(s)
+		}},
+	}
+}
+
+// crc16CCITT computes a CRC-16 with polynomial 0x1021, unreflected
+func crc16CCITT(data []byte, init uint16) uint16 {
+	crc := init
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Tables of the SQLite export. Frames and messages are numbered from 1 in
+// capture order; message_frames links each message to the frames it was
+// reassembled from.
+const (
+	sqlFramesTable = `CREATE TABLE frames (
+  id INTEGER PRIMARY KEY,
+  seq INTEGER,
+  timestamp REAL,
+  bus INTEGER,
+  can_id INTEGER,
+  can_id_hex TEXT,
+  extended INTEGER,
+  dir TEXT,
+  header INTEGER,
+  data BLOB,
+  data_hex TEXT,
+  class TEXT
+)`
+	sqlMessagesTable = `CREATE TABLE messages (
+  id INTEGER PRIMARY KEY,
+  timestamp REAL,
+  bus INTEGER,
+  can_id INTEGER,
+  can_id_hex TEXT,
+  raw BLOB,
+  json TEXT,
+  first_frame INTEGER,
+  last_frame INTEGER,
+  frame_count INTEGER
+)`
+	sqlMessageFramesTable = `CREATE TABLE message_frames (
+  message_id INTEGER,
+  frame_id INTEGER,
+  position INTEGER
+)`
+)
+
+// cborJSON converts a decoded CBOR item to a value encoding/json can write:
+// map keys become strings as in field paths, byte strings become hex and
+// numbers JSON cannot hold become strings
+func cborJSON(item interface{}) interface{} {
+	switch v := item.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[formatFieldKey(k)] = cborJSON(val)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, elem := range v {
+			a[i] = cborJSON(elem)
+		}
+		return a
+	case []byte:
+		return fmt.Sprintf("%X", v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return formatFieldValue(v)
+		}
+		return v
+	case float32:
+		return cborJSON(float64(v))
+	case nil, bool, string, int64, uint64:
+		return v
+	}
+	return formatFieldValue(item)
+}
+
+// captureDatabase writes frames, decoded messages and their links to a new
+// SQLite database as a capture is streamed. Frames are numbered in the order
+// they are released by the frameGate, so the frames of a CBOR message are
+// written together once it is complete; seq keeps the capture order.
+type captureDatabase struct {
+	db                      *sqliteWriter
+	frames, messages, links *sqliteTable
+	nFrames, nMessages      int64
+	nLinks                  int64
+
+	// frameIDs holds the rowids of written frames whose messages are not
+	// written yet, 0 for frames outside the selection
+	frameIDs map[*FrameInfo]int64
+	// pending are completed messages waiting for frames the gate still holds
+	pending []*Message
+}
+
+// newCaptureDatabase creates the database file, replacing an existing one
+func newCaptureDatabase(path string) (*captureDatabase, error) {
+	db, err := newSQLiteWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return &captureDatabase{
+		db:       db,
+		frames:   db.Table("frames", sqlFramesTable),
+		messages: db.Table("messages", sqlMessagesTable),
+		links:    db.Table("message_frames", sqlMessageFramesTable),
+		frameIDs: make(map[*FrameInfo]int64),
+	}, nil
+}
+
+// AddFrame writes a frame released by the gate if it is selected and
+// remembers its rowid until the messages it belongs to are written
+func (d *captureDatabase) AddFrame(f *FrameInfo, selected bool) error {
+	var id int64
+	if selected {
+		d.nFrames++
+		id = d.nFrames
+		fr := f.Frame
+		err := d.frames.Insert(id, nil, int64(f.SequenceNum), f.TimestampFloat, int64(fr.Bus),
+			int64(fr.ID.Value), fr.ID.String(), sqliteBool(fr.ID.Extended), frameDirection(fr),
+			int64(f.Header), fr.Data, fmt.Sprintf("%X", fr.Data), f.FrameType)
+		if err != nil {
+			return err
+		}
+	}
+	// Messages complete before their frames are released
+	if f.Message != nil {
+		d.frameIDs[f] = id
+	}
+	return nil
+}
+
+// AddMessage queues a completed message; it is written by Flush once all of
+// its frames have been released
+func (d *captureDatabase) AddMessage(msg *Message) {
+	d.pending = append(d.pending, msg)
+}
+
+// Flush writes the pending messages whose frames have all been released.
+// Messages without a selected frame are left out.
+func (d *captureDatabase) Flush() error {
+	waiting := d.pending[:0]
+	for _, msg := range d.pending {
+		ready := true
+		for _, f := range msg.Frames {
+			if _, ok := d.frameIDs[f]; !ok {
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			waiting = append(waiting, msg)
+			continue
+		}
+		if err := d.writeMessage(msg); err != nil {
+			return err
+		}
+	}
+	d.pending = waiting
+	return nil
+}
+
+// writeMessage writes a message with its links and forgets the frames that
+// belong to no later message
+func (d *captureDatabase) writeMessage(msg *Message) error {
+	var first, last interface{}
+	var linked []int64
+	for _, f := range msg.Frames {
+		if id := d.frameIDs[f]; id != 0 {
+			if first == nil {
+				first = id
+			}
+			last = id
+			linked = append(linked, id)
+		}
+	}
+	defer func() {
+		// A frame shared with the next message points to that message
+		for _, f := range msg.Frames {
+			if f.Message == msg {
+				delete(d.frameIDs, f)
+			}
+		}
+	}()
+	if linked == nil {
+		return nil
+	}
+
+	text, err := json.Marshal(cborJSON(msg.Item))
+	if err != nil {
+		return err
+	}
+	d.nMessages++
+	err = d.messages.Insert(d.nMessages, nil, msg.Timestamp, int64(msg.Bus), int64(msg.ID.Value), msg.ID.String(),
+		msg.Raw, string(text), first, last, int64(msg.FrameCount))
+	if err != nil {
+		return err
+	}
+	for pos, f := range msg.Frames {
+		id := d.frameIDs[f]
+		if id == 0 {
+			continue
+		}
+		d.nLinks++
+		if err := d.links.Insert(d.nLinks, d.nMessages, id, int64(pos)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the messages still pending and finishes the file
+func (d *captureDatabase) Close() error {
+	err := d.Flush()
+	if closeErr := d.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeCaptureDatabase streams a capture into a new SQLite database and
+// returns the number of frames and messages written
+func writeCaptureDatabase(path string, r io.Reader, filter *Filter) (int64, int64, error) {
+	d, err := newCaptureDatabase(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	reader := NewFrameReader(r)
+	var gate frameGate
+	stream := filteredStream{filter: filter}
+	var writeErr error
+	release := func(f *FrameInfo) {
+		if writeErr == nil {
+			writeErr = d.AddFrame(f, stream.Match(f))
+		}
+	}
+	for writeErr == nil {
+		info, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeErr = err
+			break
+		}
+		stream.Observe(info)
+		if msg := gate.Add(info, release); msg != nil {
+			d.AddMessage(msg)
+		}
+		if writeErr == nil {
+			writeErr = d.Flush()
+		}
+	}
+	if writeErr == nil {
+		gate.Flush(release)
+	}
+	if err := d.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if n := reader.SkippedLines(); n > 0 {
+		fmt.Fprintf(os.Stderr, "⚠️  skipped %d lines longer than %d bytes\n", n, maxLineLength)
+	}
+	return d.nFrames, d.nMessages, writeErr
+}
+
+// sqliteBool stores a boolean as SQLite does, 0 or 1
+func sqliteBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// runSQLite implements the sqlite subcommand: write a capture to an SQLite
+// database for ad-hoc queries
+func runSQLite(args []string) {
+	fs := flag.NewFlagSet("sqlite", flag.ExitOnError)
+	output := fs.String("o", "", "database file to create (replaced if it exists)")
+	filterExpr := fs.String("filter", "", "only write frames matching a filter expression")
+	progress := fs.Bool("progress", false, "report how much input has been read on stderr")
+	busNameSpec := fs.String("bus-names", "", "names for bus numbers, e.g. '0=main,1=battery'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus sqlite -o capture.db [flags] [capture file]")
+		fmt.Fprintln(fs.Output(), "Reads stdin when no file is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if *output == "" {
+		fail(fmt.Errorf("-o is required"))
+	}
+	names, err := parseBusNames(*busNameSpec)
+	if err != nil {
+		fail(err)
+	}
+	busNames = names
+	filter, err := ParseFilter(*filterExpr)
+	if err != nil {
+		fail(err)
+	}
+
+	var input io.Reader = os.Stdin
+	name := "stdin"
+	if fs.NArg() > 0 {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		defer file.Close()
+		input, name = file, fs.Arg(0)
+	}
+	var pr *progressReader
+	if *progress {
+		pr = newProgressReader(input, name)
+		input = pr
+	}
+
+	nFrames, nMessages, err := writeCaptureDatabase(*output, input, filter)
+	if pr != nil {
+		pr.Done()
+	}
+	if err != nil {
+		fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "📝 Wrote %d frames and %d CBOR messages to %s\n", nFrames, nMessages, *output)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// diagMap is a CBOR map that keeps the key order it was written in, so an
+// encoded message matches the byte layout of the notation it came from
+type diagMap []diagPair
+
+// diagPair is a single key/value entry of a diagMap
+type diagPair struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// MarshalCBOR encodes the map in insertion order
+func (m diagMap) MarshalCBOR() ([]byte, error) {
+	buf := appendCBORHead(nil, 5, uint64(len(m)))
+	for _, p := range m {
+		k, err := cborEncMode.Marshal(p.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := cborEncMode.Marshal(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, k...)
+		buf = append(buf, v...)
+	}
+	return buf, nil
+}
+
+// cborEncMode encodes values using the preferred (shortest) serialization
+var cborEncMode = func() cbor.EncMode {
+	em, err := cbor.EncOptions{ShortestFloat: cbor.ShortestFloat16}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
+
+// appendCBORHead appends a CBOR initial byte and argument for the given major type
+func appendCBORHead(buf []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(buf, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(buf, m|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(buf, m|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(buf, m|27, byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// diagParser parses CBOR diagnostic notation (RFC 8949 section 8).
+// JSON is a subset of the notation, so JSON documents are accepted as well.
+type diagParser struct {
+	src string
+	pos int
+}
+
+// parseDiagnostic parses every item in src and returns them in order
+func parseDiagnostic(src string) ([]interface{}, error) {
+	p := &diagParser{src: src}
+	var items []interface{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return items, nil
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		// Items may optionally be separated by commas
+		p.skipSpace()
+		if p.peek() == ',' {
+			p.pos++
+		}
+	}
+}
+
+func (p *diagParser) errorf(format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.src[:p.pos], "\n")
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *diagParser) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+// skipSpace skips whitespace and /comments/
+func (p *diagParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '/':
+			end := strings.IndexByte(p.src[p.pos+1:], '/')
+			if end == -1 {
+				p.pos = len(p.src)
+				return
+			}
+			p.pos += end + 2
+		default:
+			return
+		}
+	}
+}
+
+func (p *diagParser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *diagParser) parseItem() (interface{}, error) {
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end of input")
+	case c == '{':
+		return p.parseMap()
+	case c == '[':
+		return p.parseArray()
+	case c == '"':
+		return p.parseText()
+	case c == '\'':
+		s, err := p.parseQuoted('\'')
+		return []byte(s), err
+	case c == '-' || c == '+' || c >= '0' && c <= '9':
+		return p.parseNumber()
+	}
+
+	word := p.parseWord()
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "undefined":
+		return cbor.SimpleValue(23), nil
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "h", "b64":
+		if p.peek() != '\'' {
+			return nil, p.errorf("expected ' after %s", word)
+		}
+		s, err := p.parseQuoted('\'')
+		if err != nil {
+			return nil, err
+		}
+		return decodeDiagBytes(word, s, p)
+	case "simple":
+		n, err := p.parseParenInt()
+		return cbor.SimpleValue(n), err
+	}
+	return nil, p.errorf("unexpected token %q", word)
+}
+
+func (p *diagParser) parseWord() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *diagParser) parseParenInt() (uint8, error) {
+	if err := p.expect('('); err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	start := p.pos
+	p.parseWord()
+	n, err := strconv.ParseUint(p.src[start:p.pos], 0, 8)
+	if err != nil {
+		return 0, p.errorf("invalid simple value: %v", err)
+	}
+	return uint8(n), p.expect(')')
+}
+
+func decodeDiagBytes(prefix, s string, p *diagParser) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	if prefix == "h" {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, p.errorf("invalid hex byte string: %v", err)
+		}
+		return b, nil
+	}
+	s = strings.TrimRight(s, "=")
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		b, err = base64.RawURLEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, p.errorf("invalid base64 byte string: %v", err)
+	}
+	return b, nil
+}
+
+func (p *diagParser) parseNumber() (interface{}, error) {
+	start := p.pos
+	if c := p.peek(); c == '-' || c == '+' {
+		p.pos++
+	}
+	if strings.HasPrefix(p.src[p.pos:], "Infinity") {
+		p.pos += len("Infinity")
+		if p.src[start] == '-' {
+			return math.Inf(-1), nil
+		}
+		return math.Inf(1), nil
+	}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' ||
+			c == 'x' || c == 'X' || c == '.' || c == '_' ||
+			(c == '+' || c == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E') {
+			p.pos++
+			continue
+		}
+		break
+	}
+	text := p.src[start:p.pos]
+
+	// A number followed by '(' is a tag: 1(1363896240)
+	p.skipSpace()
+	if p.peek() == '(' {
+		tag, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			return nil, p.errorf("invalid tag number %q", text)
+		}
+		p.pos++
+		content, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return cbor.Tag{Number: tag, Content: content}, nil
+	}
+
+	isHex := strings.HasPrefix(strings.TrimLeft(text, "+-"), "0x")
+	if !isHex && strings.ContainsAny(text, ".eE") {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", text)
+		}
+		return f, nil
+	}
+	if strings.HasPrefix(text, "-") {
+		n, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %q", text)
+		}
+		return n, nil
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(text, "+"), 0, 64)
+	if err != nil {
+		return nil, p.errorf("invalid integer %q", text)
+	}
+	return n, nil
+}
+
+func (p *diagParser) parseText() (string, error) {
+	return p.parseQuoted('"')
+}
+
+// parseQuoted reads a quoted string using JSON escape rules
+func (p *diagParser) parseQuoted(quote byte) (string, error) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\\':
+			if p.pos+1 >= len(p.src) {
+				return "", p.errorf("unterminated escape")
+			}
+			e := p.src[p.pos+1]
+			p.pos += 2
+			switch e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					return "", p.errorf("short \\u escape")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 16)
+				if err != nil {
+					return "", p.errorf("invalid \\u escape")
+				}
+				p.pos += 4
+				sb.WriteRune(rune(r))
+			default:
+				sb.WriteByte(e)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			sb.WriteRune(r)
+			p.pos += size
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *diagParser) parseArray() (interface{}, error) {
+	p.pos++ // [
+	arr := make([]interface{}, 0)
+	for {
+		p.skipSpace()
+		if p.peek() == ']' {
+			p.pos++
+			return arr, nil
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, item)
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *diagParser) parseMap() (interface{}, error) {
+	p.pos++ // {
+	m := make(diagMap, 0)
+	for {
+		p.skipSpace()
+		if p.peek() == '}' {
+			p.pos++
+			return m, nil
+		}
+		key, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		value, err := p.parseItem()
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, diagPair{Key: key, Value: value})
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+		default:
+			return nil, p.errorf("expected ',' or '}' in map")
+		}
+	}
+}
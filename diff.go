@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ByteStats holds statistics for one byte position of a payload
+type ByteStats struct {
+	Min      byte
+	Max      byte
+	Values   map[byte]int
+	Changes  int
+	BitFlips [8]int // how often each bit (0 = LSB) changed
+}
+
+// Distinct returns the number of distinct values seen
+func (b *ByteStats) Distinct() int {
+	return len(b.Values)
+}
+
+// ByteStatsAccumulator collects per-position statistics from payloads given
+// in time order, so that they can be computed while a capture streams by
+type ByteStatsAccumulator struct {
+	Stats []*ByteStats
+	prev  []byte
+}
+
+// Add records the payload of the next frame
+func (a *ByteStatsAccumulator) Add(data []byte) {
+	for i, b := range data {
+		if i >= len(a.Stats) {
+			a.Stats = append(a.Stats, &ByteStats{Min: b, Max: b, Values: make(map[byte]int)})
+		}
+		s := a.Stats[i]
+		if b < s.Min {
+			s.Min = b
+		}
+		if b > s.Max {
+			s.Max = b
+		}
+		s.Values[b]++
+		if a.prev != nil && i < len(a.prev) && a.prev[i] != b {
+			s.Changes++
+			diff := a.prev[i] ^ b
+			for bit := 0; bit < 8; bit++ {
+				if diff&(1<<bit) != 0 {
+					s.BitFlips[bit]++
+				}
+			}
+		}
+	}
+	a.prev = data
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// displayPayloadDiffs prints, per CAN ID (or ID+header), only the frames whose
+// payload changed from the previous frame of the group, with changed bytes
+// highlighted and changed bits listed, followed by per-byte statistics. The
+// frames come from a spool, read once to count changes and once to print, so
+// only the statistics of one group are held in memory.
+func displayPayloadDiffs(spool *FrameSpool, byHeader, color bool) error {
+	// Count changes first for the group headings
+	changes := make(map[string]int)
+	current := ""
+	var prev []byte
+	err := spool.Each(func(rec *spoolRecord) {
+		if rec.Key == current && !bytes.Equal(prev, rec.Data) {
+			changes[rec.Key]++
+		}
+		current, prev = rec.Key, rec.Data
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\n===================================================")
+	if byHeader {
+		fmt.Println("🔬 PAYLOAD CHANGES BY CAN ID AND HEADER")
+	} else {
+		fmt.Println("🔬 PAYLOAD CHANGES BY CAN ID")
+	}
+	fmt.Println("===================================================")
+
+	var acc *ByteStatsAccumulator
+	current, prev = "", nil
+	err = spool.Each(func(rec *spoolRecord) {
+		if acc == nil || rec.Key != current {
+			if acc != nil {
+				printByteStats(acc.Stats)
+			}
+			acc = &ByteStatsAccumulator{}
+			current, prev = rec.Key, nil
+
+			label := "CAN ID: 0x" + rec.Key
+			if byHeader {
+				parts := strings.SplitN(rec.Key, ":", 2)
+				label = fmt.Sprintf("CAN ID: 0x%s Hdr:%s", parts[0], parts[1])
+			}
+			fmt.Printf("\n🔖 %s (%d frames, %d changes)\n", label, spool.Count(rec.Key), changes[rec.Key])
+			fmt.Println(strings.Repeat("-", 60))
+		}
+		acc.Add(rec.Data)
+
+		data := rec.Data
+		if prev != nil && bytes.Equal(prev, data) {
+			return
+		}
+		tsStr := strconv.FormatFloat(rec.Timestamp, 'f', 6, 64)
+		fmt.Printf("  [%s #%d] %s", tsStr, rec.Seq, formatPayloadDiff(prev, data, color))
+		if prev != nil {
+			fmt.Printf("  Δ %s", formatBitChanges(prev, data))
+		}
+		fmt.Println()
+		prev = data
+	})
+	if err != nil {
+		return err
+	}
+	if acc != nil {
+		printByteStats(acc.Stats)
+	}
+
+	fmt.Println("\n===================================================")
+	return nil
+}
+
+// printByteStats prints the per-byte statistics table of a group
+func printByteStats(stats []*ByteStats) {
+	fmt.Println()
+	fmt.Println("  Byte   Min  Max  Distinct  Changes  Changed bits (7..0)")
+	for i, s := range stats {
+		bits := make([]string, 8)
+		for bit := 7; bit >= 0; bit-- {
+			bits[7-bit] = strconv.Itoa(s.BitFlips[bit])
+		}
+		fmt.Printf("  [%d]    %02X   %02X   %8d  %7d  %s\n",
+			i, s.Min, s.Max, s.Distinct(), s.Changes, strings.Join(bits, " "))
+	}
+}
+
+// formatPayloadDiff formats data as spaced hex, marking bytes that differ from prev
+func formatPayloadDiff(prev, data []byte, color bool) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		changed := prev != nil && (i >= len(prev) || prev[i] != b)
+		switch {
+		case changed && color:
+			parts[i] = fmt.Sprintf("%s%02X%s", ansiChanged, b, ansiReset)
+		case changed:
+			parts[i] = fmt.Sprintf("*%02X", b)
+		case color:
+			parts[i] = fmt.Sprintf("%02X", b)
+		default:
+			parts[i] = fmt.Sprintf(" %02X", b)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatBitChanges lists changed bytes with the XOR mask of their changed bits
+func formatBitChanges(prev, data []byte) string {
+	var parts []string
+	for i, b := range data {
+		if i >= len(prev) {
+			parts = append(parts, fmt.Sprintf("[%d] new", i))
+			continue
+		}
+		if diff := prev[i] ^ b; diff != 0 {
+			parts = append(parts, fmt.Sprintf("[%d] %08b", i, diff))
+		}
+	}
+	if len(prev) > len(data) {
+		parts = append(parts, fmt.Sprintf("%d bytes shorter", len(prev)-len(data)))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Thresholds for signal discovery heuristics
+const (
+	discoveryMinFrames    = 8    // groups with fewer frames are not analyzed
+	counterMinRatio       = 0.9  // share of transitions that must follow the counter step
+	checksumMinRatio      = 0.95 // share of frames the checksum must match
+	monotonicMinRatio     = 0.99 // share of transitions that must not go backwards
+	carryMinRatio         = 0.6  // share of high byte steps that must coincide with a low byte wrap
+	correlationMinAbs     = 0.8  // |Pearson r| reported for adjacent bytes
+	checksumMinDistinct   = 4    // distinct payloads needed before trusting a checksum match
+	counterMinTransitions = 4
+	checksumSampleSize    = 256
+)
+
+// DiscoveredSignal is a field inferred from the payloads of one ID/header group
+type DiscoveredSignal struct {
+	Kind       string  // constant, counter, checksum, monotonic, signal16, correlated, varying
+	Byte       int     // first byte index within the frame data (0 = header)
+	Shift      int     // bit offset within the byte for sub-byte fields
+	Bits       int     // field width in bits
+	BigEndian  bool    // byte order of multi-byte fields
+	Confidence float64 // share of frames/transitions supporting the detection
+	Detail     string
+}
+
+// GroupDiscovery holds the discovered signals of one CAN ID + header group
+type GroupDiscovery struct {
+	ID      CANID
+	Bus     int
+	Header  byte
+	Frames  int
+	Length  int // longest payload seen, in bytes
+	Signals []DiscoveredSignal
+}
+
+// discoverSignals analyzes unaccounted frames per CAN ID and header byte
+func discoverSignals(frames []*FrameInfo) []*GroupDiscovery {
+	keys, grouped := groupFramesByID(unaccountedFrames(frames), true)
+	var results []*GroupDiscovery
+	for _, key := range keys {
+		group := grouped[key]
+		first := group[0]
+		gd := &GroupDiscovery{
+			ID:     first.Frame.ID,
+			Bus:    first.Frame.Bus,
+			Header: first.Header,
+			Frames: len(group),
+		}
+		for _, f := range group {
+			gd.Length = max(gd.Length, len(f.Frame.Data))
+		}
+		if len(group) >= discoveryMinFrames {
+			gd.Signals = analyzeGroup(group)
+		}
+		results = append(results, gd)
+	}
+	return results
+}
+
+// analyzeGroup runs all detectors on the time-sorted payloads of one group
+func analyzeGroup(group []*FrameInfo) []DiscoveredSignal {
+	payloads := make([][]byte, len(group))
+	width := 0
+	for i, f := range group {
+		payloads[i] = f.Frame.Data
+		if len(f.Frame.Data) > width {
+			width = len(f.Frame.Data)
+		}
+	}
+
+	var signals []DiscoveredSignal
+	claimed := make([]bool, width) // bytes already explained
+	claimed[0] = true              // header byte is the group key
+
+	// Constant bytes
+	for i := 1; i < width; i++ {
+		values := byteColumn(payloads, i)
+		if len(values) == len(payloads) && distinctCount(values) == 1 {
+			signals = append(signals, DiscoveredSignal{Kind: "constant", Byte: i, Bits: 8,
+				Detail: fmt.Sprintf("always 0x%02X", values[0])})
+			claimed[i] = true
+		}
+	}
+
+	// Rolling counters over the full byte. A nibble counter next to a
+	// constant nibble also looks like a wider byte counter, so nibble
+	// candidates are kept when they explain more transitions.
+	nibbleCandidates := make(map[int][]DiscoveredSignal)
+	for i := 1; i < width; i++ {
+		if claimed[i] {
+			continue
+		}
+		values := byteColumn(payloads, i)
+		byteCounter, isByteCounter := detectCounter(values, i, 0, 8)
+
+		var nibbleSignals []DiscoveredSignal
+		bestNibble := 0.0
+		for _, shift := range []int{0, 4} {
+			if sig, ok := detectCounter(nibbleColumn(values, shift), i, shift, 4); ok {
+				nibbleSignals = append(nibbleSignals, sig)
+				bestNibble = math.Max(bestNibble, sig.Confidence)
+			}
+		}
+		if isByteCounter && byteCounter.Confidence >= bestNibble {
+			signals = append(signals, byteCounter)
+			claimed[i] = true
+			continue
+		}
+		if len(nibbleSignals) > 0 {
+			nibbleCandidates[i] = nibbleSignals
+		}
+	}
+
+	// Checksums: test each remaining byte against the other bytes. Byte
+	// counters go first because with XOR every byte of a zero-sum frame
+	// matches; checksums go before nibble counters because a checksum over
+	// a counter byte often carries the counter in its low bits.
+	for i := 1; i < width; i++ {
+		if claimed[i] {
+			continue
+		}
+		if sig, ok := detectChecksum(payloads, i); ok {
+			signals = append(signals, sig)
+			claimed[i] = true
+		}
+	}
+
+	// Nibble counters in bytes that are still unexplained
+	for i := 1; i < width; i++ {
+		nibbleSignals, ok := nibbleCandidates[i]
+		if !ok || claimed[i] {
+			continue
+		}
+		values := byteColumn(payloads, i)
+		counterAt := make(map[int]bool)
+		for _, sig := range nibbleSignals {
+			counterAt[sig.Shift] = true
+		}
+		// Describe the other nibble so the byte is fully explained
+		for _, shift := range []int{0, 4} {
+			if counterAt[shift] {
+				continue
+			}
+			nibbles := nibbleColumn(values, shift)
+			lo, hi := minMax(nibbles)
+			if lo == hi {
+				nibbleSignals = append(nibbleSignals, DiscoveredSignal{Kind: "constant", Byte: i, Shift: shift, Bits: 4,
+					Detail: fmt.Sprintf("always 0x%X", lo)})
+			} else {
+				nibbleSignals = append(nibbleSignals, DiscoveredSignal{Kind: "varying", Byte: i, Shift: shift, Bits: 4,
+					Detail: fmt.Sprintf("range 0x%X..0x%X, %d distinct values", lo, hi, distinctCount(nibbles))})
+			}
+		}
+		signals = append(signals, nibbleSignals...)
+		claimed[i] = true
+	}
+
+	// Multi-byte signals from adjacent bytes
+	for i := 1; i+1 < width; i++ {
+		if claimed[i] || claimed[i+1] {
+			continue
+		}
+		a, b := byteColumn(payloads, i), byteColumn(payloads, i+1)
+		if len(a) != len(b) || len(a) < 2 {
+			continue
+		}
+		if sig, ok := detectSignal16(a, b, i); ok {
+			signals = append(signals, sig)
+			claimed[i], claimed[i+1] = true, true
+			continue
+		}
+		if r := pearson(a, b); math.Abs(r) >= correlationMinAbs {
+			signals = append(signals, DiscoveredSignal{Kind: "correlated", Byte: i, Bits: 16,
+				Detail: fmt.Sprintf("bytes %d and %d correlate (r=%.2f), possibly one signal", i, i+1, r)})
+		}
+	}
+
+	// Monotonic and remaining varying bytes
+	for i := 1; i < width; i++ {
+		if claimed[i] {
+			continue
+		}
+		values := byteColumn(payloads, i)
+		if dir, ok := monotonicDirection(values); ok {
+			signals = append(signals, DiscoveredSignal{Kind: "monotonic", Byte: i, Bits: 8,
+				Detail: fmt.Sprintf("%s from 0x%02X to 0x%02X", dir, values[0], values[len(values)-1])})
+			continue
+		}
+		lo, hi := minMax(values)
+		signals = append(signals, DiscoveredSignal{Kind: "varying", Byte: i, Bits: 8,
+			Detail: fmt.Sprintf("range 0x%02X..0x%02X, %d distinct values", lo, hi, distinctCount(values))})
+	}
+
+	sort.SliceStable(signals, func(x, y int) bool {
+		if signals[x].Byte == signals[y].Byte {
+			return signals[x].Shift > signals[y].Shift
+		}
+		return signals[x].Byte < signals[y].Byte
+	})
+	return signals
+}
+
+// byteColumn returns byte i of every payload long enough to have it
+func byteColumn(payloads [][]byte, i int) []int {
+	var values []int
+	for _, p := range payloads {
+		if i < len(p) {
+			values = append(values, int(p[i]))
+		}
+	}
+	return values
+}
+
+// nibbleColumn extracts the 4-bit field at shift from byte values
+func nibbleColumn(values []int, shift int) []int {
+	nibbles := make([]int, len(values))
+	for k, v := range values {
+		nibbles[k] = v >> shift & 0x0F
+	}
+	return nibbles
+}
+
+func distinctCount(values []int) int {
+	seen := make(map[int]bool)
+	for _, v := range values {
+		seen[v] = true
+	}
+	return len(seen)
+}
+
+func minMax(values []int) (int, int) {
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	return lo, hi
+}
+
+// detectCounter checks whether consecutive values step by a constant amount
+// modulo the counter range. The width is inferred from the largest value.
+func detectCounter(values []int, byteIdx, shift, fieldBits int) (DiscoveredSignal, bool) {
+	if len(values) <= counterMinTransitions || distinctCount(values) < 3 {
+		return DiscoveredSignal{}, false
+	}
+	_, hi := minMax(values)
+	width := bits.Len(uint(hi))
+	if width == 0 || width > fieldBits {
+		return DiscoveredSignal{}, false
+	}
+	modulus := 1 << width
+
+	steps := make(map[int]int)
+	for k := 1; k < len(values); k++ {
+		d := ((values[k]-values[k-1])%modulus + modulus) % modulus
+		steps[d]++
+	}
+	bestStep, bestCount := 0, 0
+	for step, count := range steps {
+		if step != 0 && count > bestCount {
+			bestStep, bestCount = step, count
+		}
+	}
+	transitions := len(values) - 1
+	ratio := float64(bestCount) / float64(transitions)
+	if ratio < counterMinRatio {
+		return DiscoveredSignal{}, false
+	}
+
+	wraps := 0
+	for k := 1; k < len(values); k++ {
+		if values[k] < values[k-1] {
+			wraps++
+		}
+	}
+	return DiscoveredSignal{
+		Kind:       "counter",
+		Byte:       byteIdx,
+		Shift:      shift,
+		Bits:       width,
+		Confidence: ratio,
+		Detail: fmt.Sprintf("%d-bit rolling counter, step %d, %.0f%% of transitions, %d wraps",
+			width, bestStep, ratio*100, wraps),
+	}, true
+}
+
+// detectChecksum tests whether byte c is a checksum of the other bytes,
+// computed either over the whole frame or over the bytes after the header
+func detectChecksum(payloads [][]byte, c int) (DiscoveredSignal, bool) {
+	var usable [][]byte
+	distinct := make(map[string]bool)
+	for _, p := range payloads {
+		if c < len(p) {
+			usable = append(usable, p)
+			distinct[string(p)] = true
+		}
+	}
+	if len(usable) < discoveryMinFrames || len(distinct) < checksumMinDistinct {
+		return DiscoveredSignal{}, false
+	}
+
+	// Screen candidates on an evenly spaced sample before checking every frame
+	sample := usable
+	if len(usable) > checksumSampleSize {
+		sample = make([][]byte, checksumSampleSize)
+		for k := range sample {
+			sample[k] = usable[k*len(usable)/checksumSampleSize]
+		}
+	}
+	matchRatio := func(frames [][]byte, alg checksumAlgorithm, from int) float64 {
+		matches := 0
+		covered := make([]byte, 0, 8)
+		for _, p := range frames {
+			covered = append(covered[:0], p[from:c]...)
+			covered = append(covered, p[c+1:]...)
+			if alg.Sum(covered) == p[c] {
+				matches++
+			}
+		}
+		return float64(matches) / float64(len(frames))
+	}
+
+	for _, alg := range checksumAlgorithms() {
+		for _, from := range []int{0, 1} {
+			if matchRatio(sample, alg, from) < checksumMinRatio {
+				continue
+			}
+			ratio := matchRatio(usable, alg, from)
+			if ratio >= checksumMinRatio {
+				coverage := "all other bytes"
+				if from == 1 {
+					coverage = "all other bytes after the header"
+				}
+				return DiscoveredSignal{
+					Kind:       "checksum",
+					Byte:       c,
+					Bits:       8,
+					Confidence: ratio,
+					Detail: fmt.Sprintf("%s over %s, matches %.0f%% of %d frames",
+						alg.Name, coverage, ratio*100, len(usable)),
+				}, true
+			}
+		}
+	}
+	return DiscoveredSignal{}, false
+}
+
+// detectSignal16 looks for a 16-bit value split over two adjacent bytes: when
+// the high byte steps by one, the low byte should wrap around at the same time
+func detectSignal16(a, b []int, byteIdx int) (DiscoveredSignal, bool) {
+	for _, bigEndian := range []bool{false, true} {
+		low, high := a, b
+		if bigEndian {
+			low, high = b, a
+		}
+		steps, carries := 0, 0
+		for k := 1; k < len(low); k++ {
+			dh := high[k] - high[k-1]
+			dl := low[k] - low[k-1]
+			if dh == 1 || dh == -1 {
+				steps++
+				if dh == 1 && dl < -128 || dh == -1 && dl > 128 {
+					carries++
+				}
+			}
+		}
+		if steps >= 2 && float64(carries)/float64(steps) >= carryMinRatio {
+			order := "little-endian"
+			if bigEndian {
+				order = "big-endian"
+			}
+			values := make([]int, len(low))
+			for k := range low {
+				values[k] = high[k]<<8 | low[k]
+			}
+			lo, hi := minMax(values)
+			detail := fmt.Sprintf("16-bit %s value, range %d..%d, %d/%d carries", order, lo, hi, carries, steps)
+			if dir, ok := monotonicDirection(values); ok {
+				detail += ", " + dir
+			}
+			return DiscoveredSignal{Kind: "signal16", Byte: byteIdx, Bits: 16, BigEndian: bigEndian, Detail: detail}, true
+		}
+	}
+	return DiscoveredSignal{}, false
+}
+
+// monotonicDirection reports whether values only increase or only decrease
+func monotonicDirection(values []int) (string, bool) {
+	if len(values) < 2 || distinctCount(values) < 3 {
+		return "", false
+	}
+	up, down := 0, 0
+	for k := 1; k < len(values); k++ {
+		switch {
+		case values[k] > values[k-1]:
+			up++
+		case values[k] < values[k-1]:
+			down++
+		}
+	}
+	transitions := float64(len(values) - 1)
+	switch {
+	case float64(len(values)-1-down)/transitions >= monotonicMinRatio && up > 0:
+		return "monotonic increasing", true
+	case float64(len(values)-1-up)/transitions >= monotonicMinRatio && down > 0:
+		return "monotonic decreasing", true
+	}
+	return "", false
+}
+
+// pearson returns the correlation coefficient of two equally long series
+func pearson(a, b []int) float64 {
+	x := make([]float64, len(a))
+	y := make([]float64, len(b))
+	for i := range a {
+		x[i], y[i] = float64(a[i]), float64(b[i])
+	}
+	return pearsonFloat(x, y)
+}
+
+// pearsonFloat is pearson for float series
+func pearsonFloat(a, b []float64) float64 {
+	n := float64(len(a))
+	var sa, sb, saa, sbb, sab float64
+	for i := range a {
+		x, y := a[i], b[i]
+		sa += x
+		sb += y
+		saa += x * x
+		sbb += y * y
+		sab += x * y
+	}
+	den := math.Sqrt(n*saa-sa*sa) * math.Sqrt(n*sbb-sb*sb)
+	if den == 0 {
+		return 0
+	}
+	return (n*sab - sa*sb) / den
+}
+
+// displayDiscovery prints the signal discovery report
+func displayDiscovery(results []*GroupDiscovery) {
+	fmt.Println("\n===================================================")
+	fmt.Println("🧭 SIGNAL DISCOVERY (unaccounted frames)")
+	fmt.Println("===================================================")
+
+	for _, gd := range results {
+		fmt.Printf("\n🔖 CAN ID: 0x%s Hdr:%02X (%d frames)\n", busQualifiedID(gd.ID, gd.Bus), gd.Header, gd.Frames)
+		fmt.Println(strings.Repeat("-", 60))
+		if gd.Frames < discoveryMinFrames {
+			fmt.Printf("  Too few frames to analyze (need %d)\n", discoveryMinFrames)
+			continue
+		}
+		for _, s := range gd.Signals {
+			fmt.Printf("  %-10s %-12s %s\n", signalKindLabel(s.Kind), signalLocation(s), s.Detail)
+		}
+	}
+
+	fmt.Println("\n===================================================")
+}
+
+func signalKindLabel(kind string) string {
+	switch kind {
+	case "constant":
+		return "📌 const"
+	case "counter":
+		return "🔁 counter"
+	case "checksum":
+		return "🔐 checksum"
+	case "monotonic":
+		return "📈 monotonic"
+	case "signal16":
+		return "📶 signal16"
+	case "correlated":
+		return "🔗 correlated"
+	}
+	return "❔ varying"
+}
+
+// signalLocation describes where a signal sits, e.g. "byte 3" or "byte 2 bits 0-3"
+func signalLocation(s DiscoveredSignal) string {
+	switch {
+	case s.Bits == 16:
+		return fmt.Sprintf("bytes %d-%d", s.Byte, s.Byte+1)
+	case s.Bits < 8 || s.Shift > 0:
+		return fmt.Sprintf("byte %d bits %d-%d", s.Byte, s.Shift, s.Shift+s.Bits-1)
+	}
+	return fmt.Sprintf("byte %d", s.Byte)
+}
+
+// writeDiscoveryDBC writes the discovered signals as a draft DBC file, using
+// the header byte as multiplexor so each header gets its own signal set
+func writeDiscoveryDBC(w io.Writer, results []*GroupDiscovery) error {
+	var sb strings.Builder
+	sb.WriteString("VERSION \"\"\n\nNS_ :\n\nBS_:\n\nBU_:\n\n")
+
+	var comments []string
+	byID := make(map[string][]*GroupDiscovery)
+	var ids []string
+	for _, gd := range results {
+		key := busQualifiedID(gd.ID, gd.Bus)
+		if _, ok := byID[key]; !ok {
+			ids = append(ids, key)
+		}
+		byID[key] = append(byID[key], gd)
+	}
+
+	for _, key := range ids {
+		groups := byID[key]
+		dbcID := groups[0].ID.Value
+		if groups[0].ID.Extended {
+			dbcID |= 0x80000000
+		}
+		length := 0
+		for _, gd := range groups {
+			length = max(length, gd.Length)
+		}
+		msgName := "MSG_" + strings.ReplaceAll(key, "@", "_")
+		fmt.Fprintf(&sb, "BO_ %d %s: %d Vector__XXX\n", dbcID, msgName, length)
+		fmt.Fprintf(&sb, " SG_ Header M : 0|8@1+ (1,0) [0|255] \"\" Vector__XXX\n")
+
+		for _, gd := range groups {
+			for _, s := range gd.Signals {
+				if s.Kind == "correlated" {
+					continue
+				}
+				name := fmt.Sprintf("H%02X_%s_B%d", gd.Header, s.Kind, s.Byte)
+				if s.Shift > 0 || s.Bits < 8 {
+					name += fmt.Sprintf("_%d", s.Shift)
+				}
+				start := s.Byte*8 + s.Shift
+				order := 1
+				if s.BigEndian {
+					// Motorola start bit is the MSB of the first byte
+					order = 0
+					start = s.Byte*8 + 7
+				}
+				max := uint64(1)<<uint(s.Bits) - 1
+				fmt.Fprintf(&sb, " SG_ %s m%d : %d|%d@%d+ (1,0) [0|%d] \"\" Vector__XXX\n",
+					name, gd.Header, start, s.Bits, order, max)
+				comments = append(comments, fmt.Sprintf("CM_ SG_ %d %s \"%s\";", dbcID, name,
+					strings.ReplaceAll(s.Detail, "\"", "'")))
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, c := range comments {
+		sb.WriteString(c + "\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeDBCFiles writes the draft DBC to path and returns the files written.
+// A DBC describes one network, so with results from several buses each bus
+// gets its own file, e.g. draft_battery.dbc, and a CAN ID seen on two buses
+// is never defined twice in one file.
+func writeDBCFiles(path string, results []*GroupDiscovery) ([]string, error) {
+	byBus := make(map[int][]*GroupDiscovery)
+	var buses []int
+	for _, gd := range results {
+		if _, ok := byBus[gd.Bus]; !ok {
+			buses = append(buses, gd.Bus)
+		}
+		byBus[gd.Bus] = append(byBus[gd.Bus], gd)
+	}
+	if len(buses) <= 1 {
+		return []string{path}, writeDBCFile(path, results)
+	}
+
+	sort.Ints(buses)
+	ext := filepath.Ext(path)
+	var paths []string
+	for _, bus := range buses {
+		busPath := strings.TrimSuffix(path, ext) + "_" + busName(bus) + ext
+		if err := writeDBCFile(busPath, byBus[bus]); err != nil {
+			return paths, err
+		}
+		paths = append(paths, busPath)
+	}
+	return paths, nil
+}
+
+// writeDBCFile writes the draft DBC to path
+func writeDBCFile(path string, results []*GroupDiscovery) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeDiscoveryDBC(file, results); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
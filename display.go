@@ -9,70 +9,98 @@ import (
 
 // printFrameHeader prints a formatted frame header with metadata
 func printFrameHeader(frame *CANFrame, header byte, frameType string) {
+	fmt.Println(formatFrameHeader(frame, header, frameType))
+}
+
+// formatFrameHeader formats the frame header line printed by printFrameHeader
+func formatFrameHeader(frame *CANFrame, header byte, frameType string) string {
 	idType := "Std"
-	if frame.IsExtended {
+	if frame.ID.Extended {
 		idType = "Ext"
 	}
-	fmt.Printf("📍 ID:0x%s(%s) Hdr:%02X [%s] Data[%d]: %X\n",
-		frame.ID, idType, header, frameType, len(frame.Data), frame.Data)
+	line := fmt.Sprintf("📍 ID:0x%s(%s) Hdr:%02X [%s] Data[%d]: %X",
+		frameSourceID(frame), idType, header, frameType, len(frame.Data), frame.Data)
+	if fields := idLayout.Format(frame.ID); fields != "" {
+		line += " {" + fields + "}"
+	}
+	return line
 }
 
-// displayGroupedFrames displays frames grouped by CAN ID and sorted by timestamp
-func displayGroupedFrames(frames []*FrameInfo, hideAccounted, hideUnaccounted bool) {
-	// Group frames by CAN ID
+// groupFramesByID groups frames by bus-qualified CAN ID, sorted by timestamp
+// within each group, and returns the group keys sorted by bus and ID. With
+// byHeader the header byte is part of the key ("ID:HDR").
+func groupFramesByID(frames []*FrameInfo, byHeader bool) ([]string, map[string][]*FrameInfo) {
 	grouped := make(map[string][]*FrameInfo)
 	for _, f := range frames {
-		grouped[f.Frame.ID] = append(grouped[f.Frame.ID], f)
+		key := frameSourceID(f.Frame)
+		if byHeader {
+			key = fmt.Sprintf("%s:%02X", key, f.Header)
+		}
+		grouped[key] = append(grouped[key], f)
 	}
 
-	// Get sorted list of IDs
-	var ids []string
-	for id := range grouped {
-		ids = append(ids, id)
+	// Get sorted list of keys
+	var keys []string
+	for key := range grouped {
+		keys = append(keys, key)
 	}
-	sort.Strings(ids)
-
-	fmt.Println("\n===================================================")
-	fmt.Println("📋 FRAMES GROUPED BY CAN ID")
-	fmt.Println("===================================================")
-
-	for _, id := range ids {
-		frameList := grouped[id]
-
-		// Sort by timestamp within each group
-		sort.Slice(frameList, func(i, j int) bool {
-			if frameList[i].TimestampFloat == frameList[j].TimestampFloat {
-				// If timestamps are equal, sort by sequence number to maintain order
-				return frameList[i].SequenceNum < frameList[j].SequenceNum
-			}
-			return frameList[i].TimestampFloat < frameList[j].TimestampFloat
-		})
-
-		// Filter frames based on flags
-		var filteredFrames []*FrameInfo
-		for _, f := range frameList {
-			if hideAccounted && (f.IsCBOR || f.IsHeartbeat) {
-				continue
-			}
-			if hideUnaccounted && !f.IsCBOR && !f.IsHeartbeat {
-				continue
-			}
-			filteredFrames = append(filteredFrames, f)
+	sort.Slice(keys, func(i, j int) bool {
+		bi, bj := grouped[keys[i]][0].Frame.Bus, grouped[keys[j]][0].Frame.Bus
+		if bi != bj {
+			return bi < bj
 		}
+		return keys[i] < keys[j]
+	})
 
-		if len(filteredFrames) == 0 {
-			continue
+	for _, frameList := range grouped {
+		sortFramesByTime(frameList)
+	}
+	return keys, grouped
+}
+
+// sortFramesByTime sorts frames by timestamp, keeping capture order for equal timestamps
+func sortFramesByTime(frames []*FrameInfo) {
+	sort.Slice(frames, func(i, j int) bool {
+		if frames[i].TimestampFloat == frames[j].TimestampFloat {
+			// If timestamps are equal, sort by sequence number to maintain order
+			return frames[i].SequenceNum < frames[j].SequenceNum
 		}
+		return frames[i].TimestampFloat < frames[j].TimestampFloat
+	})
+}
 
-		fmt.Printf("\n🔖 CAN ID: 0x%s (%d frames)\n", id, len(filteredFrames))
-		fmt.Println(strings.Repeat("-", 60))
+// displayGroupedFrames displays spooled frames grouped by CAN ID and sorted
+// by timestamp
+func displayGroupedFrames(spool *FrameSpool) error {
+	fmt.Println("\n===================================================")
+	fmt.Println("📋 FRAMES GROUPED BY CAN ID")
+	fmt.Println("===================================================")
 
-		for _, f := range filteredFrames {
-			tsStr := strconv.FormatFloat(f.TimestampFloat, 'f', 6, 64)
-			fmt.Printf("  [%s #%d] ", tsStr, f.SequenceNum)
-			printFrameHeader(f.Frame, f.Header, f.FrameType)
+	current := ""
+	err := spool.Each(func(rec *spoolRecord) {
+		if rec.Key != current {
+			current = rec.Key
+			fmt.Printf("\n🔖 CAN ID: 0x%s (%d frames)\n", rec.Key, spool.Count(rec.Key))
+			fmt.Println(strings.Repeat("-", 60))
 		}
+		frame := &CANFrame{ID: rec.ID, Direction: rec.Direction, Bus: rec.Bus, Length: len(rec.Data), Data: rec.Data}
+		tsStr := strconv.FormatFloat(rec.Timestamp, 'f', 6, 64)
+		fmt.Printf("  [%s #%d] ", tsStr, rec.Seq)
+		printFrameHeader(frame, rec.Data[0], rec.FrameType)
+	})
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("\n===================================================")
+	return nil
+}
+
+// accountedHidden reports whether the -hide-accounted / -hide-unaccounted
+// flags hide a frame
+func accountedHidden(f *FrameInfo, hideAccounted, hideUnaccounted bool) bool {
+	if hideAccounted && (f.IsCBOR || f.IsHeartbeat) {
+		return true
+	}
+	return hideUnaccounted && !f.IsCBOR && !f.IsHeartbeat
 }
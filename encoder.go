@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxFramePayload is the number of CBOR bytes carried per frame (8 minus header)
+const maxFramePayload = 7
+
+// encodeMessage encodes a parsed diagnostic/JSON item to CBOR
+func encodeMessage(item interface{}) ([]byte, error) {
+	return cborEncMode.Marshal(item)
+}
+
+// fragmentMessage splits a CBOR message into a START frame (0xAx) followed by
+// CONT frames (0x1x). The low nibble of the START header is startNibble; CONT
+// headers carry a sequence counter in their low nibble starting at 1.
+func fragmentMessage(id CANID, msg []byte, startNibble byte) []*CANFrame {
+	var frames []*CANFrame
+	seq := byte(1)
+	for offset := 0; offset < len(msg) || offset == 0; offset += maxFramePayload {
+		end := offset + maxFramePayload
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		var header byte
+		if offset == 0 {
+			header = 0xA0 | startNibble&0x0F
+		} else {
+			header = 0x10 | seq&0x0F
+			seq++
+		}
+
+		data := make([]byte, 0, 8)
+		data = append(data, header)
+		data = append(data, msg[offset:end]...)
+		frames = append(frames, &CANFrame{
+			ID:     id,
+			Length: len(data),
+			Data:   data,
+		})
+	}
+	return frames
+}
+
+// runEncode implements the encode subcommand: build VanMoof CBOR messages from
+// JSON or CBOR diagnostic notation and emit them as CAN frames
+func runEncode(args []string) {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	idFlag := fs.String("id", "", "target CAN ID in hex (e.g. 18209820)")
+	input := fs.String("in", "", "read message(s) from file instead of arguments/stdin")
+	rawHex := fs.Bool("hex", false, "input is already-encoded CBOR as hex, skip encoding")
+	startNibble := fs.Uint("start-nibble", 0, "low nibble of the START header byte (0-15)")
+	iface := fs.String("iface", "vcan0", "interface name written in candump output or used with -send")
+	send := fs.Bool("send", false, "transmit frames on the SocketCAN interface instead of printing them")
+	gap := fs.Duration("gap", time.Millisecond, "delay between frames (timestamp step when printing)")
+	startTime := fs.Float64("t0", 0, "timestamp of the first frame in candump output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus encode -id ID [flags] [message ...]")
+		fmt.Fprintln(fs.Output(), `Example: canbus encode -id 18209820 "{1: h'0102', 2: [true, 5]}"`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *idFlag == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	id, err := ParseCANID(*idFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if *startNibble > 0x0F {
+		fmt.Fprintln(os.Stderr, "Error: -start-nibble must be between 0 and 15")
+		os.Exit(1)
+	}
+
+	// Message source: file, remaining arguments, or stdin
+	var src string
+	switch {
+	case *input != "":
+		b, err := os.ReadFile(*input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		src = string(b)
+	case fs.NArg() > 0:
+		src = strings.Join(fs.Args(), " ")
+	default:
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		src = string(b)
+	}
+
+	var messages [][]byte
+	if *rawHex {
+		for _, field := range strings.Fields(src) {
+			msg, err := hex.DecodeString(field)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: invalid hex:", err)
+				os.Exit(1)
+			}
+			messages = append(messages, msg)
+		}
+	} else {
+		items, err := parseDiagnostic(src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: parsing message:", err)
+			os.Exit(1)
+		}
+		for _, item := range items {
+			msg, err := encodeMessage(item)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error: encoding CBOR:", err)
+				os.Exit(1)
+			}
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no message given")
+		os.Exit(1)
+	}
+
+	var sock *canSocket
+	if *send {
+		sock, err = openCANSocket(*iface)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		defer sock.Close()
+	}
+
+	ts := *startTime
+	for _, msg := range messages {
+		frames := fragmentMessage(id, msg, byte(*startNibble))
+		if *send {
+			fmt.Fprintf(os.Stderr, "📤 Sending %d bytes in %d frames on %s: %X\n", len(msg), len(frames), *iface, msg)
+		}
+		for _, frame := range frames {
+			if *send {
+				if err := sock.WriteFrame(frame); err != nil {
+					fmt.Fprintln(os.Stderr, "Error: sending frame:", err)
+					os.Exit(1)
+				}
+				time.Sleep(*gap)
+				continue
+			}
+			fmt.Println(formatCandumpLine(frame, ts, *iface))
+			ts += gap.Seconds()
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// exportRow is one value of the long-format export
+type exportRow struct {
+	Timestamp float64
+	ID        string
+	Path      string
+	Value     float64 // NaN for values that are not numbers
+	Text      string
+	Unit      string
+}
+
+// readUnits reads a units file with one "ID PATH UNIT" per line, e.g.
+// "14609460 3.[1] km/h". Blank lines and lines starting with # are ignored.
+func readUnits(path string) (map[string]string, error) {
+	units := make(map[string]string)
+	if path == "" {
+		return units, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected ID PATH UNIT", path, lineNum)
+		}
+		units[canonicalID(fields[0])+" "+fields[1]] = strings.Join(fields[2:], " ")
+	}
+	return units, scanner.Err()
+}
+
+// exportRows flattens decoded CBOR messages with at least one frame in
+// frames, and with raw the payload bytes of unaccounted frames, into
+// long-format rows in capture order. Raw bytes use the path raw.<header>.[N].
+func exportRows(frames []*FrameInfo, units map[string]string, raw bool) []exportRow {
+	var rows []exportRow
+	seen := make(map[*Message]bool)
+	for _, f := range frames {
+		if msg := f.Message; msg != nil {
+			// A message is exported once, at the first of its frames selected
+			if seen[msg] {
+				continue
+			}
+			seen[msg] = true
+			walkFields(msg.Item, "", func(path string, value interface{}) {
+				n, ok := fieldNumber(value)
+				if !ok {
+					n = math.NaN()
+				}
+				rows = append(rows, exportRow{
+					Timestamp: msg.Timestamp,
+					ID:        msg.SourceID(),
+					Path:      path,
+					Value:     n,
+					Text:      formatFieldValue(value),
+					Unit:      units[idKey(msg.ID)+" "+path],
+				})
+			})
+			continue
+		}
+		if !raw || f.IsCBOR || f.IsHeartbeat {
+			continue
+		}
+		for i := 1; i < len(f.Frame.Data); i++ {
+			path := fmt.Sprintf("raw.%02X.[%d]", f.Header, i)
+			rows = append(rows, exportRow{
+				Timestamp: f.TimestampFloat,
+				ID:        frameSourceID(f.Frame),
+				Path:      path,
+				Value:     float64(f.Frame.Data[i]),
+				Text:      fmt.Sprintf("%02X", f.Frame.Data[i]),
+				Unit:      units[idKey(f.Frame.ID)+" "+path],
+			})
+		}
+	}
+	return rows
+}
+
+// wideTable resamples the numeric long-format rows onto a time grid with one
+// column per CAN ID and field path, holding the last value. Cells before the
+// first sample of a column are NaN.
+func wideTable(rows []exportRow, step float64) (names []string, times []float64, columns [][]float64) {
+	if len(rows) == 0 {
+		return nil, nil, nil
+	}
+	index := make(map[string]int)
+	var series []*TimeSeries
+	start, end := rows[0].Timestamp, rows[0].Timestamp
+	for _, r := range rows {
+		start, end = math.Min(start, r.Timestamp), math.Max(end, r.Timestamp)
+		if math.IsNaN(r.Value) {
+			continue
+		}
+		name := r.ID + ":" + r.Path
+		if r.Unit != "" {
+			name += " (" + r.Unit + ")"
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(series)
+			index[name] = i
+			series = append(series, &TimeSeries{Name: name})
+			names = append(names, name)
+		}
+		series[i].Times = append(series[i].Times, r.Timestamp)
+		series[i].Values = append(series[i].Values, r.Value)
+	}
+
+	n := int(math.Floor((end-start)/step+1e-9)) + 1
+	times = make([]float64, n)
+	for i := range times {
+		times[i] = start + float64(i)*step
+	}
+	for _, s := range series {
+		sortSeries(s)
+		column := make([]float64, n)
+		j := -1
+		for i, t := range times {
+			for j+1 < len(s.Times) && s.Times[j+1] <= t+1e-9 {
+				j++
+			}
+			if j < 0 {
+				column[i] = math.NaN()
+			} else {
+				column[i] = s.Values[j]
+			}
+		}
+		columns = append(columns, column)
+	}
+	return names, times, columns
+}
+
+// formatExportValue formats a number for CSV, leaving NaN empty
+func formatExportValue(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// writeLongCSV writes one row per value
+func writeLongCSV(w io.Writer, rows []exportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "can_id", "path", "value", "text", "unit"})
+	for _, r := range rows {
+		cw.Write([]string{strconv.FormatFloat(r.Timestamp, 'f', 6, 64), r.ID, r.Path,
+			formatExportValue(r.Value), r.Text, r.Unit})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeWideCSV writes one row per grid time and one column per field
+func writeWideCSV(w io.Writer, names []string, times []float64, columns [][]float64) error {
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"timestamp"}, names...))
+	for i, t := range times {
+		record := []string{strconv.FormatFloat(t, 'f', 6, 64)}
+		for _, c := range columns {
+			record = append(record, formatExportValue(c[i]))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// longParquetColumns converts long-format rows to Parquet columns
+func longParquetColumns(rows []exportRow) []*parquetColumn {
+	cols := []*parquetColumn{
+		{Name: "timestamp", Doubles: make([]float64, 0, len(rows))},
+		{Name: "can_id", Strings: make([]string, 0, len(rows))},
+		{Name: "path", Strings: make([]string, 0, len(rows))},
+		{Name: "value", Doubles: make([]float64, 0, len(rows))},
+		{Name: "text", Strings: make([]string, 0, len(rows))},
+		{Name: "unit", Strings: make([]string, 0, len(rows))},
+	}
+	for _, r := range rows {
+		cols[0].Doubles = append(cols[0].Doubles, r.Timestamp)
+		cols[1].Strings = append(cols[1].Strings, r.ID)
+		cols[2].Strings = append(cols[2].Strings, r.Path)
+		cols[3].Doubles = append(cols[3].Doubles, r.Value)
+		cols[4].Strings = append(cols[4].Strings, r.Text)
+		cols[5].Strings = append(cols[5].Strings, r.Unit)
+	}
+	return cols
+}
+
+// runExport implements the export subcommand: flatten decoded messages (and
+// optionally raw bytes) into time series tables
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default stdout)")
+	format := fs.String("format", "", "csv or parquet (default from the -o extension, else csv)")
+	wide := fs.Bool("wide", false, "one column per CAN ID and field, resampled to a time grid, instead of one row per value")
+	step := fs.Float64("step", 0.1, "with -wide, time grid step in seconds")
+	raw := fs.Bool("raw", false, "also export payload bytes of unaccounted frames as raw.<header>.[N] fields")
+	unitsPath := fs.String("units", "", "file with one 'ID PATH UNIT' per line to fill the unit column")
+	filterExpr := fs.String("filter", "", "only export frames matching a filter expression")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus export [flags] [capture file]")
+		fmt.Fprintln(fs.Output(), "Reads stdin when no file is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	if *format == "" {
+		*format = "csv"
+		if strings.EqualFold(filepath.Ext(*output), ".parquet") {
+			*format = "parquet"
+		}
+	}
+	if *format != "csv" && *format != "parquet" {
+		fail(fmt.Errorf("unknown format %q (use csv or parquet)", *format))
+	}
+	if *step <= 0 {
+		fail(fmt.Errorf("-step must be positive"))
+	}
+	filter, err := ParseFilter(*filterExpr)
+	if err != nil {
+		fail(err)
+	}
+	units, err := readUnits(*unitsPath)
+	if err != nil {
+		fail(err)
+	}
+
+	var input io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		defer file.Close()
+		input = file
+	}
+	frames, err := readAllFrames(input)
+	if err != nil {
+		fail(err)
+	}
+	attachMessages(frames)
+	rows := exportRows(filterFrames(frames, filter), units, *raw)
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			fail(err)
+		}
+		out = file
+	}
+	bw := bufio.NewWriter(out)
+
+	written := len(rows)
+	switch {
+	case *wide:
+		names, times, columns := wideTable(rows, *step)
+		written = len(times)
+		if *format == "csv" {
+			err = writeWideCSV(bw, names, times, columns)
+		} else {
+			cols := []*parquetColumn{{Name: "timestamp", Doubles: times}}
+			for i, name := range names {
+				cols = append(cols, &parquetColumn{Name: name, Doubles: columns[i]})
+			}
+			err = writeParquet(bw, cols)
+		}
+	case *format == "csv":
+		err = writeLongCSV(bw, rows)
+	default:
+		err = writeParquet(bw, longParquetColumns(rows))
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fail(err)
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "📝 Exported %d rows to %s (%s)\n", written, *output, *format)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// twoMessages is a candump capture with two CBOR messages of three frames
+const twoMessages = `(100.000000) vcan0 14609460#A0A3010202820A14
+(100.001000) vcan0 14609460#11646D6F64656365
+(100.002000) vcan0 14609460#12636F
+(100.003000) vcan0 14609460#A0A3010302820B14
+(100.004000) vcan0 14609460#11646D6F64656365
+(100.005000) vcan0 14609460#12636F
+`
+
+// readTestFrames parses a capture and reassembles its messages
+func readTestFrames(t *testing.T, capture string) []*FrameInfo {
+	t.Helper()
+	frames, err := readAllFrames(strings.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachMessages(frames)
+	return frames
+}
+
+func TestExportRowsMultiFrameMessage(t *testing.T) {
+	frames := readTestFrames(t, twoMessages)
+
+	for _, tc := range []struct {
+		filter string
+		want   int // messages exported
+	}{
+		{"", 2},
+		{"type==START", 2},
+		{"type==CONT", 2},
+		{"t<0.002", 1},
+		{"t>=0.003", 1},
+		{"id==123", 0},
+	} {
+		filter, err := ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := exportRows(filterFrames(frames, filter), nil, false)
+		// Each message has the fields 1, 2.[0], 2.[1] and mode
+		if len(rows) != 4*tc.want {
+			t.Errorf("filter %q: got %d rows, want %d", tc.filter, len(rows), 4*tc.want)
+			continue
+		}
+		if tc.want == 0 {
+			continue
+		}
+		byPath := make(map[string]exportRow)
+		for _, r := range rows[:4] {
+			byPath[r.Path] = r
+		}
+		if r := byPath["mode"]; r.Text != "eco" || !math.IsNaN(r.Value) {
+			t.Errorf("filter %q: mode = %q/%v, want eco/NaN", tc.filter, r.Text, r.Value)
+		}
+		if r := byPath["2.[0]"]; r.ID != "14609460" || r.Value != 10 && r.Value != 11 {
+			t.Errorf("filter %q: 2.[0] = %s %v", tc.filter, r.ID, r.Value)
+		}
+	}
+}
+
+func TestWideTable(t *testing.T) {
+	rows := []exportRow{
+		{Timestamp: 0, ID: "1", Path: "a", Value: 1},
+		{Timestamp: 0.25, ID: "1", Path: "b", Value: 5, Unit: "V"},
+		{Timestamp: 0.3, ID: "1", Path: "a", Value: 2},
+		{Timestamp: 0.35, ID: "1", Path: "text", Value: math.NaN()},
+	}
+	names, times, columns := wideTable(rows, 0.1)
+	if want := []string{"1:a", "1:b (V)"}; strings.Join(names, "|") != strings.Join(want, "|") {
+		t.Fatalf("names = %q, want %q", names, want)
+	}
+	if len(times) != 4 || math.Abs(times[3]-0.3) > 1e-9 {
+		t.Fatalf("times = %v", times)
+	}
+	if got := columns[0]; got[0] != 1 || got[2] != 1 || got[3] != 2 {
+		t.Errorf("column a = %v", got)
+	}
+	if got := columns[1]; !math.IsNaN(got[2]) || got[3] != 5 {
+		t.Errorf("column b = %v", got)
+	}
+}
+
+// thriftReader decodes the Thrift compact protocol into maps of field IDs
+type thriftReader struct {
+	data []byte
+	pos  int
+	t    *testing.T
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("bad varint at %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		v := r.varint()
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftBinary:
+		n := int(r.varint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case thriftList:
+		head := r.data[r.pos]
+		r.pos++
+		size, elem := int(head>>4), head&0x0F
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStructType:
+		return r.structure()
+	}
+	r.t.Fatalf("unexpected thrift type %d at %d", typ, r.pos)
+	return nil
+}
+
+func (r *thriftReader) structure() map[int]interface{} {
+	fields := make(map[int]interface{})
+	last := 0
+	for {
+		head := r.data[r.pos]
+		r.pos++
+		if head == 0 {
+			return fields
+		}
+		id := last + int(head>>4)
+		if head>>4 == 0 {
+			v := r.varint()
+			id = int(int64(v>>1) ^ -int64(v&1))
+		}
+		fields[id] = r.value(head & 0x0F)
+		last = id
+	}
+}
+
+func TestWriteParquetLayout(t *testing.T) {
+	columns := []*parquetColumn{
+		{Name: "timestamp", Doubles: []float64{1.5, 2.5, 3.5}},
+		{Name: "can_id", Strings: []string{"123", "14609460", ""}},
+	}
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, columns); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatal("missing PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLen
+	r := &thriftReader{data: data[:len(data)-8], pos: footerStart, t: t}
+	meta := r.structure()
+	if r.pos != len(data)-8 {
+		t.Fatalf("footer decoded to %d, want %d", r.pos, len(data)-8)
+	}
+	if meta[3] != int64(3) {
+		t.Errorf("num_rows = %v, want 3", meta[3])
+	}
+
+	schema := meta[2].([]interface{})
+	if len(schema) != 3 || schema[0].(map[int]interface{})[5] != int64(2) {
+		t.Fatalf("schema = %v", schema)
+	}
+	for i, c := range columns {
+		leaf := schema[i+1].(map[int]interface{})
+		if leaf[4] != c.Name || leaf[1] != c.physicalType() {
+			t.Errorf("schema leaf %d = %v", i, leaf)
+		}
+	}
+
+	rowGroup := meta[4].([]interface{})[0].(map[int]interface{})
+	chunks := rowGroup[1].([]interface{})
+	end := 4
+	for i, c := range columns {
+		cm := chunks[i].(map[int]interface{})[3].(map[int]interface{})
+		offset, size := int(cm[9].(int64)), int(cm[6].(int64))
+		if offset != end {
+			t.Errorf("column %s starts at %d, want %d", c.Name, offset, end)
+		}
+		end = offset + size
+
+		// The chunk is a data page header followed by the PLAIN values
+		pr := &thriftReader{data: data, pos: offset, t: t}
+		page := pr.structure()
+		values := data[pr.pos:end]
+		if page[1] != int64(parquetPageData) || page[2] != int64(len(values)) {
+			t.Errorf("column %s: page header %v, %d value bytes", c.Name, page, len(values))
+		}
+		if dp := page[5].(map[int]interface{}); dp[1] != int64(3) {
+			t.Errorf("column %s: data page has %v values", c.Name, dp[1])
+		}
+		if !bytes.Equal(values, c.plain()) {
+			t.Errorf("column %s: values differ", c.Name)
+		}
+	}
+	if end != footerStart {
+		t.Errorf("column chunks end at %d, footer starts at %d", end, footerStart)
+	}
+	if got := string(columns[1].plain()[4:7]); got != "123" {
+		t.Errorf("first string = %q", got)
+	}
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(columns[0].plain()[8:])); got != 2.5 {
+		t.Errorf("second double = %v", got)
+	}
+}
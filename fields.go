@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Decoded CBOR fields are addressed by paths of map keys and array indices
+// joined with dots, e.g. "3.[1]" is element 1 of the array under map key 3.
+
+// splitFieldPath splits a field path into its segments
+func splitFieldPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	var segments []string
+	for _, seg := range strings.Split(path, ".") {
+		// Allow "3[1]" as shorthand for "3.[1]"
+		for {
+			idx := strings.Index(seg, "[")
+			if idx <= 0 {
+				break
+			}
+			segments = append(segments, seg[:idx])
+			seg = seg[idx:]
+		}
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	return segments
+}
+
+// lookupField returns the value at the given path in a decoded CBOR item
+func lookupField(item interface{}, path []string) (interface{}, bool) {
+	for _, seg := range path {
+		switch v := item.(type) {
+		case map[interface{}]interface{}:
+			found := false
+			for k, val := range v {
+				if formatFieldKey(k) == seg {
+					item, found = val, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(seg, "["), "]"))
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			item = v[idx]
+		default:
+			return nil, false
+		}
+	}
+	return item, true
+}
+
+// formatFieldKey formats a map key as a path segment
+func formatFieldKey(k interface{}) string {
+	if b, ok := k.([]byte); ok {
+		return fmt.Sprintf("%X", b)
+	}
+	return fmt.Sprintf("%v", k)
+}
+
+// walkFields calls fn for every leaf value of a decoded CBOR item with its
+// field path. Map entries are visited in sorted key order.
+func walkFields(item interface{}, prefix string, fn func(path string, value interface{})) {
+	join := func(seg string) string {
+		if prefix == "" {
+			return seg
+		}
+		return prefix + "." + seg
+	}
+
+	switch v := item.(type) {
+	case map[interface{}]interface{}:
+		keys := make([]string, 0, len(v))
+		byKey := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			key := formatFieldKey(k)
+			keys = append(keys, key)
+			byKey[key] = val
+		}
+		sortFieldKeys(keys)
+		for _, key := range keys {
+			walkFields(byKey[key], join(key), fn)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			walkFields(elem, join(fmt.Sprintf("[%d]", i)), fn)
+		}
+	default:
+		fn(prefix, v)
+	}
+}
+
+// sortFieldKeys sorts keys numerically where possible, then lexically
+func sortFieldKeys(keys []string) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.ParseInt(keys[i], 10, 64)
+		b, errB := strconv.ParseInt(keys[j], 10, 64)
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// fieldNumber converts a decoded CBOR scalar to a float64 if it is numeric
+func fieldNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// formatFieldValue formats a decoded CBOR scalar for display and matching;
+// byte strings are shown as hex
+func formatFieldValue(v interface{}) string {
+	switch b := v.(type) {
+	case []byte:
+		return fmt.Sprintf("%X", b)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,715 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter is a compiled frame filter expression such as
+//
+//	id==0x18209820 && type==CONT && t>12.5
+//
+// Supported fields:
+//
+//	id, bus, dir, ext, type, hdr, len   frame metadata (bus by number or -bus-names name)
+//	id.<field>                          field of an extended ID in the -id-layout
+//	data, payload                       hex string of all bytes / bytes after the header
+//	data[N], payload[N]                 single byte
+//	t, ts                               seconds since the capture start / raw timestamp
+//	msg, msg.<path>                     decoded CBOR message and its fields (e.g. msg.3.[1])
+//	busstate, state                     bus state and sequence state with -states
+//
+// Operators: == != < <= > >= ~ (regexp), in [a, b, lo..hi], & (mask), && || ! and parentheses.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// filterContext is what a filter expression is evaluated against
+type filterContext struct {
+	info  *FrameInfo
+	start float64 // capture start timestamp for the t field
+}
+
+type filterNode interface {
+	eval(ctx *filterContext) bool
+}
+
+// ParseFilter compiles a filter expression. An empty expression matches everything.
+func ParseFilter(expr string) (*Filter, error) {
+	f := &Filter{expr: expr}
+	if strings.TrimSpace(expr) == "" {
+		return f, nil
+	}
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.tokens[p.pos].text)
+	}
+	f.root = root
+	return f, nil
+}
+
+// Match reports whether a frame satisfies the filter. start is the timestamp
+// of the first frame of the capture, used for the relative time field t.
+// Decoded-field predicates only match frames whose message is complete.
+func (f *Filter) Match(info *FrameInfo, start float64) bool {
+	if f == nil || f.root == nil {
+		return true
+	}
+	return f.root.eval(&filterContext{info: info, start: start})
+}
+
+// String returns the source expression
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// Active reports whether the filter excludes anything
+func (f *Filter) Active() bool {
+	return f != nil && f.root != nil
+}
+
+// filterFrames returns the frames of one capture, in the order they were
+// read, that match the filter. As when streaming, the relative time field
+// counts from the first frame read, not the earliest timestamp.
+func filterFrames(frames []*FrameInfo, filter *Filter) []*FrameInfo {
+	if !filter.Active() || len(frames) == 0 {
+		return frames
+	}
+	start := frames[0].TimestampFloat
+	var matched []*FrameInfo
+	for _, f := range frames {
+		if filter.Match(f, start) {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+// captureStart returns the earliest timestamp in a capture
+func captureStart(frames []*FrameInfo) float64 {
+	if len(frames) == 0 {
+		return 0
+	}
+	start := frames[0].TimestampFloat
+	for _, f := range frames {
+		if f.TimestampFloat < start {
+			start = f.TimestampFloat
+		}
+	}
+	return start
+}
+
+// captureEnd returns the latest timestamp in a capture
+func captureEnd(frames []*FrameInfo) float64 {
+	if len(frames) == 0 {
+		return 0
+	}
+	end := frames[0].TimestampFloat
+	for _, f := range frames {
+		if f.TimestampFloat > end {
+			end = f.TimestampFloat
+		}
+	}
+	return end
+}
+
+// --- Lexer ---
+
+type filterTokenKind int
+
+const (
+	tokIdent filterTokenKind = iota
+	tokNumber
+	tokString
+	tokOp
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end == -1 {
+				return nil, fmt.Errorf("filter: unterminated string at %d", i)
+			}
+			tokens = append(tokens, filterToken{tokString, expr[i+1 : i+1+end]})
+			i += end + 2
+		case c >= '0' && c <= '9':
+			// Numbers may be hex without a prefix (18209820, 1A), so take
+			// all alphanumerics plus a decimal point followed by a digit
+			start := i
+			for i < len(expr) && (isIdentChar(expr[i]) ||
+				expr[i] == '.' && i+1 < len(expr) && expr[i+1] >= '0' && expr[i+1] <= '9') {
+				i++
+			}
+			tokens = append(tokens, filterToken{tokNumber, expr[start:i]})
+		case isIdentChar(c):
+			start := i
+			for i < len(expr) && isIdentChar(expr[i]) {
+				i++
+			}
+			// msg.<path> is a single token; path segments may be numbers or [N]
+			if expr[start:i] == "msg" {
+				for i < len(expr) && (expr[i] == '.' || expr[i] == '[') {
+					if expr[i] == '[' {
+						end := strings.IndexByte(expr[i:], ']')
+						if end == -1 {
+							return nil, fmt.Errorf("filter: unterminated [ at %d", i)
+						}
+						i += end + 1
+						continue
+					}
+					i++
+					for i < len(expr) && (isIdentChar(expr[i]) || expr[i] == '-') {
+						i++
+					}
+				}
+			}
+			// id.<field> is a single token too
+			if strings.EqualFold(expr[start:i], "id") && i+1 < len(expr) && expr[i] == '.' && isIdentChar(expr[i+1]) {
+				i++
+				for i < len(expr) && isIdentChar(expr[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, filterToken{tokIdent, expr[start:i]})
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "..", "<", ">", "!", "~", "&", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("filter: unexpected character %q at %d", c, i)
+			}
+			tokens = append(tokens, filterToken{tokOp, op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// --- Parser ---
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *filterParser) acceptOp(op string) bool {
+	if t := p.peek(); t != nil && t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.acceptOp("!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	if p.acceptOp("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptOp(")") {
+			return nil, fmt.Errorf("filter: missing )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	field, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+
+	// Optional mask: id & 0x1FFFFF00 == 0x18209800
+	if p.acceptOp("&") {
+		mask, err := p.parseNumber(true)
+		if err != nil {
+			return nil, err
+		}
+		field.mask = uint64(mask)
+		field.hasMask = true
+	}
+
+	t := p.peek()
+	if t == nil || t.kind == tokOp && (t.text == "&&" || t.text == "||" || t.text == ")") {
+		// Bare field: true if present and non-zero
+		return truthNode{field}, nil
+	}
+
+	if t.kind == tokIdent && t.text == "in" {
+		p.pos++
+		return p.parseIn(field)
+	}
+
+	if t.kind != tokOp {
+		return nil, fmt.Errorf("filter: expected operator after %s, got %q", field.name, t.text)
+	}
+	op := t.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "~":
+	default:
+		return nil, fmt.Errorf("filter: unexpected operator %q", op)
+	}
+	p.pos++
+
+	lit := p.peek()
+	if lit == nil || lit.kind == tokOp {
+		return nil, fmt.Errorf("filter: expected value after %s %s", field.name, op)
+	}
+	p.pos++
+
+	node := &compareNode{field: field, op: op, text: lit.text}
+	if op == "~" {
+		re, err := regexp.Compile("(?i)" + lit.text)
+		if err != nil {
+			return nil, fmt.Errorf("filter: invalid pattern %q: %v", lit.text, err)
+		}
+		node.re = re
+		return node, nil
+	}
+	// Buses may be given by name, e.g. bus==battery
+	if field.name == "bus" && lit.kind != tokNumber {
+		bus, ok := busNumber(lit.text)
+		if !ok {
+			return nil, fmt.Errorf("filter: unknown bus %q (name buses with -bus-names)", lit.text)
+		}
+		node.num, node.isNum = float64(bus), true
+		return node, nil
+	}
+	if lit.kind == tokNumber || field.numeric() {
+		n, err := parseFilterNumber(lit.text, field.hexDefault())
+		if err == nil {
+			node.num, node.isNum = n, true
+		} else if field.numeric() {
+			return nil, err
+		}
+		// Decoded fields fall back to comparing text, e.g. hex byte strings
+	}
+	return node, nil
+}
+
+func (p *filterParser) parseIn(field *filterField) (filterNode, error) {
+	if !p.acceptOp("[") {
+		return nil, fmt.Errorf("filter: expected [ after in")
+	}
+	node := &inNode{field: field}
+	for {
+		if p.acceptOp("]") {
+			return node, nil
+		}
+		lo, err := p.parseNumber(field.hexDefault())
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if p.acceptOp("..") {
+			if hi, err = p.parseNumber(field.hexDefault()); err != nil {
+				return nil, err
+			}
+		}
+		node.ranges = append(node.ranges, [2]float64{lo, hi})
+		if !p.acceptOp(",") && !(p.peek() != nil && p.peek().text == "]") {
+			return nil, fmt.Errorf("filter: expected , or ] in list")
+		}
+	}
+}
+
+func (p *filterParser) parseNumber(hex bool) (float64, error) {
+	t := p.peek()
+	if t == nil || t.kind == tokOp || t.kind == tokString {
+		return 0, fmt.Errorf("filter: expected number")
+	}
+	p.pos++
+	return parseFilterNumber(t.text, hex)
+}
+
+// parseFilterNumber parses a number literal. 0x always means hex; bare
+// numbers are hex for hex-displayed fields (id, hdr, bytes) and decimal otherwise.
+func parseFilterNumber(text string, hex bool) (float64, error) {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		text, hex = text[2:], true
+	}
+	if hex {
+		n, err := strconv.ParseUint(text, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("filter: invalid hex number %q", text)
+		}
+		return float64(n), nil
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("filter: invalid number %q", text)
+	}
+	return n, nil
+}
+
+func (p *filterParser) parseField() (*filterField, error) {
+	t := p.peek()
+	if t == nil || t.kind != tokIdent {
+		if t == nil {
+			return nil, fmt.Errorf("filter: unexpected end of expression")
+		}
+		return nil, fmt.Errorf("filter: expected field name, got %q", t.text)
+	}
+	p.pos++
+
+	field := &filterField{name: strings.ToLower(t.text), index: -1}
+	if strings.HasPrefix(t.text, "msg.") {
+		field.name = "msg"
+		field.path = splitFieldPath(strings.TrimPrefix(t.text, "msg."))
+		return field, nil
+	}
+	if name, ok := strings.CutPrefix(field.name, "id."); ok {
+		if _, known := idLayout.Field(CANID{Extended: true}, name); !known {
+			return nil, fmt.Errorf("filter: unknown ID field %q (choose a layout with -id-layout)", name)
+		}
+		field.name = "idfield"
+		field.path = []string{name}
+		return field, nil
+	}
+
+	switch field.name {
+	case "id", "bus", "dir", "ext", "type", "hdr", "header", "len", "t", "ts", "msg", "busstate", "state":
+	case "data", "payload":
+		if p.acceptOp("[") {
+			t := p.peek()
+			if t == nil || t.kind != tokNumber {
+				return nil, fmt.Errorf("filter: expected byte index after %s[", field.name)
+			}
+			p.pos++
+			idx, err := strconv.Atoi(t.text)
+			if err != nil {
+				return nil, fmt.Errorf("filter: invalid byte index %q", t.text)
+			}
+			if !p.acceptOp("]") {
+				return nil, fmt.Errorf("filter: missing ]")
+			}
+			field.index = idx
+		}
+	default:
+		return nil, fmt.Errorf("filter: unknown field %q", t.text)
+	}
+	if field.name == "header" {
+		field.name = "hdr"
+	}
+	return field, nil
+}
+
+// --- Evaluation ---
+
+type filterField struct {
+	name    string
+	index   int      // byte index for data[N]/payload[N], -1 otherwise
+	path    []string // path for msg.<path>, field name for id.<field>
+	mask    uint64
+	hasMask bool
+}
+
+// numeric reports whether the field always yields a number
+func (fd *filterField) numeric() bool {
+	switch fd.name {
+	case "id", "idfield", "bus", "hdr", "len", "t", "ts":
+		return true
+	case "data", "payload":
+		return fd.index >= 0
+	}
+	return false
+}
+
+// hexDefault reports whether bare numbers compared with this field are hex,
+// matching how the field is displayed
+func (fd *filterField) hexDefault() bool {
+	return fd.name == "id" || fd.name == "idfield" || fd.name == "hdr" || fd.index >= 0 || fd.hasMask
+}
+
+// value returns the field value for a frame: a float64, string or bool,
+// and false if the field is not available
+func (fd *filterField) value(ctx *filterContext) (interface{}, bool) {
+	info := ctx.info
+	frame := info.Frame
+	var v interface{}
+
+	switch fd.name {
+	case "id":
+		v = float64(frame.ID.Value)
+	case "idfield":
+		n, ok := idLayout.Field(frame.ID, fd.path[0])
+		if !ok {
+			return nil, false
+		}
+		v = float64(n)
+	case "bus":
+		v = float64(frame.Bus)
+	case "dir":
+		v = frame.Direction
+	case "ext":
+		v = frame.ID.Extended
+	case "type":
+		v = info.FrameType
+	case "busstate":
+		v = info.BusState
+	case "state":
+		v = info.State
+	case "hdr":
+		v = float64(info.Header)
+	case "len":
+		v = float64(len(frame.Data))
+	case "t":
+		v = info.TimestampFloat - ctx.start
+	case "ts":
+		v = info.TimestampFloat
+	case "data", "payload":
+		data := frame.Data
+		if fd.name == "payload" && len(data) > 0 {
+			data = data[1:]
+		}
+		if fd.index < 0 {
+			v = fmt.Sprintf("%X", data)
+		} else if fd.index < len(data) {
+			v = float64(data[fd.index])
+		} else {
+			return nil, false
+		}
+	case "msg":
+		if info.Message == nil {
+			return nil, false
+		}
+		if len(fd.path) == 0 {
+			return true, true
+		}
+		raw, ok := lookupField(info.Message.Item, fd.path)
+		if !ok {
+			return nil, false
+		}
+		if n, ok := fieldNumber(raw); ok {
+			if _, isBool := raw.(bool); !isBool {
+				v = n
+				break
+			}
+		}
+		if b, ok := raw.(bool); ok {
+			v = b
+			break
+		}
+		v = formatFieldValue(raw)
+	}
+
+	if fd.hasMask {
+		n, ok := v.(float64)
+		if !ok {
+			return nil, false
+		}
+		v = float64(uint64(n) & fd.mask)
+	}
+	return v, true
+}
+
+type orNode struct{ left, right filterNode }
+
+func (n orNode) eval(ctx *filterContext) bool { return n.left.eval(ctx) || n.right.eval(ctx) }
+
+type andNode struct{ left, right filterNode }
+
+func (n andNode) eval(ctx *filterContext) bool { return n.left.eval(ctx) && n.right.eval(ctx) }
+
+type notNode struct{ inner filterNode }
+
+func (n notNode) eval(ctx *filterContext) bool { return !n.inner.eval(ctx) }
+
+type truthNode struct{ field *filterField }
+
+func (n truthNode) eval(ctx *filterContext) bool {
+	v, ok := n.field.value(ctx)
+	if !ok {
+		return false
+	}
+	switch x := v.(type) {
+	case bool:
+		return x
+	case float64:
+		return x != 0
+	case string:
+		return x != ""
+	}
+	return false
+}
+
+type compareNode struct {
+	field *filterField
+	op    string
+	text  string
+	num   float64
+	isNum bool
+	re    *regexp.Regexp
+}
+
+func (n *compareNode) eval(ctx *filterContext) bool {
+	v, ok := n.field.value(ctx)
+	if !ok {
+		return false
+	}
+
+	if n.re != nil {
+		switch x := v.(type) {
+		case float64:
+			return n.re.MatchString(strconv.FormatFloat(x, 'f', -1, 64))
+		default:
+			return n.re.MatchString(fmt.Sprint(x))
+		}
+	}
+
+	switch x := v.(type) {
+	case float64:
+		if !n.isNum {
+			return false
+		}
+		return compareOrdered(x, n.num, n.op)
+	case bool:
+		want, err := strconv.ParseBool(n.text)
+		if err != nil {
+			return false
+		}
+		switch n.op {
+		case "==":
+			return x == want
+		case "!=":
+			return x != want
+		}
+		return false
+	case string:
+		cmp := strings.Compare(strings.ToUpper(x), strings.ToUpper(n.text))
+		switch n.op {
+		case "==":
+			return cmp == 0
+		case "!=":
+			return cmp != 0
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		case ">=":
+			return cmp >= 0
+		}
+	}
+	return false
+}
+
+func compareOrdered(a, b float64, op string) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+type inNode struct {
+	field  *filterField
+	ranges [][2]float64
+}
+
+func (n *inNode) eval(ctx *filterContext) bool {
+	v, ok := n.field.value(ctx)
+	if !ok {
+		return false
+	}
+	x, ok := v.(float64)
+	if !ok {
+		return false
+	}
+	for _, r := range n.ranges {
+		if x >= r[0] && x <= r[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexVersion is the version of the capture index file format
+const indexVersion = 1
+
+// defaultIndexPath is where index writes and search reads the index
+const defaultIndexPath = "canbus-index.json"
+
+// CaptureIndex describes every capture found under a set of directories
+type CaptureIndex struct {
+	Version int            `json:"version"`
+	Created time.Time      `json:"created"`
+	Roots   []string       `json:"roots"`
+	Files   []*IndexedFile `json:"files"`
+}
+
+// IndexedFile is the summary of one capture file
+type IndexedFile struct {
+	Path         string         `json:"path"`
+	Size         int64          `json:"size"`
+	Modified     time.Time      `json:"modified"`
+	Format       string         `json:"format,omitempty"`
+	Start        float64        `json:"start"`
+	End          float64        `json:"end"`
+	Duration     float64        `json:"duration"`
+	Frames       int            `json:"frames"`
+	Classes      map[string]int `json:"classes,omitempty"`
+	Messages     int            `json:"messages"`
+	Heartbeats   int            `json:"heartbeats"`
+	Unaccounted  int            `json:"unaccounted"`
+	SkippedLines int            `json:"skipped_lines,omitempty"`
+	Buses        []IndexedBus   `json:"buses,omitempty"`
+	IDs          []IndexedID    `json:"ids,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// IndexedBus is the traffic of one bus in a capture
+type IndexedBus struct {
+	Bus        int    `json:"bus"`
+	Name       string `json:"name"`
+	Frames     int    `json:"frames"`
+	IDs        int    `json:"ids"`
+	Messages   int    `json:"messages"`
+	Heartbeats int    `json:"heartbeats"`
+}
+
+// IndexedID is one CAN ID of a capture with the header bytes it was seen with
+type IndexedID struct {
+	ID       string   `json:"id"`
+	Bus      int      `json:"bus"`
+	Frames   int      `json:"frames"`
+	Messages int      `json:"messages"`
+	Headers  []string `json:"headers"`
+}
+
+// captureExtensions are the file extensions index picks up by default
+const captureExtensions = ".log,.csv,.txt"
+
+// indexCapture reads a capture and summarizes it
+func indexCapture(path string, progress bool) (*IndexedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entry := &IndexedFile{Path: path, Size: st.Size(), Modified: st.ModTime().UTC()}
+
+	type idStats struct {
+		frames, messages int
+		headers          [256]bool
+	}
+	ids := make(map[reassemblyKey]*idStats)
+	summary := NewCaptureSummary()
+	var reasm Reassembler
+	var r io.Reader = file
+	if progress {
+		pr := newProgressReader(file, path)
+		defer pr.Done()
+		r = pr
+	}
+	reader := NewFrameReader(r)
+	for {
+		info, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		summary.Observe(info)
+		msg := reasm.Add(info)
+		summary.Add(info, msg)
+
+		key := reassemblyKey{bus: info.Frame.Bus, id: info.Frame.ID}
+		s, ok := ids[key]
+		if !ok {
+			s = &idStats{}
+			ids[key] = s
+		}
+		s.frames++
+		s.headers[info.Header] = true
+		if msg != nil {
+			s.messages++
+		}
+	}
+
+	entry.Format = reader.Format()
+	entry.SkippedLines = reader.SkippedLines()
+	entry.Start, entry.End, entry.Duration = summary.Start, summary.End, summary.Duration()
+	entry.Frames = summary.Frames
+	entry.Classes = summary.Classes
+	entry.Messages, entry.Heartbeats, entry.Unaccounted = summary.Messages, summary.Heartbeats, summary.Unaccounted()
+	for _, b := range summary.Buses {
+		entry.Buses = append(entry.Buses, IndexedBus{
+			Bus: b.Bus, Name: busName(b.Bus), Frames: b.Frames, IDs: len(b.IDs),
+			Messages: b.Messages, Heartbeats: b.Heartbeats,
+		})
+	}
+	sort.Slice(entry.Buses, func(i, j int) bool { return entry.Buses[i].Bus < entry.Buses[j].Bus })
+	for key, s := range ids {
+		id := IndexedID{ID: key.id.String(), Bus: key.bus, Frames: s.frames, Messages: s.messages}
+		for h, seen := range s.headers {
+			if seen {
+				id.Headers = append(id.Headers, fmt.Sprintf("%02X", h))
+			}
+		}
+		entry.IDs = append(entry.IDs, id)
+	}
+	sort.Slice(entry.IDs, func(i, j int) bool {
+		if entry.IDs[i].Bus != entry.IDs[j].Bus {
+			return entry.IDs[i].Bus < entry.IDs[j].Bus
+		}
+		return entry.IDs[i].ID < entry.IDs[j].ID
+	})
+	return entry, nil
+}
+
+// findCaptures lists the files under roots with one of the extensions, sorted
+func findCaptures(roots []string, extensions []string, skip string) ([]string, error) {
+	var paths []string
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || path == skip {
+				return nil
+			}
+			if slices.Contains(extensions, strings.ToLower(filepath.Ext(path))) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(paths)
+	return slices.Compact(paths), nil
+}
+
+// readCaptureIndex reads an index written by index
+func readCaptureIndex(path string) (*CaptureIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var index CaptureIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if index.Version != indexVersion {
+		return nil, fmt.Errorf("%s: unsupported index version %d", path, index.Version)
+	}
+	return &index, nil
+}
+
+// writeCaptureIndex writes an index as indented JSON
+func writeCaptureIndex(path string, index *CaptureIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// unchanged reports whether a file still has the size and modification time
+// it had when it was indexed
+func (e *IndexedFile) unchanged() bool {
+	if e.Error != "" {
+		return false
+	}
+	st, err := os.Stat(e.Path)
+	return err == nil && st.Size() == e.Size && st.ModTime().UTC().Equal(e.Modified)
+}
+
+// runIndex implements the index subcommand: summarize every capture under
+// the given directories into an index file that search can query
+func runIndex(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	output := fs.String("o", defaultIndexPath, "index file to write")
+	extList := fs.String("ext", captureExtensions, "comma separated file extensions of captures")
+	jobs := fs.Int("j", runtime.NumCPU(), "number of files read in parallel")
+	rebuild := fs.Bool("rebuild", false, "re-read every capture instead of reusing unchanged entries of an existing index")
+	progress := fs.Bool("progress", false, "report how many files or bytes have been read on stderr")
+	busNameSpec := fs.String("bus-names", "", "names for bus numbers, e.g. '0=main,1=battery'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus index [flags] directory...")
+		fmt.Fprintln(fs.Output(), "Summarizes every capture under the directories into an index file for canbus search.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	names, err := parseBusNames(*busNameSpec)
+	if err != nil {
+		fail(err)
+	}
+	busNames = names
+
+	var extensions []string
+	for _, ext := range strings.Split(*extList, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+	paths, err := findCaptures(fs.Args(), extensions, *output)
+	if err != nil {
+		fail(err)
+	}
+
+	// Entries of files that did not change since the last run are kept
+	previous := make(map[string]*IndexedFile)
+	if !*rebuild {
+		old, err := readCaptureIndex(*output)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fail(err)
+		}
+		if old != nil {
+			for _, e := range old.Files {
+				previous[e.Path] = e
+			}
+		}
+	}
+
+	entries := make([]*IndexedFile, len(paths))
+	var stale []string
+	var staleIndex []int
+	for i, path := range paths {
+		if e := previous[path]; e != nil && e.unchanged() {
+			entries[i] = e
+			continue
+		}
+		stale = append(stale, path)
+		staleIndex = append(staleIndex, i)
+	}
+	failed := runFiles(stale, *jobs, *progress, nil, func(k int, path string, progress bool) error {
+		entry, err := indexCapture(path, progress)
+		if err != nil {
+			return err
+		}
+		entries[staleIndex[k]] = entry
+		return nil
+	})
+	for k, err := range failed {
+		var fileErr *FileError
+		if errors.As(err, &fileErr) {
+			entries[staleIndex[k]] = &IndexedFile{Path: fileErr.Path, Error: fileErr.Err.Error()}
+		}
+	}
+
+	index := &CaptureIndex{Version: indexVersion, Created: time.Now().UTC(), Roots: fs.Args(), Files: entries}
+	if err := writeCaptureIndex(*output, index); err != nil {
+		fail(err)
+	}
+	displayCaptureIndex(index, len(paths)-len(stale))
+	fmt.Printf("📝 Index written to %s\n", *output)
+}
+
+// displayCaptureIndex prints one line per capture and the totals
+func displayCaptureIndex(index *CaptureIndex, reused int) {
+	fmt.Println("===================================================")
+	fmt.Println("📇 CAPTURE INDEX")
+	fmt.Println("===================================================")
+	var frames, messages, errs int
+	var duration float64
+	ids := make(map[string]bool)
+	for _, e := range index.Files {
+		if e.Error != "" {
+			errs++
+			fmt.Printf("❌ %s: %s\n", e.Path, e.Error)
+			continue
+		}
+		frames += e.Frames
+		messages += e.Messages
+		duration += e.Duration
+		for _, id := range e.IDs {
+			ids[busQualifiedID(indexedCANID(id.ID), id.Bus)] = true
+		}
+		format := e.Format
+		if format == "" {
+			format = "no frames"
+		}
+		fmt.Printf("📄 %s: %s, %s, %d frames, %d IDs, %d CBOR messages, %d unaccounted\n",
+			e.Path, format, formatDuration(e.Duration), e.Frames, len(e.IDs), e.Messages, e.Unaccounted)
+	}
+	fmt.Println("\n===================================================")
+	fmt.Printf("📈 Summary:\n")
+	fmt.Printf("   Captures: %d (%d unchanged since the last run, %d unreadable)\n", len(index.Files), reused, errs)
+	fmt.Printf("   Total duration: %s\n", formatDuration(duration))
+	fmt.Printf("   Total frames: %d, CBOR messages: %d\n", frames, messages)
+	fmt.Printf("   Distinct IDs: %d\n", len(ids))
+	fmt.Println("===================================================")
+}
+
+// indexedCANID parses an ID written by the index, which is always valid
+func indexedCANID(text string) CANID {
+	id, err := ParseCANID(text)
+	if err != nil {
+		return CANID{}
+	}
+	return id
+}
+
+// runSearch implements the search subcommand: list the captures of an index
+// that contain given IDs, optionally with a header byte and on a bus
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	indexPath := fs.String("index", defaultIndexPath, "index file written by canbus index")
+	idList := fs.String("id", "", "comma separated CAN IDs that must all be present")
+	headerSpec := fs.String("hdr", "", "hex header byte the IDs must have been seen with, e.g. 93")
+	busSpec := fs.String("bus", "", "bus number or -bus-names name the IDs must be on")
+	minDuration := fs.Float64("min-duration", 0, "only captures lasting at least this many seconds")
+	showErrors := fs.Bool("errors", false, "list the captures that could not be read instead")
+	busNameSpec := fs.String("bus-names", "", "names for bus numbers, e.g. '0=main,1=battery'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus search [flags]")
+		fmt.Fprintln(fs.Output(), "Lists the captures of an index that match all given conditions.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	names, err := parseBusNames(*busNameSpec)
+	if err != nil {
+		fail(err)
+	}
+	busNames = names
+
+	var query IndexQuery
+	query.MinDuration = *minDuration
+	for _, field := range strings.Split(*idList, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := ParseCANID(field)
+		if err != nil {
+			fail(err)
+		}
+		query.IDs = append(query.IDs, id)
+	}
+	if *headerSpec != "" {
+		h, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(*headerSpec), "0x"), 16, 8)
+		if err != nil {
+			fail(fmt.Errorf("invalid header byte %q", *headerSpec))
+		}
+		query.Header = fmt.Sprintf("%02X", h)
+	}
+	if *busSpec != "" {
+		bus, err := parseBus(*busSpec)
+		if err != nil {
+			fail(err)
+		}
+		query.Bus = &bus
+	}
+
+	index, err := readCaptureIndex(*indexPath)
+	if err != nil {
+		fail(err)
+	}
+	matches := 0
+	for _, e := range index.Files {
+		if *showErrors {
+			if e.Error != "" {
+				matches++
+				fmt.Printf("❌ %s: %s\n", e.Path, e.Error)
+			}
+			continue
+		}
+		found, ok := query.Match(e)
+		if !ok {
+			continue
+		}
+		matches++
+		line := fmt.Sprintf("📄 %s (%s, %d frames)", e.Path, formatDuration(e.Duration), e.Frames)
+		var hits []string
+		for _, id := range found {
+			hits = append(hits, fmt.Sprintf("0x%s: %d frames", busQualifiedID(indexedCANID(id.ID), id.Bus), id.Frames))
+		}
+		if len(hits) > 0 {
+			line += " " + strings.Join(hits, ", ")
+		}
+		fmt.Println(line)
+	}
+	fmt.Printf("%d of %d captures match\n", matches, len(index.Files))
+}
+
+// IndexQuery selects captures of an index
+type IndexQuery struct {
+	IDs         []CANID // all must be present
+	Header      string  // hex header byte the IDs were seen with, "" for any
+	Bus         *int    // bus the IDs were seen on, nil for any
+	MinDuration float64
+}
+
+// Match reports whether a capture satisfies the query and returns the
+// matching ID entries
+func (q *IndexQuery) Match(e *IndexedFile) ([]IndexedID, bool) {
+	if e.Error != "" || e.Duration < q.MinDuration {
+		return nil, false
+	}
+	var found []IndexedID
+	for _, want := range q.IDs {
+		matched := false
+		for _, id := range e.IDs {
+			if indexedCANID(id.ID) != want {
+				continue
+			}
+			if q.Bus != nil && id.Bus != *q.Bus {
+				continue
+			}
+			if q.Header != "" && !slices.Contains(id.Headers, q.Header) {
+				continue
+			}
+			found = append(found, id)
+			matched = true
+		}
+		if !matched {
+			return nil, false
+		}
+	}
+	return found, true
+}
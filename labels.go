@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Thresholds for labeled segment comparison
+const (
+	labelMinRateRatio = 2.0 // rate inside a label vs. elsewhere to count as more frequent
+	labelMinZScore    = 3.0 // binomial z-score a rate difference must reach
+	labelListLimit    = 20  // patterns listed per section
+)
+
+// Segment is a labeled time range of a capture
+type Segment struct {
+	Start float64
+	End   float64
+	Label string
+}
+
+// labelEnd is the label that ends the current segment without starting a new one
+const labelEnd = "-"
+
+// segmentsFromMarkers turns a label file, read as markers, into segments: each
+// marker starts a segment that lasts until the next marker or the capture end.
+// A marker labeled "-" only ends the running segment.
+func segmentsFromMarkers(markers []Marker, end float64) []Segment {
+	var segments []Segment
+	for i, m := range markers {
+		if m.Label == labelEnd {
+			continue
+		}
+		// Segments are half-open, so the last one must reach past the last frame
+		segEnd := math.Nextafter(end, math.Inf(1))
+		if i+1 < len(markers) {
+			segEnd = markers[i+1].Time
+		}
+		if segEnd > m.Time {
+			segments = append(segments, Segment{Start: m.Time, End: segEnd, Label: m.Label})
+		}
+	}
+	return segments
+}
+
+// appendLabel appends one "timestamp label" line to a label file
+func appendLabel(path string, ts float64, label string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(file, "%.6f %s\n", ts, label); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// labelStats holds how often one frame pattern or ID was seen per label
+type labelStats struct {
+	Key    string
+	Counts map[string]int
+	Total  int
+}
+
+// compareLabels counts unaccounted frames per label under the given key,
+// e.g. the ID+header+data pattern or just ID+header
+func compareLabels(frames []*FrameInfo, segments []Segment, key func(*FrameInfo) string) map[string]*labelStats {
+	stats := make(map[string]*labelStats)
+	for _, f := range frames {
+		if f.IsCBOR || f.IsHeartbeat {
+			continue
+		}
+		label, ok := segmentLabel(segments, f.TimestampFloat)
+		if !ok {
+			continue
+		}
+		k := key(f)
+		s, ok := stats[k]
+		if !ok {
+			s = &labelStats{Key: k, Counts: make(map[string]int)}
+			stats[k] = s
+		}
+		s.Counts[label]++
+		s.Total++
+	}
+	return stats
+}
+
+// segmentLabel returns the label of the segment containing ts
+func segmentLabel(segments []Segment, ts float64) (string, bool) {
+	for _, seg := range segments {
+		if ts >= seg.Start && ts < seg.End {
+			return seg.Label, true
+		}
+	}
+	return "", false
+}
+
+// rateZScore returns a binomial z-score for count out of total falling into
+// a label that covers share of the labeled time
+func rateZScore(count, total int, share float64) float64 {
+	n := float64(total)
+	sd := math.Sqrt(n * share * (1 - share))
+	if sd == 0 {
+		return 0
+	}
+	return (float64(count) - n*share) / sd
+}
+
+// displayLabelComparison compares unaccounted frame patterns and CAN IDs
+// between the labeled segments of one capture, listing per label what is
+// exclusive to it and what occurs significantly more often than elsewhere
+func displayLabelComparison(frames []*FrameInfo, segments []Segment) {
+	durations := make(map[string]float64)
+	var labels []string
+	labeledTime := 0.0
+	for _, seg := range segments {
+		if _, ok := durations[seg.Label]; !ok {
+			labels = append(labels, seg.Label)
+		}
+		durations[seg.Label] += seg.End - seg.Start
+		labeledTime += seg.End - seg.Start
+	}
+
+	fmt.Println("\n===================================================")
+	fmt.Println("🏷️  LABELED SEGMENT COMPARISON (unaccounted frames)")
+	fmt.Println("===================================================")
+	fmt.Println("Labels:")
+	for _, label := range labels {
+		count := 0
+		for _, seg := range segments {
+			if seg.Label == label {
+				count++
+			}
+		}
+		fmt.Printf("  %-20s %d segments, %.3f s\n", label, count, durations[label])
+	}
+
+	if len(labels) < 2 || labeledTime <= 0 {
+		fmt.Println("\nNeed at least two labels to compare")
+		fmt.Println("\n===================================================")
+		return
+	}
+
+	levels := []struct {
+		name string
+		key  func(*FrameInfo) string
+	}{
+		{"CAN IDs", func(f *FrameInfo) string { return fmt.Sprintf("ID:0x%s Hdr:%02X", frameSourceID(f.Frame), f.Header) }},
+		{"patterns", func(f *FrameInfo) string {
+			return fmt.Sprintf("ID:0x%s Hdr:%02X Data:%X", frameSourceID(f.Frame), f.Header, f.Frame.Data)
+		}},
+	}
+
+	levelStats := make([]map[string]*labelStats, len(levels))
+	for i, level := range levels {
+		levelStats[i] = compareLabels(frames, segments, level.key)
+	}
+
+	for _, label := range labels {
+		share := durations[label] / labeledTime
+		fmt.Printf("\n🏷️  %s\n", label)
+		fmt.Println(strings.Repeat("-", 70))
+
+		for i, level := range levels {
+			var exclusive, frequent []*labelStats
+			for _, s := range levelStats[i] {
+				count := s.Counts[label]
+				if count == 0 {
+					continue
+				}
+				if count == s.Total {
+					exclusive = append(exclusive, s)
+					continue
+				}
+				// Rates per second inside and outside the label
+				inside := float64(count) / durations[label]
+				outside := float64(s.Total-count) / (labeledTime - durations[label])
+				if inside >= labelMinRateRatio*outside && rateZScore(count, s.Total, share) >= labelMinZScore {
+					frequent = append(frequent, s)
+				}
+			}
+			sortLabelStats(exclusive, label)
+			sortLabelStats(frequent, label)
+
+			fmt.Printf("  🔸 %s exclusive to %q: %d\n", level.name, label, len(exclusive))
+			for j, s := range exclusive {
+				if j == labelListLimit {
+					fmt.Printf("    ... and %d more\n", len(exclusive)-labelListLimit)
+					break
+				}
+				fmt.Printf("    %s (count: %d)\n", s.Key, s.Counts[label])
+			}
+
+			fmt.Printf("  ⬆️  %s more frequent in %q: %d\n", level.name, label, len(frequent))
+			for j, s := range frequent {
+				if j == labelListLimit {
+					fmt.Printf("    ... and %d more\n", len(frequent)-labelListLimit)
+					break
+				}
+				count := s.Counts[label]
+				inside := float64(count) / durations[label]
+				outside := float64(s.Total-count) / (labeledTime - durations[label])
+				fmt.Printf("    %s (%.1f/s vs %.1f/s elsewhere, z=%.1f)\n",
+					s.Key, inside, outside, rateZScore(count, s.Total, share))
+			}
+		}
+	}
+
+	fmt.Println("\n===================================================")
+}
+
+// sortLabelStats sorts by count within the label, most frequent first
+func sortLabelStats(stats []*labelStats, label string) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Counts[label] != stats[j].Counts[label] {
+			return stats[i].Counts[label] > stats[j].Counts[label]
+		}
+		return stats[i].Key < stats[j].Key
+	})
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// latencyMaxListed is the number of unanswered requests and timeouts listed
+// per pair
+const latencyMaxListed = 10
+
+// MessagePair is a request ID and the ID that answers it, as bus-qualified
+// IDs like msg.SourceID()
+type MessagePair struct {
+	Request, Response string
+	Configured        bool     // given with -pairs rather than detected
+	SharedKeys        []string // top-level CBOR keys seen in both
+}
+
+// Exchange is one request with its outcome
+type Exchange struct {
+	Request  *Message
+	Response *Message // nil if unanswered
+	Latency  float64  // seconds from request to response completion
+	TimedOut bool     // answered, but later than the timeout
+}
+
+// PairLatency is the outcome of all requests of one pair
+type PairLatency struct {
+	Pair        *MessagePair
+	Exchanges   []*Exchange
+	Answered    int
+	TimedOut    int
+	Unanswered  int
+	Unsolicited int // responses without a preceding request
+	Latencies   []float64
+}
+
+// parseMessagePairs parses request/response pairs such as
+// "14609460>14609461,123>456@1". A bus given on either side applies to both.
+func parseMessagePairs(spec string) ([]*MessagePair, error) {
+	var pairs []*MessagePair
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		reqText, respText, ok := strings.Cut(entry, ">")
+		if !ok {
+			return nil, fmt.Errorf("pairs: expected REQUEST>RESPONSE, got %q", entry)
+		}
+		reqText, reqBus, _ := strings.Cut(reqText, "@")
+		respText, respBus, _ := strings.Cut(respText, "@")
+		busText := reqBus
+		if busText == "" {
+			busText = respBus
+		}
+		bus := 0
+		if busText != "" {
+			n, err := parseBus(busText)
+			if err != nil {
+				return nil, fmt.Errorf("pairs: %v", err)
+			}
+			bus = n
+		}
+		req, err := ParseCANID(reqText)
+		if err != nil {
+			return nil, fmt.Errorf("pairs: %v", err)
+		}
+		resp, err := ParseCANID(respText)
+		if err != nil {
+			return nil, fmt.Errorf("pairs: %v", err)
+		}
+		pairs = append(pairs, &MessagePair{
+			Request:    busQualifiedID(req, bus),
+			Response:   busQualifiedID(resp, bus),
+			Configured: true,
+		})
+	}
+	return pairs, nil
+}
+
+// topLevelKeys returns the keys of a decoded CBOR map, or nil for other items
+func topLevelKeys(item interface{}) []string {
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, formatFieldKey(k))
+	}
+	return keys
+}
+
+// messagesByID groups decoded messages by bus-qualified ID, in capture order
+func messagesByID(messages []*Message) ([]string, map[string][]*Message) {
+	var ids []string
+	byID := make(map[string][]*Message)
+	for _, msg := range messages {
+		id := msg.SourceID()
+		if _, ok := byID[id]; !ok {
+			ids = append(ids, id)
+		}
+		byID[id] = append(byID[id], msg)
+	}
+	sort.Strings(ids)
+	return ids, byID
+}
+
+// sharedKeys returns the top-level keys that occur in messages of both IDs
+func sharedKeys(a, b []*Message) []string {
+	inA := make(map[string]bool)
+	for _, msg := range a {
+		for _, k := range topLevelKeys(msg.Item) {
+			inA[k] = true
+		}
+	}
+	shared := make(map[string]bool)
+	for _, msg := range b {
+		for _, k := range topLevelKeys(msg.Item) {
+			if inA[k] {
+				shared[k] = true
+			}
+		}
+	}
+	keys := sortedKeys(shared)
+	sortFieldKeys(keys)
+	return keys
+}
+
+// hasMapItems reports whether any message decodes to a CBOR map
+func hasMapItems(messages []*Message) bool {
+	for _, msg := range messages {
+		if _, ok := msg.Item.(map[interface{}]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// detectMessagePairs finds IDs whose messages reliably follow the messages of
+// another ID on the same bus within the window, using the same timing test
+// as -topology. Where both sides decode to maps, they must also share a key.
+func detectMessagePairs(ids []string, byID map[string][]*Message, window, duration float64) []*MessagePair {
+	activities := make([]*IDActivity, len(ids))
+	for i, id := range ids {
+		msgs := byID[id]
+		a := &IDActivity{Source: id, ID: msgs[0].ID, Bus: msgs[0].Bus, Messages: len(msgs)}
+		for _, msg := range msgs {
+			a.events = append(a.events, msg.Timestamp)
+		}
+		activities[i] = a
+	}
+
+	var pairs []*MessagePair
+	for _, req := range activities {
+		if len(req.events) < responseMinRequests {
+			continue
+		}
+		for _, resp := range activities {
+			if resp == req || resp.Bus != req.Bus {
+				continue
+			}
+			if matchResponses(req, resp, window, duration) == nil {
+				continue
+			}
+			reqMsgs, respMsgs := byID[req.Source], byID[resp.Source]
+			shared := sharedKeys(reqMsgs, respMsgs)
+			if len(shared) == 0 && hasMapItems(reqMsgs) && hasMapItems(respMsgs) {
+				continue
+			}
+			pairs = append(pairs, &MessagePair{Request: req.Source, Response: resp.Source, SharedKeys: shared})
+		}
+	}
+	return pairs
+}
+
+// pairExchanges matches each response with a pending request of the pair:
+// the most recent one whose values of the shared keys equal those of the
+// response, e.g. an echoed command or sequence number, or else the most
+// recent one. Requests pending for longer than the timeout expire: only a
+// response with equal shared key values can still claim one, as a timeout,
+// so that a request never answered does not take an unrelated response.
+func pairExchanges(pair *MessagePair, requests, responses []*Message, timeout float64) *PairLatency {
+	result := &PairLatency{Pair: pair}
+	exchanges := make([]*Exchange, len(requests))
+	for i, req := range requests {
+		exchanges[i] = &Exchange{Request: req}
+	}
+
+	next := 0 // first request not yet sent at the time of the response
+	var pending, expired []*Exchange
+	for _, resp := range responses {
+		for next < len(requests) && requests[next].Timestamp < resp.Timestamp {
+			pending = append(pending, exchanges[next])
+			next++
+		}
+		n := 0
+		for n < len(pending) && resp.Timestamp-pending[n].Request.Timestamp > timeout {
+			n++
+		}
+		if len(pair.SharedKeys) > 0 {
+			expired = append(expired, pending[:n]...)
+		}
+		pending = pending[n:]
+
+		match, late := -1, false
+		for i := len(pending) - 1; i >= 0; i-- {
+			if sameKeyValues(pending[i].Request.Item, resp.Item, pair.SharedKeys) {
+				match = i
+				break
+			}
+		}
+		if match < 0 {
+			for i := len(expired) - 1; i >= 0; i-- {
+				if sameKeyValues(expired[i].Request.Item, resp.Item, pair.SharedKeys) {
+					match, late = i, true
+					break
+				}
+			}
+		}
+		if match < 0 && len(pending) > 0 {
+			match = len(pending) - 1
+		}
+		if match < 0 {
+			result.Unsolicited++
+			continue
+		}
+		var ex *Exchange
+		if late {
+			ex = expired[match]
+			expired = slices.Delete(expired, match, match+1)
+		} else {
+			ex = pending[match]
+			pending = slices.Delete(pending, match, match+1)
+		}
+		ex.Response = resp
+		ex.Latency = resp.Timestamp - ex.Request.Timestamp
+		ex.TimedOut = ex.Latency > timeout
+	}
+
+	for _, ex := range exchanges {
+		switch {
+		case ex.Response == nil:
+			result.Unanswered++
+		case ex.TimedOut:
+			result.TimedOut++
+		default:
+			result.Answered++
+			result.Latencies = append(result.Latencies, ex.Latency)
+		}
+	}
+	result.Exchanges = exchanges
+	return result
+}
+
+// sameKeyValues reports whether two decoded maps have equal values for all
+// of the given top-level keys they both contain
+func sameKeyValues(a, b interface{}, keys []string) bool {
+	ma, okA := a.(map[interface{}]interface{})
+	mb, okB := b.(map[interface{}]interface{})
+	if !okA || !okB || len(keys) == 0 {
+		return false
+	}
+	values := make(map[string]string, len(ma))
+	for k, v := range ma {
+		values[formatFieldKey(k)] = formatFieldValue(v)
+	}
+	compared := 0
+	for k, v := range mb {
+		key := formatFieldKey(k)
+		va, ok := values[key]
+		if !ok || !slices.Contains(keys, key) {
+			continue
+		}
+		if va != formatFieldValue(v) {
+			return false
+		}
+		compared++
+	}
+	return compared > 0
+}
+
+// analyzeLatency pairs the CBOR messages of a capture by the configured
+// pairs, or by pairs detected within the window if none are given
+func analyzeLatency(frames []*FrameInfo, pairs []*MessagePair, window, timeout float64) []*PairLatency {
+	ids, byID := messagesByID(captureMessages(frames))
+	if len(pairs) == 0 {
+		pairs = detectMessagePairs(ids, byID, window, captureEnd(frames)-captureStart(frames))
+	} else {
+		for _, p := range pairs {
+			p.SharedKeys = sharedKeys(byID[p.Request], byID[p.Response])
+		}
+	}
+	results := make([]*PairLatency, len(pairs))
+	for i, p := range pairs {
+		results[i] = pairExchanges(p, byID[p.Request], byID[p.Response], timeout)
+	}
+	return results
+}
+
+// percentile returns the p-th percentile (0-100) of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// displayLatency prints the round-trip statistics, timeouts and unanswered
+// requests of each pair
+func displayLatency(results []*PairLatency, timeout float64) {
+	fmt.Println("\n===================================================")
+	fmt.Println("⏱️  REQUEST/RESPONSE LATENCY")
+	fmt.Println("===================================================")
+	fmt.Printf("Timeout: %s\n", formatDuration(timeout))
+	if len(results) == 0 {
+		fmt.Println("\nNo request/response pairs found (configure them with -pairs)")
+		fmt.Println("===================================================")
+		return
+	}
+
+	totalRequests, totalAnswered, totalTimedOut, totalUnanswered := 0, 0, 0, 0
+	for _, r := range results {
+		origin := "detected"
+		if r.Pair.Configured {
+			origin = "configured"
+		}
+		keys := "none"
+		if len(r.Pair.SharedKeys) > 0 {
+			keys = strings.Join(r.Pair.SharedKeys, ",")
+		}
+		fmt.Printf("\n📨 0x%s → 0x%s (%s, shared keys: %s)\n", r.Pair.Request, r.Pair.Response, origin, keys)
+		fmt.Println(strings.Repeat("-", 60))
+
+		requests := len(r.Exchanges)
+		totalRequests += requests
+		totalAnswered += r.Answered
+		totalTimedOut += r.TimedOut
+		totalUnanswered += r.Unanswered
+		share := 0.0
+		if requests > 0 {
+			share = 100 * float64(r.Answered) / float64(requests)
+		}
+		fmt.Printf("  Requests: %d, answered: %d (%.1f%%), timeouts: %d, unanswered: %d, unsolicited responses: %d\n",
+			requests, r.Answered, share, r.TimedOut, r.Unanswered, r.Unsolicited)
+
+		if len(r.Latencies) > 0 {
+			sorted := append([]float64(nil), r.Latencies...)
+			sort.Float64s(sorted)
+			sum := 0.0
+			for _, l := range sorted {
+				sum += l
+			}
+			fmt.Printf("  Latency: min %s, median %s, p95 %s, max %s, mean %s\n",
+				formatDuration(sorted[0]), formatDuration(percentile(sorted, 50)), formatDuration(percentile(sorted, 95)),
+				formatDuration(sorted[len(sorted)-1]), formatDuration(sum/float64(len(sorted))))
+		}
+
+		var timeouts, unanswered []string
+		for _, ex := range r.Exchanges {
+			switch {
+			case ex.Response == nil:
+				unanswered = append(unanswered, fmt.Sprintf("%.6f", ex.Request.Timestamp))
+			case ex.TimedOut:
+				timeouts = append(timeouts, fmt.Sprintf("%.6f (after %s)", ex.Request.Timestamp, formatDuration(ex.Latency)))
+			}
+		}
+		printTimes := func(label string, times []string) {
+			if len(times) == 0 {
+				return
+			}
+			fmt.Printf("  %s:\n", label)
+			for _, t := range times[:min(len(times), latencyMaxListed)] {
+				fmt.Printf("    %s\n", t)
+			}
+			if len(times) > latencyMaxListed {
+				fmt.Printf("    ... and %d more\n", len(times)-latencyMaxListed)
+			}
+		}
+		printTimes("⌛ Timed out requests", timeouts)
+		printTimes("❌ Unanswered requests", unanswered)
+	}
+
+	fmt.Println("\n===================================================")
+	fmt.Printf("📈 Summary:\n")
+	fmt.Printf("   Pairs: %d\n", len(results))
+	fmt.Printf("   Requests: %d\n", totalRequests)
+	fmt.Printf("   Answered in time: %d\n", totalAnswered)
+	fmt.Printf("   Timeouts: %d\n", totalTimedOut)
+	fmt.Printf("   Unanswered: %d\n", totalUnanswered)
+	fmt.Println("===================================================")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// readLiveFrames reads frames from a SocketCAN interface, timestamps them with
+// the wall clock and sends them classified to out until the socket fails
+func readLiveFrames(sock *canSocket, out chan<- *FrameInfo) error {
+	sequenceNum := 0
+	for {
+		frame, err := sock.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if len(frame.Data) == 0 {
+			continue
+		}
+
+		ts := float64(time.Now().UnixMicro()) / 1_000_000
+		frame.Timestamp = strconv.FormatFloat(ts, 'f', 6, 64)
+		frame.Direction = "Rx"
+		sequenceNum++
+		out <- newFrameInfo(frame, ts, sequenceNum)
+	}
+}
@@ -1,28 +1,96 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
-	"strconv"
-	"strings"
-
-	"github.com/fxamacker/cbor/v2"
+	"slices"
 )
 
 const Version = "0.1.0"
 
 func main() {
+	// Subcommands take precedence over the flag-driven decoder modes
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "encode":
+			runEncode(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "tui":
+			runTUI(os.Args[2:])
+			return
+		case "convert":
+			runConvert(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "sqlite":
+			runSQLite(os.Args[2:])
+			return
+		case "index":
+			runIndex(os.Args[2:])
+			return
+		case "search":
+			runSearch(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		}
+	}
+
 	version := flag.Bool("version", false, "show version information")
 	unaccountedOnly := flag.Bool("unaccounted-only", false, "only display frames that are not CBOR or heartbeat/keep-alive")
 	hideUnaccounted := flag.Bool("hide-unaccounted", false, "hide unaccounted frames, show only decoded CBOR and heartbeat frames")
 	hideAccounted := flag.Bool("hide-accounted", false, "hide accounted frames (CBOR and heartbeat), show only unaccounted frames")
 	groupByID := flag.Bool("group-by-id", false, "group frames by CAN ID, then sort by timestamp within each group")
+	spoolFrames := flag.Int("spool-frames", defaultSpoolFrames, "with -group-by-id and -diff, frames kept in memory before sorted runs are spilled to temporary files")
+	progress := flag.Bool("progress", false, "report how much input has been read, with rate and remaining time, on stderr")
 	compareMode := flag.Bool("compare", false, "compare unaccounted frames across multiple files (provide file paths as arguments)")
+	jobs := flag.Int("j", runtime.NumCPU(), "with -compare, number of files read in parallel")
+	compareStats := flag.Bool("compare-stats", false, "with -compare, compare per-ID rates and per-byte value distributions instead of exact patterns")
+	compareCBOR := flag.Bool("compare-cbor", false, "with -compare, diff decoded CBOR messages: message shapes and values per field")
+	maskSpec := flag.String("mask", "", "with -compare, ignore payload bytes per CAN ID, e.g. '14609460:6,7 123@1:1' (byte 0 = header, ID@* = on any bus, * = any ID)")
+	maskCounters := flag.Bool("mask-counters", false, "with -compare, also ignore bytes detected as rolling counters")
+	reportPath := flag.String("report", "", "with -compare, also write the pattern comparison to a .json, .csv or .html file")
+	diffMode := flag.Bool("diff", false, "per CAN ID, print only payload changes with changed bytes/bits and per-byte statistics")
+	diffByHeader := flag.Bool("diff-by-header", false, "with -diff, track payloads per CAN ID and header byte")
+	color := flag.Bool("color", isTerminal(os.Stdout), "highlight changed bytes with ANSI colors")
+	discoverMode := flag.Bool("discover", false, "detect counters, constants, checksums and signals in unaccounted frames per CAN ID and header")
+	dbcPath := flag.String("dbc", "", "with -discover, write a draft DBC signal definition to this file")
+	correlateMode := flag.Bool("correlate", false, "rank raw bytes/bits by correlation with decoded CBOR fields and by coincidence with -markers")
+	markersPath := flag.String("markers", "", "with -correlate, a file of event markers, one 'timestamp label' per line ('+' prefix = relative to capture start)")
+	correlateField := flag.String("correlate-field", "", "with -correlate, only correlate this decoded CBOR field path, e.g. '3.[1]'")
+	resampleStep := flag.Float64("resample", 0.1, "with -correlate, common timeline step in seconds")
+	markerWindow := flag.Float64("marker-window", 0.5, "with -correlate, seconds around a marker in which a change counts as a hit")
+	labelsPath := flag.String("labels", "", "compare unaccounted frames between labeled segments; file lines 'timestamp label' start a segment, label '-' ends one")
+	plotPath := flag.String("plot", "", "draw a frame timeline, per-ID rates and -plot-series to a standalone .svg or .html file")
+	plotSeries := flag.String("plot-series", "", "with -plot, series to draw, e.g. 'cbor:14609460:3.[1],byte:123:81:3,u16le:14609460:*:3'")
+	topologyMode := flag.Bool("topology", false, "group CAN IDs into nodes and show which node sends what and request/response pairs")
+	nodesPath := flag.String("nodes", "", "with -topology, node names file with lines 'name pattern...', e.g. 'motor 146094??' ('?' matches any hex digit)")
+	nodeMaskSpec := flag.String("node-mask", "1FFFFF00", "with -topology, hex mask of the ID bits that identify an unnamed node")
+	responseWindow := flag.Float64("response-window", 0.05, "with -topology and -latency, seconds within which a frame counts as a response when detecting pairs")
+	topologyDOT := flag.String("topology-dot", "", "with -topology, also write the node graph as Graphviz DOT to a file")
+	latencyMode := flag.Bool("latency", false, "pair CBOR requests with their responses and report round-trip latencies, timeouts and unanswered requests")
+	pairSpec := flag.String("pairs", "", "with -latency, request>response ID pairs, e.g. '14609460>14609461,123>456@1'; detected by timing if empty")
+	latencyTimeout := flag.Float64("timeout", 0.5, "with -latency, seconds after which a response counts as a timeout")
+	statesMode := flag.Bool("states", false, "track bus states (silent, waking, normal, sleeping) and -state-rules sequences, marking transitions in the frame stream")
+	stateRulesPath := flag.String("state-rules", "", "with -states, rules file with thresholds and sequences, e.g. 'sequence unlock within 2 -> unlocked: msg.1==4; id==123'")
+	transfersMode := flag.Bool("transfers", false, "detect bulk transfers (firmware updates), reassemble their bytes and report progress, gaps and checksums")
+	transferDir := flag.String("transfer-dir", "", "with -transfers, write each reassembled image to a .bin file in this directory")
+	transferIDs := flag.String("transfer-ids", "", "with -transfers, comma-separated IDs whose frames are all transfer data")
+	transferMin := flag.Int("transfer-min", 1024, "with -transfers, smallest session in bytes reported on other IDs")
+	transferGap := flag.Float64("transfer-gap", 2.0, "with -transfers, seconds of pause that end a session")
+	filterExpr := flag.String("filter", "", "only show frames matching an expression, e.g. 'id==18209820 && type==CONT && t>12.5'")
+	idLayoutSpec := flag.String("id-layout", "", "decompose extended IDs into fields for display and id.<field> filters: 'j1939' or 'name:shift:bits,...'")
+	busNameSpec := flag.String("bus-names", "", "names for bus numbers, e.g. '0=main,1=battery'; IDs on other buses than 0 are shown as ID@name")
 	flag.Parse()
 
 	if *version {
@@ -31,6 +99,117 @@ func main() {
 		return
 	}
 
+	names, err := parseBusNames(*busNameSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	busNames = names
+
+	idLayout, err = parseIDLayout(*idLayoutSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	filter, err := ParseFilter(*filterExpr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if *correlateMode && (*resampleStep <= 0 || *markerWindow < 0) {
+		fmt.Println("Error: -resample must be positive and -marker-window must not be negative")
+		os.Exit(1)
+	}
+
+	var nodeConfig *NodeConfig
+	var nodeMask uint32
+	if (*topologyMode || *latencyMode) && *responseWindow <= 0 {
+		fmt.Println("Error: -response-window must be positive")
+		os.Exit(1)
+	}
+	if *topologyMode {
+		nodeMask, err = parseNodeMask(*nodeMaskSpec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if *nodesPath != "" {
+			nodeConfig, err = readNodeConfig(*nodesPath)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	var stateTracker *StateTracker
+	if *statesMode {
+		rules := defaultStateRules()
+		if *stateRulesPath != "" {
+			rules, err = readStateRules(*stateRulesPath)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+		stateTracker = NewStateTracker(rules)
+	}
+
+	transferForced, err := parseIDList(*transferIDs)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if *transfersMode && *transferGap <= 0 {
+		fmt.Println("Error: -transfer-gap must be positive")
+		os.Exit(1)
+	}
+
+	if *latencyMode && *latencyTimeout <= 0 {
+		fmt.Println("Error: -timeout must be positive")
+		os.Exit(1)
+	}
+	messagePairs, err := parseMessagePairs(*pairSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	mask, err := ParseByteMask(*maskSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	plotSelectors, err := parsePlotSelectors(*plotSeries)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if *plotPath != "" {
+		if err := checkPlotPath(*plotPath); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *compareStats && *compareCBOR {
+		fmt.Println("Error: -compare-stats and -compare-cbor cannot be combined")
+		os.Exit(1)
+	}
+	if *reportPath != "" {
+		if *compareStats || *compareCBOR {
+			fmt.Println("Error: -report writes the pattern comparison and cannot be combined with -compare-stats or -compare-cbor")
+			os.Exit(1)
+		}
+		if _, err := comparisonReportWriter(*reportPath); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+
 	// Compare mode: process multiple files
 	if *compareMode {
 		files := flag.Args()
@@ -39,25 +218,37 @@ func main() {
 			fmt.Println("Usage: canbus -compare file1.csv file2.csv [file3.csv file4.csv ...]")
 			os.Exit(1)
 		}
-		compareFiles(files)
+		mode := "patterns"
+		if *compareStats {
+			mode = "stats"
+		} else if *compareCBOR {
+			mode = "cbor"
+		}
+		if err := compareFiles(files, filter, mask, *maskCounters, mode, *reportPath, *jobs, *progress); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	// Buffer to accumulate CBOR data from multiple CAN frames
-	var cborBuffer []byte
-	var lastCanID string
-	var frameCount int
-	var minTimestamp float64 = float64(^uint64(0) >> 1) // Max float
-	var maxTimestamp float64 = 0
-	var isCSV bool
-	var captureStarted bool
-	var cborMessageCount int
-	var heartbeatCount int
-	var totalFramesProcessed int
-	var allFrames []*FrameInfo // For grouping mode
+	// Reassembles CBOR data from multiple CAN frames
+	var reasm Reassembler
+	var allFrames []*FrameInfo // For analysis modes
+	var spool *FrameSpool      // For grouping mode
+	var diffSpool *FrameSpool  // For payload diffs
+	var gate frameGate         // Reassembles CBOR while collecting
+	stream := filteredStream{filter: filter}
+	var stateEvents []*StateEvent
+	summary := NewCaptureSummary()
 
 	// Main Loop: Read Stdin
-	scanner := bufio.NewScanner(os.Stdin)
+	var input io.Reader = os.Stdin
+	var progressInput *progressReader
+	if *progress {
+		progressInput = newProgressReader(os.Stdin, "stdin")
+		input = progressInput
+	}
+	reader := NewFrameReader(input)
 	fmt.Println("VanMoof CAN Bus Decoder")
 	fmt.Println("Supports: CSV format (SavvyCAN) and candump format")
 	fmt.Println("Protocol: Ax = Start Frame, 1x = Continuation")
@@ -69,6 +260,27 @@ func main() {
 		} else if *hideAccounted {
 			return "Unaccounted frames only (hiding accounted)"
 		}
+		if *topologyMode {
+			return "Bus topology"
+		}
+		if *latencyMode {
+			return "Request/response latency"
+		}
+		if *transfersMode {
+			return "Bulk transfer reconstruction"
+		}
+		if *discoverMode {
+			return "Signal discovery"
+		}
+		if *labelsPath != "" {
+			return "Labeled segment comparison"
+		}
+		if *correlateMode {
+			return "Correlation analysis"
+		}
+		if *diffMode {
+			return "Payload changes per ID"
+		}
 		if *groupByID {
 			return "All frames (grouped by ID)"
 		}
@@ -76,81 +288,66 @@ func main() {
 	}())
 	fmt.Println("---------------------------------------------------")
 
-	lineNum := 0
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineNum++
-
-		// Skip empty lines
-		if strings.TrimSpace(line) == "" {
-			continue
+	formatAnnounced := false
+	// Analysis modes keep every frame in memory; grouping and payload diffs
+	// spool them to disk. All display after the capture is read.
+	keepFrames := *discoverMode || *correlateMode || *labelsPath != "" || *plotPath != "" || *topologyMode || *latencyMode || *transfersMode
+	collectFrames := *groupByID || *diffMode || keepFrames
+	if *groupByID {
+		spool = NewFrameSpool(*spoolFrames, false)
+		defer spool.Close()
+	}
+	if *diffMode {
+		diffSpool = NewFrameSpool(*spoolFrames, *diffByHeader)
+		defer diffSpool.Close()
+	}
+	// spoolFrame groups a frame whose message is complete
+	spoolFrame := func(f *FrameInfo) {
+		if !stream.Match(f) {
+			return
 		}
-
-		var frame *CANFrame
-		var err error
-
-		// Detect format on first data line
-		if lineNum == 1 {
-			// Check if this looks like a CSV header
-			if strings.Contains(line, "Time Stamp") || strings.Contains(line, "ID,Extended") {
-				isCSV = true
-				fmt.Println("📄 Detected CSV format")
-				continue // Skip header
-			} else if strings.Contains(line, "#") {
-				isCSV = false
-				fmt.Println("📄 Detected candump format")
+		if spool != nil && !accountedHidden(f, *hideAccounted, *hideUnaccounted) {
+			if err := spool.Add(f); err != nil {
+				log.Fatal(err)
 			}
 		}
-
-		// Parse based on format
-		if isCSV || strings.Contains(line, ",") && !strings.Contains(line, "#") {
-			isCSV = true
-			// Parse as CSV - split by comma
-			fields := strings.Split(line, ",")
-			frame, err = parseCSVLine(fields)
-			if err != nil {
-				continue
-			}
-		} else {
-			// Parse as candump
-			frame, err = parseCandumpLine(line)
-			if err != nil {
-				continue
+		if diffSpool != nil && !accountedHidden(f, *hideAccounted || *unaccountedOnly, *hideUnaccounted) {
+			if err := diffSpool.Add(f); err != nil {
+				log.Fatal(err)
 			}
 		}
+	}
 
-		if frame == nil || len(frame.Data) == 0 {
-			continue
+	for {
+		info, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !formatAnnounced && reader.Format() != "" {
+			fmt.Printf("📄 Detected %s format\n", reader.Format())
+			formatAnnounced = true
 		}
 
-		totalFramesProcessed++
+		frame := info.Frame
 
-		// Track capture timestamps
-		var timestampFloat float64
-		if frame.Timestamp != "" {
-			if ts, err := strconv.ParseFloat(frame.Timestamp, 64); err == nil {
-				// CSV timestamps are in microseconds, convert to seconds
-				if isCSV {
-					timestampFloat = ts / 1_000_000
-				} else {
-					timestampFloat = ts
-				}
-				if timestampFloat < minTimestamp {
-					minTimestamp = timestampFloat
-				}
-				if timestampFloat > maxTimestamp {
-					maxTimestamp = timestampFloat
-				}
-				if !captureStarted {
-					captureStarted = true
+		// Track capture timestamps and frame counts
+		summary.Observe(info)
+
+		// Track bus states before display so transitions precede the frame
+		if stateTracker != nil {
+			for _, ev := range stateTracker.Observe(info) {
+				stateEvents = append(stateEvents, ev)
+				if !collectFrames {
+					fmt.Println(formatStateEvent(ev))
 				}
 			}
 		}
 
-		// Extract header byte and payload
-		header := frame.Data[0]
-		payload := frame.Data[1:]
+		// Extract header byte
+		header := info.Header
 
 		// VanMoof Protocol Analysis:
 		// Header byte high nibble indicates frame type:
@@ -158,282 +355,289 @@ func main() {
 		// - 0xAx (e.g., A2) = Start of new CBOR message
 		// - 0x1x (e.g., 11) = Continuation frame
 		// - 0x0x = Could be status/heartbeat
-		isStartFrame := (header & 0xF0) == 0xA0
-		isContinuation := (header & 0xF0) == 0x10
-
-		// Determine frame type
-		var frameType string
-		var isCBOR bool
-		var isHeartbeat bool
+		isStartFrame := info.FrameType == "START"
+		isContinuation := info.FrameType == "CONT"
 
-		if isStartFrame {
-			frameType = "START"
-			isCBOR = true
-		} else if isContinuation {
-			frameType = "CONT"
-			isCBOR = true
-		} else {
-			// Check if heartbeat
-			isHeartbeat = checkIfHeartbeat(frame)
-			if isHeartbeat {
-				frameType = "HEARTBEAT"
-			} else {
-				frameType = "UNACCOUNTED"
-			}
+		// Store frame info for the analysis modes
+		if keepFrames {
+			allFrames = append(allFrames, info)
 		}
 
-		// Store frame info if grouping
-		if *groupByID {
-			allFrames = append(allFrames, &FrameInfo{
-				Frame:          frame,
-				TimestampFloat: timestampFloat,
-				Header:         header,
-				FrameType:      frameType,
-				IsHeartbeat:    isHeartbeat,
-				IsCBOR:         isCBOR,
-				SequenceNum:    totalFramesProcessed,
-			})
-		}
+		// The relative time field of the filter counts from the first frame
+		stream.Observe(info)
 
 		// Skip immediate display if grouping
-		if *groupByID {
+		if collectFrames {
 			// Still need to process CBOR for accurate counts
-			if isStartFrame {
-				cborBuffer = make([]byte, 0)
-				cborBuffer = append(cborBuffer, payload...)
-				lastCanID = frame.ID
-				frameCount = 1
-			} else if isContinuation {
-				cborBuffer = append(cborBuffer, payload...)
-				frameCount++
-			}
-
-			if isCBOR && len(cborBuffer) > 0 {
-				bufReader := bytes.NewReader(cborBuffer)
-				dec := cbor.NewDecoder(bufReader)
-				var item interface{}
-				if dec.Decode(&item) == nil {
-					cborMessageCount++
-					cborBuffer = cborBuffer[len(cborBuffer)-bufReader.Len():]
-				}
-			}
-
-			if isHeartbeat {
-				heartbeatCount++
-			}
+			summary.Add(info, gate.Add(info, spoolFrame))
 			continue
 		}
 
+		show := stream.Match(info)
+
 		// --- VANMOOF FRAMING LOGIC ---
 		if isStartFrame {
-			if !*unaccountedOnly && !*hideAccounted {
-				printFrameHeader(frame, header, "START")
-			}
-			// New message starting - reset buffer
-			if len(cborBuffer) > 0 {
-				fmt.Printf("   ⚠️ Discarding incomplete buffer (%d bytes): %X\n",
-					len(cborBuffer), cborBuffer)
+			discarded := reasm.Append(info)
+			if show {
+				if !*unaccountedOnly && !*hideAccounted {
+					printFrameHeader(frame, header, "START")
+				}
+				// New message starting - buffer was reset
+				if len(discarded) > 0 {
+					fmt.Printf("   ⚠️ Discarding incomplete buffer (%d bytes): %X\n",
+						len(discarded), discarded)
+				}
+				fmt.Printf("   🆕 New message started, buffer: %X\n", reasm.Buffer())
 			}
-			cborBuffer = make([]byte, 0)
-			cborBuffer = append(cborBuffer, payload...)
-			lastCanID = frame.ID
-			frameCount = 1
-			fmt.Printf("   🆕 New message started, buffer: %X\n", cborBuffer)
 		} else if isContinuation {
-			if !*unaccountedOnly && !*hideAccounted {
-				printFrameHeader(frame, header, "CONT")
-			}
 			// Continuation of current message
-			cborBuffer = append(cborBuffer, payload...)
-			frameCount++
-			fmt.Printf("   ➕ Frame %d appended, buffer now: %X (%d bytes)\n",
-				frameCount, cborBuffer, len(cborBuffer))
+			reasm.Append(info)
+			if show {
+				if !*unaccountedOnly && !*hideAccounted {
+					printFrameHeader(frame, header, "CONT")
+				}
+				fmt.Printf("   ➕ Frame %d appended, buffer now: %X (%d bytes)\n",
+					reasm.FrameCount(), reasm.Buffer(), len(reasm.Buffer()))
+			}
 		} else {
 			// Not CBOR framing - analyze as raw data
-			showVerbose := !*unaccountedOnly && !*hideUnaccounted && !*hideAccounted
+			showVerbose := show && !*unaccountedOnly && !*hideUnaccounted && !*hideAccounted
 			isHeartbeat := analyzeRawFrame(frame, showVerbose)
-			if !isHeartbeat && (*unaccountedOnly || *hideAccounted) {
+			if show && !isHeartbeat && (*unaccountedOnly || *hideAccounted) {
 				printFrameHeader(frame, header, "UNACCOUNTED")
 			}
-			if isHeartbeat {
-				heartbeatCount++
-			}
+			summary.Add(info, nil)
 			continue
 		}
 
 		// Try to decode CBOR from the accumulated buffer
-		if len(cborBuffer) > 0 {
-			bufReader := bytes.NewReader(cborBuffer)
-			dec := cbor.NewDecoder(bufReader)
-
-			var item interface{}
-			err := dec.Decode(&item)
+		msg := reasm.Decode()
+		summary.Add(info, msg)
+		if msg != nil {
+			// Successfully decoded!
 
-			if err == nil {
-				// Successfully decoded!
-				bytesConsumed := len(cborBuffer) - bufReader.Len()
-				cborMessageCount++
-
-				fmt.Println("\n===================================================")
-				fmt.Printf("✅ COMPLETE CBOR MESSAGE (CAN ID: 0x%s, %d frames, %d bytes)\n",
-					lastCanID, frameCount, bytesConsumed)
-				fmt.Printf("Raw CBOR: %X\n", cborBuffer[:bytesConsumed])
-				fmt.Println("---------------------------------------------------")
+			// Decoded-field predicates can only be checked now that the message is complete
+			if !stream.Match(info) {
+				continue
+			}
 
-				// Decode and display the structure
-				decodeAndPrint(item, 0)
+			fmt.Println("\n===================================================")
+			fmt.Printf("✅ COMPLETE CBOR MESSAGE (CAN ID: 0x%s, %d frames, %d bytes)\n",
+				msg.SourceID(), msg.FrameCount, len(msg.Raw))
+			fmt.Printf("Raw CBOR: %X\n", msg.Raw)
+			fmt.Println("---------------------------------------------------")
 
-				fmt.Println("===================================================")
+			// Decode and display the structure
+			decodeAndPrint(msg.Item, 0)
 
-				// Remove consumed bytes
-				cborBuffer = cborBuffer[bytesConsumed:]
-			}
+			fmt.Println("===================================================")
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	gate.Flush(spoolFrame)
+	if progressInput != nil {
+		progressInput.Done()
+	}
+	if n := reader.SkippedLines(); n > 0 {
+		fmt.Printf("⚠️  Skipped %d lines longer than %d bytes\n", n, maxLineLength)
 	}
 
 	// Display grouped output if requested
-	if *groupByID && len(allFrames) > 0 {
-		displayGroupedFrames(allFrames, *hideAccounted, *hideUnaccounted)
+	if spool != nil && summary.Frames > 0 {
+		if err := displayGroupedFrames(spool); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// Display capture summary
-	if captureStarted && maxTimestamp > minTimestamp {
-		durationSeconds := maxTimestamp - minTimestamp
-		readableDuration := formatDuration(durationSeconds)
-		fmt.Println("\n===================================================")
-		fmt.Printf("📊 Capture Summary\n")
-		fmt.Printf("   Duration: %s (%.3f sec)\n", readableDuration, durationSeconds)
-		fmt.Printf("   From: %.6f to %.6f seconds\n", minTimestamp, maxTimestamp)
-		fmt.Printf("   CBOR Messages Found: %d\n", cborMessageCount)
-		fmt.Printf("   Heartbeat/Keep-Alive Frames: %d\n", heartbeatCount)
-		unaccountedFrames := totalFramesProcessed - cborMessageCount - heartbeatCount
-		if unaccountedFrames < 0 {
-			unaccountedFrames = 0
-		}
-		fmt.Printf("   Unaccounted Frames: %d\n", unaccountedFrames)
-		fmt.Printf("   Total Frames Processed: %d\n", totalFramesProcessed)
-		fmt.Println("===================================================")
+	// Display payload changes if requested
+	if diffSpool != nil && summary.Frames > 0 {
+		if err := displayPayloadDiffs(diffSpool, *diffByHeader, *color); err != nil {
+			log.Fatal(err)
+		}
 	}
-}
 
-// compareFiles processes multiple files and compares their unaccounted frames
-func compareFiles(filePaths []string) {
-	fileFrames := make(map[string][]*FrameInfo)
+	// Run signal discovery if requested
+	if *discoverMode && len(allFrames) > 0 {
+		results := discoverSignals(filterFrames(allFrames, filter))
+		displayDiscovery(results)
+		if *dbcPath != "" {
+			paths, err := writeDBCFiles(*dbcPath, results)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, path := range paths {
+				fmt.Printf("📝 Draft signal definitions written to %s\n", path)
+			}
+		}
+	}
 
-	for _, filePath := range filePaths {
-		fmt.Printf("Processing %s...\n", filePath)
-		frames := processFile(filePath)
-		fileFrames[filePath] = frames
+	// Run correlation analysis if requested
+	if *correlateMode && len(allFrames) > 0 {
+		frames := filterFrames(allFrames, filter)
+		var markers []Marker
+		if *markersPath != "" {
+			markers, err = readMarkers(*markersPath, captureStart(frames))
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		displayCorrelations(frames, markers, *correlateField, *resampleStep, *markerWindow)
 	}
 
-	CompareUnaccountedFrames(fileFrames)
-}
+	// Compare labeled segments if requested
+	if *labelsPath != "" && len(allFrames) > 0 {
+		frames := filterFrames(allFrames, filter)
+		markers, err := readMarkers(*labelsPath, captureStart(frames))
+		if err != nil {
+			log.Fatal(err)
+		}
+		displayLabelComparison(frames, segmentsFromMarkers(markers, captureEnd(frames)))
+	}
 
-// processFile reads a file and returns all frame info
-func processFile(filePath string) []*FrameInfo {
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("Error opening %s: %v", filePath, err)
-		return nil
+	// Display bus topology if requested
+	if *topologyMode && len(allFrames) > 0 {
+		topo := buildTopology(filterFrames(allFrames, filter), nodeConfig, nodeMask, *responseWindow)
+		displayTopology(topo)
+		if *topologyDOT != "" {
+			if err := writeTopologyFile(*topologyDOT, topo); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("📝 Topology graph written to %s\n", *topologyDOT)
+		}
 	}
-	defer file.Close()
 
-	var allFrames []*FrameInfo
-	var isCSV bool
-	lineNum := 0
-	sequenceNum := 0
+	// Display request/response latencies if requested
+	if *latencyMode && len(allFrames) > 0 {
+		displayLatency(analyzeLatency(filterFrames(allFrames, filter), messagePairs, *responseWindow, *latencyTimeout), *latencyTimeout)
+	}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineNum++
+	// Reconstruct bulk transfers if requested
+	if *transfersMode && len(allFrames) > 0 {
+		sessions := findTransfers(filterFrames(allFrames, filter), transferForced, *transferMin, *transferGap)
+		if err := displayTransfers(sessions, *transferDir); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		if strings.TrimSpace(line) == "" {
-			continue
+	// Write plots if requested
+	if *plotPath != "" && len(allFrames) > 0 {
+		frames := filterFrames(allFrames, filter)
+		var series []*TimeSeries
+		for _, sel := range plotSelectors {
+			series = append(series, sel.series(frames))
+		}
+		if err := writePlot(*plotPath, frames, series); err != nil {
+			log.Fatal(err)
 		}
+		fmt.Printf("📈 Plots written to %s\n", *plotPath)
+	}
 
-		var frame *CANFrame
-		var parseErr error
+	// Display the state timeline if requested
+	if stateTracker != nil && summary.Timestamped {
+		displayStateTimeline(stateEvents, summary.End, collectFrames)
+	}
 
-		// Detect format on first data line
-		if lineNum == 1 {
-			if strings.Contains(line, "Time Stamp") || strings.Contains(line, "ID,Extended") {
-				isCSV = true
-				continue
-			} else if strings.Contains(line, "#") {
-				isCSV = false
+	// Display capture summary
+	summary.display()
+}
+
+// compareFiles processes multiple files on up to jobs goroutines and
+// compares them. mode selects unaccounted frame "patterns", "stats" or
+// decoded "cbor" messages. The pattern comparison is also written to
+// reportPath if given. Files that cannot be read are reported and left out.
+func compareFiles(filePaths []string, filter *Filter, mask ByteMask, maskCounters bool, mode, reportPath string, jobs int, progress bool) error {
+	// Results are merged in the order of the report
+	filenames := slices.Sorted(slices.Values(filePaths))
+	filenames = slices.Compact(filenames)
+	failed := make([]error, len(filenames))
+
+	// Counters are detected in a first pass so that every file is compared
+	// with the same mask
+	if maskCounters {
+		fmt.Printf("Detecting counters in %d files...\n", len(filenames))
+		counters := make([]ByteMask, len(filenames))
+		failed = runFiles(filenames, jobs, progress, failed, func(i int, path string, progress bool) error {
+			detector := NewCounterDetector()
+			if err := streamFile(path, filter, progress, detector.Add); err != nil {
+				return err
 			}
+			counters[i] = make(ByteMask)
+			detector.Mask(counters[i])
+			return nil
+		})
+		for _, c := range counters {
+			mask.Merge(c)
 		}
+	}
 
-		// Parse based on format
-		if isCSV || strings.Contains(line, ",") && !strings.Contains(line, "#") {
-			isCSV = true
-			fields := strings.Split(line, ",")
-			frame, parseErr = parseCSVLine(fields)
-		} else {
-			frame, parseErr = parseCandumpLine(line)
+	// Each file is streamed into its own accumulator
+	patterns := make([]*ComparisonBuilder, len(filenames))
+	captures := make([]*CaptureStats, len(filenames))
+	messages := make([]*CBORComparison, len(filenames))
+	for _, filePath := range filenames {
+		fmt.Printf("Processing %s...\n", filePath)
+	}
+	failed = runFiles(filenames, jobs, progress, failed, func(i int, path string, progress bool) error {
+		var add func(f *FrameInfo)
+		switch mode {
+		case "stats":
+			captures[i] = NewCaptureStats()
+			add = captures[i].Add
+		case "cbor":
+			messages[i] = NewCBORComparison([]string{path})
+			add = func(f *FrameInfo) { messages[i].AddFrame(0, f) }
+		default:
+			patterns[i] = NewComparisonBuilder(mask)
+			patterns[i].AddFile(path)
+			add = func(f *FrameInfo) { patterns[i].Add(path, f) }
 		}
-
-		if parseErr != nil || frame == nil || len(frame.Data) == 0 {
+		return streamFile(path, filter, progress, add)
+	})
+
+	// Merge the files that could be read, in order
+	var readable []string
+	for i, filePath := range filenames {
+		if failed[i] != nil {
+			fmt.Printf("❌ %v\n", failed[i])
 			continue
 		}
+		readable = append(readable, filePath)
+	}
+	if len(readable) < 2 {
+		return fmt.Errorf("compare needs at least 2 readable files, got %d", len(readable))
+	}
 
-		sequenceNum++
-
-		// Parse timestamp
-		var timestampFloat float64
-		if frame.Timestamp != "" {
-			if ts, err := strconv.ParseFloat(frame.Timestamp, 64); err == nil {
-				if isCSV {
-					timestampFloat = ts / 1_000_000
-				} else {
-					timestampFloat = ts
-				}
+	switch mode {
+	case "stats":
+		var stats []*CaptureStats
+		for i := range filenames {
+			if failed[i] == nil {
+				stats = append(stats, captures[i])
 			}
 		}
-
-		// Extract header and determine frame type
-		header := frame.Data[0]
-		isStartFrame := (header & 0xF0) == 0xA0
-		isContinuation := (header & 0xF0) == 0x10
-
-		var frameType string
-		var isCBOR bool
-		var isHeartbeat bool
-
-		if isStartFrame {
-			frameType = "START"
-			isCBOR = true
-		} else if isContinuation {
-			frameType = "CONT"
-			isCBOR = true
-		} else {
-			isHeartbeat = checkIfHeartbeat(frame)
-			if isHeartbeat {
-				frameType = "HEARTBEAT"
-			} else {
-				frameType = "UNACCOUNTED"
+		CompareCaptureStatistics(readable, stats, mask)
+	case "cbor":
+		merged := NewCBORComparison(readable)
+		for i := range filenames {
+			if failed[i] == nil {
+				merged.Merge(slices.Index(readable, filenames[i]), messages[i])
 			}
 		}
-
-		allFrames = append(allFrames, &FrameInfo{
-			Frame:          frame,
-			TimestampFloat: timestampFloat,
-			Header:         header,
-			FrameType:      frameType,
-			IsHeartbeat:    isHeartbeat,
-			IsCBOR:         isCBOR,
-			SequenceNum:    sequenceNum,
-		})
-	}
-
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading %s: %v", filePath, err)
+		CompareCBORMessages(merged)
+	default:
+		merged := NewComparisonBuilder(mask)
+		for i := range filenames {
+			if failed[i] == nil {
+				merged.Merge(patterns[i])
+			}
+		}
+		result := CompareUnaccountedFrames(merged)
+		if reportPath != "" {
+			if err := writeComparisonReport(reportPath, result); err != nil {
+				return err
+			}
+			fmt.Printf("📝 Comparison report written to %s\n", reportPath)
+		}
 	}
-
-	return allFrames
+	return nil
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// A minimal Apache Parquet writer: one row group, one uncompressed PLAIN
+// data page per column, required (non-null) DOUBLE and UTF8 string columns.
+// That is all the exports need and keeps the tool free of dependencies.
+
+// Parquet physical types and other enum values used by the writer
+const (
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+	parquetRequired      = 0
+	parquetConvertedUTF8 = 0
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+	parquetCodecNone     = 0
+	parquetPageData      = 0
+)
+
+// parquetColumn is one column of a Parquet table; exactly one of Doubles and
+// Strings is used
+type parquetColumn struct {
+	Name    string
+	Doubles []float64
+	Strings []string
+}
+
+// isString reports whether the column holds UTF8 strings
+func (c *parquetColumn) isString() bool {
+	return c.Strings != nil
+}
+
+// rows returns the number of values in the column
+func (c *parquetColumn) rows() int {
+	if c.isString() {
+		return len(c.Strings)
+	}
+	return len(c.Doubles)
+}
+
+// plain encodes the column values with PLAIN encoding
+func (c *parquetColumn) plain() []byte {
+	var buf bytes.Buffer
+	var b [8]byte
+	if c.isString() {
+		for _, s := range c.Strings {
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+			buf.Write(b[:4])
+			buf.WriteString(s)
+		}
+		return buf.Bytes()
+	}
+	for _, v := range c.Doubles {
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		buf.Write(b[:])
+	}
+	return buf.Bytes()
+}
+
+// physicalType returns the Parquet type of the column
+func (c *parquetColumn) physicalType() int64 {
+	if c.isString() {
+		return parquetTypeByteArray
+	}
+	return parquetTypeDouble
+}
+
+// writeParquet writes equally long columns as a Parquet file
+func writeParquet(w io.Writer, columns []*parquetColumn) error {
+	numRows := 0
+	if len(columns) > 0 {
+		numRows = columns[0].rows()
+	}
+
+	var out bytes.Buffer
+	out.WriteString("PAR1")
+
+	// Column chunks: a page header followed by the PLAIN values
+	type chunkInfo struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunkInfo, len(columns))
+	for i, c := range columns {
+		data := c.plain()
+		var page thriftStruct
+		page.i32(1, parquetPageData)
+		page.i32(2, int64(len(data)))
+		page.i32(3, int64(len(data)))
+		var dataPage thriftStruct
+		dataPage.i32(1, int64(c.rows()))
+		dataPage.i32(2, parquetEncodingPlain)
+		dataPage.i32(3, parquetEncodingRLE)
+		dataPage.i32(4, parquetEncodingRLE)
+		page.structField(5, dataPage.end())
+
+		chunks[i].offset = int64(out.Len())
+		out.Write(page.end())
+		out.Write(data)
+		chunks[i].size = int64(out.Len()) - chunks[i].offset
+	}
+
+	// Schema: a root element followed by one leaf per column
+	var root thriftStruct
+	root.binary(4, "schema")
+	root.i32(5, int64(len(columns)))
+	schema := [][]byte{root.end()}
+	for _, c := range columns {
+		var leaf thriftStruct
+		leaf.i32(1, c.physicalType())
+		leaf.i32(3, parquetRequired)
+		leaf.binary(4, c.Name)
+		if c.isString() {
+			leaf.i32(6, parquetConvertedUTF8)
+		}
+		schema = append(schema, leaf.end())
+	}
+
+	var chunkMeta [][]byte
+	var totalSize int64
+	for i, c := range columns {
+		var cm thriftStruct
+		cm.i32(1, c.physicalType())
+		cm.i32List(2, []int64{parquetEncodingPlain})
+		cm.binaryList(3, []string{c.Name})
+		cm.i32(4, parquetCodecNone)
+		cm.i64(5, int64(c.rows()))
+		cm.i64(6, chunks[i].size)
+		cm.i64(7, chunks[i].size)
+		cm.i64(9, chunks[i].offset)
+		var chunk thriftStruct
+		chunk.i64(2, chunks[i].offset)
+		chunk.structField(3, cm.end())
+		chunkMeta = append(chunkMeta, chunk.end())
+		totalSize += chunks[i].size
+	}
+	var rowGroup thriftStruct
+	rowGroup.structList(1, chunkMeta)
+	rowGroup.i64(2, totalSize)
+	rowGroup.i64(3, int64(numRows))
+
+	var meta thriftStruct
+	meta.i32(1, 1) // format version
+	meta.structList(2, schema)
+	meta.i64(3, int64(numRows))
+	meta.structList(4, [][]byte{rowGroup.end()})
+	meta.binary(6, "vanmoof-canbus version "+Version)
+	footer := meta.end()
+
+	out.Write(footer)
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	out.Write(size[:])
+	out.WriteString("PAR1")
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// Thrift compact protocol type codes
+const (
+	thriftI32        = 5
+	thriftI64        = 6
+	thriftBinary     = 8
+	thriftList       = 9
+	thriftStructType = 12
+)
+
+// thriftStruct encodes one struct in the Thrift compact protocol used by the
+// Parquet metadata. Fields must be added in increasing ID order; nested
+// structs are encoded separately and added as bytes.
+type thriftStruct struct {
+	buf  bytes.Buffer
+	last int
+}
+
+func zigzag(n int64) uint64 {
+	return uint64(n<<1) ^ uint64(n>>63)
+}
+
+func (t *thriftStruct) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftStruct) rawBinary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// field writes a field header with a delta-encoded ID when possible
+func (t *thriftStruct) field(id int, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+// listHeader writes the size and element type of a list
+func (t *thriftStruct) listHeader(elemType byte, size int) {
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xF0 | elemType)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftStruct) i32(id int, v int64) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftStruct) i64(id int, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftStruct) binary(id int, s string) {
+	t.field(id, thriftBinary)
+	t.rawBinary(s)
+}
+
+// structField adds an encoded struct as a field
+func (t *thriftStruct) structField(id int, encoded []byte) {
+	t.field(id, thriftStructType)
+	t.buf.Write(encoded)
+}
+
+func (t *thriftStruct) i32List(id int, values []int64) {
+	t.field(id, thriftList)
+	t.listHeader(thriftI32, len(values))
+	for _, v := range values {
+		t.varint(zigzag(v))
+	}
+}
+
+func (t *thriftStruct) binaryList(id int, values []string) {
+	t.field(id, thriftList)
+	t.listHeader(thriftBinary, len(values))
+	for _, v := range values {
+		t.rawBinary(v)
+	}
+}
+
+// structList adds a list of encoded structs as a field
+func (t *thriftStruct) structList(id int, encoded [][]byte) {
+	t.field(id, thriftList)
+	t.listHeader(thriftStructType, len(encoded))
+	for _, e := range encoded {
+		t.buf.Write(e)
+	}
+}
+
+// end terminates the struct and returns its encoding
+func (t *thriftStruct) end() []byte {
+	t.buf.WriteByte(0)
+	return t.buf.Bytes()
+}
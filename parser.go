@@ -14,11 +14,14 @@ func parseCSVLine(fields []string) (*CANFrame, error) {
 		return nil, fmt.Errorf("not enough fields: got %d, need 14", len(fields))
 	}
 
+	id, err := parseCANIDAs(fields[1], strings.ToLower(fields[2]) == "true")
+	if err != nil {
+		return nil, err
+	}
 	frame := &CANFrame{
-		Timestamp:  fields[0],
-		ID:         fields[1],
-		IsExtended: strings.ToLower(fields[2]) == "true",
-		Direction:  fields[3],
+		Timestamp: fields[0],
+		ID:        id,
+		Direction: fields[3],
 	}
 
 	// Parse Bus
@@ -70,12 +73,17 @@ func parseCandumpLine(line string) (*CANFrame, error) {
 	}
 	idPart = strings.TrimSpace(idPart)
 
-	// Remove interface name (vcan0, can0, etc.)
+	// Remove interface name (vcan0, can0, etc.), keeping its number as the bus
+	bus := 0
 	if idx := strings.LastIndex(idPart, " "); idx != -1 {
+		bus = interfaceBus(strings.TrimSpace(idPart[:idx]))
 		idPart = idPart[idx+1:]
 	}
 
-	canID := strings.TrimSpace(idPart)
+	canID, err := ParseCANID(idPart)
+	if err != nil {
+		return nil, err
+	}
 
 	// Extract and decode payload (everything after #)
 	payloadHex := line[idxHash+1:]
@@ -86,14 +94,32 @@ func parseCandumpLine(line string) (*CANFrame, error) {
 		return nil, err
 	}
 
+	// Keep the (timestamp) prefix if present, e.g. from candump -L
+	var timestamp string
+	if start, end := strings.Index(line, "("), strings.Index(line, ")"); start != -1 && start < end && end < idxHash {
+		timestamp = line[start+1 : end]
+	}
+
 	return &CANFrame{
-		ID:         canID,
-		IsExtended: len(canID) > 3,
-		Data:       payload,
-		Length:     len(payload),
+		Timestamp: timestamp,
+		ID:        canID,
+		Bus:       bus,
+		Data:      payload,
+		Length:    len(payload),
 	}, nil
 }
 
+// interfaceBus returns the trailing number of an interface name as the bus
+// number, e.g. 1 for can1, or 0 if there is none
+func interfaceBus(iface string) int {
+	digits := strings.TrimLeftFunc(iface, func(r rune) bool { return r < '0' || r > '9' })
+	bus, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0
+	}
+	return bus
+}
+
 // parseTimestamp extracts the numeric timestamp from a line
 // SavvyCAN CSV format uses microseconds, candump uses seconds
 func parseTimestamp(line string, isCSV bool) (float64, error) {
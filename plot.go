@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Plot layout in SVG user units
+const (
+	plotWidth       = 1200
+	plotLabelWidth  = 170 // left column with CAN IDs and series names
+	plotMargin      = 20
+	plotRowHeight   = 14  // timeline and rate rows per CAN ID
+	plotPanelHeight = 110 // line plot of one series
+	plotRateBins    = 200
+)
+
+// Frame class colors of the timeline
+var plotClassColors = []struct {
+	Class string
+	Color string
+}{
+	{"START", "#1f77b4"},
+	{"CONT", "#9ecae1"},
+	{"HEARTBEAT", "#aaaaaa"},
+	{"UNACCOUNTED", "#ff7f0e"},
+}
+
+// plotSelector selects a series to plot: a decoded CBOR field or raw bytes
+type plotSelector struct {
+	Kind   string // cbor, byte, u16le or u16be
+	ID     string
+	Header int // -1 matches any header
+	Index  int // byte index for raw selectors
+	Path   string
+	Spec   string
+}
+
+// parsePlotSelectors parses a comma-separated list such as
+// "cbor:14609460:3.[1],byte:123:81:3,u16le:14609460:*:3"
+func parsePlotSelectors(spec string) ([]plotSelector, error) {
+	var selectors []plotSelector
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 4)
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("plot: invalid series %q", item)
+		}
+		if _, err := ParseCANID(parts[1]); err != nil {
+			return nil, fmt.Errorf("plot: %v", err)
+		}
+		sel := plotSelector{Kind: parts[0], ID: canonicalID(parts[1]), Header: -1, Spec: item}
+		switch sel.Kind {
+		case "cbor":
+			sel.Path = strings.Join(parts[2:], ":")
+		case "byte", "u16le", "u16be":
+			if len(parts) != 4 {
+				return nil, fmt.Errorf("plot: %s series need ID:HDR:N, got %q", sel.Kind, item)
+			}
+			if parts[2] != "*" {
+				h, err := strconv.ParseUint(parts[2], 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("plot: invalid header %q", parts[2])
+				}
+				sel.Header = int(h)
+			}
+			n, err := strconv.Atoi(parts[3])
+			if err != nil || n < 0 || n > 63 {
+				return nil, fmt.Errorf("plot: invalid byte index %q", parts[3])
+			}
+			sel.Index = n
+		default:
+			return nil, fmt.Errorf("plot: unknown series kind %q (use cbor, byte, u16le or u16be)", sel.Kind)
+		}
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
+
+// series extracts the selected values from a capture
+func (sel plotSelector) series(frames []*FrameInfo) *TimeSeries {
+	s := &TimeSeries{Name: sel.Spec}
+	if sel.Kind == "cbor" {
+		for _, msg := range captureMessages(frames) {
+			if idKey(msg.ID) != sel.ID {
+				continue
+			}
+			if v, ok := lookupField(msg.Item, splitFieldPath(sel.Path)); ok {
+				if n, ok := fieldNumber(v); ok {
+					s.Times = append(s.Times, msg.Timestamp)
+					s.Values = append(s.Values, n)
+				}
+			}
+		}
+		return s
+	}
+
+	width := 1
+	if sel.Kind != "byte" {
+		width = 2
+	}
+	for _, f := range unaccountedFrames(frames) {
+		data := f.Frame.Data
+		if idKey(f.Frame.ID) != sel.ID || sel.Header >= 0 && int(f.Header) != sel.Header ||
+			sel.Index+width > len(data) {
+			continue
+		}
+		v := float64(data[sel.Index])
+		switch sel.Kind {
+		case "u16le":
+			v = float64(data[sel.Index]) + 256*float64(data[sel.Index+1])
+		case "u16be":
+			v = 256*float64(data[sel.Index]) + float64(data[sel.Index+1])
+		}
+		s.Times = append(s.Times, f.TimestampFloat)
+		s.Values = append(s.Values, v)
+	}
+	sortSeries(s)
+	return s
+}
+
+// sortSeries orders the samples of a series by time
+func sortSeries(s *TimeSeries) {
+	idx := make([]int, len(s.Times))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return s.Times[idx[a]] < s.Times[idx[b]] })
+	times := make([]float64, len(idx))
+	values := make([]float64, len(idx))
+	for i, j := range idx {
+		times[i], values[i] = s.Times[j], s.Values[j]
+	}
+	s.Times, s.Values = times, values
+}
+
+// frameClass returns the timeline class of a frame
+func frameClass(f *FrameInfo) string {
+	switch {
+	case f.IsHeartbeat:
+		return "HEARTBEAT"
+	case f.FrameType == "START" || f.FrameType == "CONT":
+		return f.FrameType
+	}
+	return "UNACCOUNTED"
+}
+
+// niceStep returns a 1/2/5×10^n step giving about n ticks over span
+func niceStep(span float64, n int) float64 {
+	if span <= 0 {
+		return 1
+	}
+	raw := span / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// svgPlot accumulates SVG elements with the shared time axis
+type svgPlot struct {
+	sb       strings.Builder
+	y        float64 // top of the next section
+	start    float64
+	duration float64
+}
+
+// x maps a capture timestamp to the plot area
+func (p *svgPlot) x(ts float64) float64 {
+	plotArea := float64(plotWidth - plotLabelWidth - 2*plotMargin)
+	if p.duration <= 0 {
+		return float64(plotLabelWidth + plotMargin)
+	}
+	return float64(plotLabelWidth+plotMargin) + (ts-p.start)/p.duration*plotArea
+}
+
+func (p *svgPlot) text(x, y float64, anchor, class, s string) {
+	fmt.Fprintf(&p.sb, `<text x="%.1f" y="%.1f" text-anchor="%s" class="%s">%s</text>`+"\n",
+		x, y, anchor, class, html.EscapeString(s))
+}
+
+// heading starts a new section with a title
+func (p *svgPlot) heading(title string) {
+	p.y += 28
+	p.text(plotMargin, p.y, "start", "title", title)
+	p.y += 10
+}
+
+// timeAxis draws time ticks in seconds relative to the capture start
+func (p *svgPlot) timeAxis() {
+	step := niceStep(p.duration, 10)
+	for t := 0.0; t <= p.duration+step/1e6; t += step {
+		x := p.x(p.start + t)
+		fmt.Fprintf(&p.sb, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" class="tick"/>`+"\n", x, p.y, x, p.y+4)
+		p.text(x, p.y+16, "middle", "axis", strconv.FormatFloat(t, 'f', -1, 64)+" s")
+	}
+	p.y += 22
+}
+
+// timeline draws one row per CAN ID with a mark per frame, colored by class.
+// Frames are binned per pixel column so large captures stay small.
+func (p *svgPlot) timeline(ids []string, grouped map[string][]*FrameInfo) {
+	p.heading("Frames per CAN ID")
+	legendX := float64(plotLabelWidth + plotMargin)
+	for _, c := range plotClassColors {
+		fmt.Fprintf(&p.sb, `<rect x="%.1f" y="%.1f" width="10" height="10" fill="%s"/>`+"\n", legendX, p.y, c.Color)
+		p.text(legendX+14, p.y+9, "start", "axis", c.Class)
+		legendX += 110
+	}
+	p.y += 18
+
+	for _, id := range ids {
+		p.text(plotLabelWidth, p.y+plotRowHeight-3, "end", "label", fmt.Sprintf("0x%s (%d)", id, len(grouped[id])))
+		seen := make(map[string]bool)
+		for _, f := range grouped[id] {
+			class := frameClass(f)
+			x := math.Floor(p.x(f.TimestampFloat))
+			key := fmt.Sprintf("%s:%.0f", class, x)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			fmt.Fprintf(&p.sb, `<rect x="%.0f" y="%.1f" width="1.5" height="%d" class="%s"/>`+"\n",
+				x, p.y+2, plotRowHeight-4, strings.ToLower(class))
+		}
+		p.y += plotRowHeight
+	}
+	p.timeAxis()
+}
+
+// rates draws a sparkline of frames per second per CAN ID
+func (p *svgPlot) rates(ids []string, grouped map[string][]*FrameInfo) {
+	p.heading(fmt.Sprintf("Rate per CAN ID (frames/s, %d bins)", plotRateBins))
+	binWidth := p.duration / plotRateBins
+	if binWidth <= 0 {
+		binWidth = 1
+	}
+	for _, id := range ids {
+		bins := make([]float64, plotRateBins)
+		for _, f := range grouped[id] {
+			b := int((f.TimestampFloat - p.start) / binWidth)
+			bins[min(max(b, 0), plotRateBins-1)]++
+		}
+		peak := 0.0
+		for i := range bins {
+			bins[i] /= binWidth
+			peak = math.Max(peak, bins[i])
+		}
+		p.text(plotLabelWidth, p.y+plotRowHeight-3, "end", "label", fmt.Sprintf("0x%s ≤%.1f/s", id, peak))
+		var points []string
+		for i, r := range bins {
+			x := p.x(p.start + (float64(i)+0.5)*binWidth)
+			y := p.y + plotRowHeight - 2
+			if peak > 0 {
+				y -= r / peak * (plotRowHeight - 4)
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+		}
+		fmt.Fprintf(&p.sb, `<polyline points="%s" class="rate"/>`+"\n", strings.Join(points, " "))
+		p.y += plotRowHeight
+	}
+	p.timeAxis()
+}
+
+// linePlot draws one series as a step line with its value range
+func (p *svgPlot) linePlot(s *TimeSeries) {
+	p.heading(fmt.Sprintf("%s (%d samples)", s.Name, len(s.Values)))
+	if len(s.Values) == 0 {
+		p.text(plotLabelWidth, p.y+14, "end", "label", "no samples")
+		p.y += 20
+		return
+	}
+	lo, hi := s.Values[0], s.Values[0]
+	for _, v := range s.Values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	top, bottom := p.y+4, p.y+plotPanelHeight-4
+	yOf := func(v float64) float64 {
+		if hi == lo {
+			return (top + bottom) / 2
+		}
+		return bottom - (v-lo)/(hi-lo)*(bottom-top)
+	}
+	fmt.Fprintf(&p.sb, `<rect x="%d" y="%.1f" width="%d" height="%d" class="panel"/>`+"\n",
+		plotLabelWidth+plotMargin, p.y, plotWidth-plotLabelWidth-2*plotMargin, plotPanelHeight)
+	p.text(plotLabelWidth, top+8, "end", "label", formatNumber(hi))
+	p.text(plotLabelWidth, bottom, "end", "label", formatNumber(lo))
+
+	// Step line: hold each value until the next sample. Samples sharing a
+	// pixel column are reduced to their first, lowest, highest and last value.
+	var points []string
+	column, colMin, colMax := math.NaN(), 0.0, 0.0
+	prev := 0.0
+	flush := func() {
+		if !math.IsNaN(column) && colMin != colMax {
+			points = append(points, fmt.Sprintf("%.1f,%.1f %.1f,%.1f", column, yOf(colMin), column, yOf(colMax)))
+		}
+	}
+	for i, v := range s.Values {
+		x := p.x(s.Times[i])
+		if math.Floor(x) != column {
+			flush()
+			if i > 0 {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", x, yOf(prev)))
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x, yOf(v)))
+			column, colMin, colMax = math.Floor(x), v, v
+		} else {
+			colMin, colMax = math.Min(colMin, v), math.Max(colMax, v)
+		}
+		prev = v
+	}
+	flush()
+	if len(s.Values) > 0 {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", p.x(s.Times[len(s.Times)-1]), yOf(prev)))
+	}
+	fmt.Fprintf(&p.sb, `<polyline points="%s" class="series"/>`+"\n", strings.Join(points, " "))
+	p.y += plotPanelHeight + 4
+	p.timeAxis()
+}
+
+// renderPlotSVG draws the timeline, rate and series plots of a capture
+func renderPlotSVG(frames []*FrameInfo, series []*TimeSeries) string {
+	ids, grouped := groupFramesByID(frames, false)
+	p := &svgPlot{start: captureStart(frames)}
+	p.duration = captureEnd(frames) - p.start
+
+	p.timeline(ids, grouped)
+	p.rates(ids, grouped)
+	for _, s := range series {
+		p.linePlot(s)
+	}
+
+	var style strings.Builder
+	style.WriteString(`text { font-family: sans-serif; font-size: 11px; fill: #222; }
+.title { font-size: 14px; font-weight: bold; }
+.axis { fill: #666; }
+.tick { stroke: #999; }
+.panel { fill: #fafafa; stroke: #ddd; }
+.rate { fill: none; stroke: #2ca02c; stroke-width: 1; }
+.series { fill: none; stroke: #d62728; stroke-width: 1.2; }
+`)
+	for _, c := range plotClassColors {
+		fmt.Fprintf(&style, ".%s { fill: %s; }\n", strings.ToLower(c.Class), c.Color)
+	}
+
+	height := p.y + plotMargin
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%.0f" viewBox="0 0 %d %.0f">
+<style>
+%s</style>
+<rect width="100%%" height="100%%" fill="white"/>
+%s</svg>
+`, plotWidth, height, plotWidth, height, style.String(), p.sb.String())
+}
+
+// checkPlotPath verifies that the plot format is known from the extension
+func checkPlotPath(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".svg", ".html", ".htm":
+		return nil
+	}
+	return fmt.Errorf("unknown plot format %q (use .svg or .html)", filepath.Ext(path))
+}
+
+// writePlot writes the plots to path as standalone SVG, or as an HTML page
+// embedding the SVG when the extension is .html
+func writePlot(path string, frames []*FrameInfo, series []*TimeSeries) error {
+	if err := checkPlotPath(path); err != nil {
+		return err
+	}
+	svg := renderPlotSVG(frames, series)
+	var out string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".svg":
+		out = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + svg
+	default:
+		out = fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>CAN capture plots</title>
+<style>body { font-family: system-ui, sans-serif; margin: 1em; } svg { max-width: 100%%; height: auto; }</style>
+</head>
+<body>
+<h1>📈 CAN capture plots</h1>
+<p>%d frames, %.3f s, vanmoof-canbus %s</p>
+%s</body>
+</html>
+`, len(frames), captureEnd(frames)-captureStart(frames), Version, svg)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(file, out); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
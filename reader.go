@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxLineLength is the longest input line the reader accepts. Longer lines
+// cannot be frames and are skipped without being buffered.
+const maxLineLength = 1 << 20
+
+// FrameReader reads CAN frames from SavvyCAN CSV or candump input, detecting
+// the format from the first line
+type FrameReader struct {
+	reader      *bufio.Reader
+	isCSV       bool
+	detected    bool
+	lineNum     int
+	sequenceNum int
+	skipped     int // lines longer than maxLineLength
+}
+
+// NewFrameReader returns a reader for CSV or candump input
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{reader: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// SkippedLines returns the number of overlong lines skipped so far
+func (fr *FrameReader) SkippedLines() int {
+	return fr.skipped
+}
+
+// readLine returns the next line without its line ending. Lines longer than
+// maxLineLength are consumed and returned as too long.
+func (fr *FrameReader) readLine() (line string, tooLong bool, err error) {
+	var long []byte
+	for {
+		chunk, err := fr.reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			if !tooLong {
+				long = append(long, chunk...)
+				if len(long) > maxLineLength {
+					tooLong, long = true, nil
+				}
+			}
+			continue
+		}
+		if err != nil && (err != io.EOF || len(chunk)+len(long) == 0 && !tooLong) {
+			return "", false, err
+		}
+		if tooLong {
+			return "", true, nil
+		}
+		if long != nil {
+			chunk = append(long, chunk...)
+		}
+		chunk = bytes.TrimSuffix(chunk, []byte("\n"))
+		chunk = bytes.TrimSuffix(chunk, []byte("\r"))
+		return string(chunk), false, nil
+	}
+}
+
+// Format returns the detected input format, or "" if not yet known
+func (fr *FrameReader) Format() string {
+	if !fr.detected {
+		return ""
+	}
+	if fr.isCSV {
+		return "CSV"
+	}
+	return "candump"
+}
+
+// Next returns the next classified frame, skipping lines that do not parse.
+// It returns io.EOF once the input is exhausted.
+func (fr *FrameReader) Next() (*FrameInfo, error) {
+	for {
+		line, tooLong, err := fr.readLine()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		fr.lineNum++
+		if tooLong {
+			fr.skipped++
+			continue
+		}
+
+		// Skip empty lines
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var frame *CANFrame
+
+		// Detect format on first data line
+		if fr.lineNum == 1 {
+			// Check if this looks like a CSV header
+			if strings.Contains(line, "Time Stamp") || strings.Contains(line, "ID,Extended") {
+				fr.isCSV = true
+				fr.detected = true
+				continue // Skip header
+			} else if strings.Contains(line, "#") {
+				fr.isCSV = false
+				fr.detected = true
+			}
+		}
+
+		// Parse based on format
+		if fr.isCSV || strings.Contains(line, ",") && !strings.Contains(line, "#") {
+			fr.isCSV = true
+			fr.detected = true
+			frame, err = parseCSVLine(strings.Split(line, ","))
+		} else {
+			frame, err = parseCandumpLine(line)
+		}
+
+		if err != nil || frame == nil || len(frame.Data) == 0 {
+			continue
+		}
+
+		fr.sequenceNum++
+		return newFrameInfo(frame, fr.frameTimestamp(frame), fr.sequenceNum), nil
+	}
+}
+
+// frameTimestamp converts the frame timestamp to seconds.
+// CSV timestamps are in microseconds, candump timestamps are already in seconds.
+func (fr *FrameReader) frameTimestamp(frame *CANFrame) float64 {
+	if frame.Timestamp == "" {
+		return 0
+	}
+	ts, err := strconv.ParseFloat(frame.Timestamp, 64)
+	if err != nil {
+		return 0
+	}
+	if fr.isCSV {
+		return ts / 1_000_000
+	}
+	return ts
+}
+
+// newFrameInfo classifies a parsed frame by its header byte
+func newFrameInfo(frame *CANFrame, timestamp float64, sequenceNum int) *FrameInfo {
+	header := frame.Data[0]
+	frameType, isCBOR, isHeartbeat := classifyFrame(frame)
+	return &FrameInfo{
+		Frame:          frame,
+		TimestampFloat: timestamp,
+		Header:         header,
+		FrameType:      frameType,
+		IsHeartbeat:    isHeartbeat,
+		IsCBOR:         isCBOR,
+		SequenceNum:    sequenceNum,
+	}
+}
+
+// classifyFrame determines the frame type from the header byte:
+// 0xAx starts a CBOR message, 0x1x continues one, anything else is raw data
+func classifyFrame(frame *CANFrame) (frameType string, isCBOR, isHeartbeat bool) {
+	header := frame.Data[0]
+	switch {
+	case (header & 0xF0) == 0xA0:
+		return "START", true, false
+	case (header & 0xF0) == 0x10:
+		return "CONT", true, false
+	case checkIfHeartbeat(frame):
+		return "HEARTBEAT", false, true
+	}
+	return "UNACCOUNTED", false, false
+}
+
+// readAllFrames reads every frame from r
+func readAllFrames(r io.Reader) ([]*FrameInfo, error) {
+	fr := NewFrameReader(r)
+	var frames []*FrameInfo
+	for {
+		f, err := fr.Next()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, f)
+	}
+}
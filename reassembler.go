@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Reassembler accumulates START/CONT frame payloads and decodes complete
+// CBOR messages from them. Each bus and CAN ID has its own buffer, so
+// interleaved messages of different IDs or buses do not mix.
+type Reassembler struct {
+	streams map[reassemblyKey]*reassemblyStream
+	current *reassemblyStream // stream of the last appended frame
+}
+
+// reassemblyKey identifies the stream a frame belongs to
+type reassemblyKey struct {
+	bus int
+	id  CANID
+}
+
+// reassemblyStream is the message being assembled on one bus and CAN ID
+type reassemblyStream struct {
+	key        reassemblyKey
+	buffer     []byte
+	frames     []*FrameInfo
+	frameCount int
+}
+
+// Append adds the payload of a START or CONT frame to the buffer of its bus
+// and CAN ID. A START frame resets the buffer; any bytes left from an
+// incomplete message are returned so the caller can report them.
+func (r *Reassembler) Append(f *FrameInfo) (discarded []byte) {
+	key := reassemblyKey{bus: f.Frame.Bus, id: f.Frame.ID}
+	if r.streams == nil {
+		r.streams = make(map[reassemblyKey]*reassemblyStream)
+	}
+	st, ok := r.streams[key]
+	if !ok {
+		st = &reassemblyStream{key: key}
+		r.streams[key] = st
+	}
+	r.current = st
+
+	payload := f.Frame.Data[1:]
+	switch f.FrameType {
+	case "START":
+		if len(st.buffer) > 0 {
+			discarded = st.buffer
+		}
+		st.buffer = append(make([]byte, 0, len(payload)), payload...)
+		st.frames = []*FrameInfo{f}
+		st.frameCount = 1
+	case "CONT":
+		st.buffer = append(st.buffer, payload...)
+		st.frames = append(st.frames, f)
+		st.frameCount++
+	}
+	return discarded
+}
+
+// Decode tries to decode one CBOR item from the buffer of the last appended
+// frame. On success the consumed bytes are removed and every frame that
+// contributed to the message is linked to it.
+func (r *Reassembler) Decode() *Message {
+	st := r.current
+	if st == nil || len(st.buffer) == 0 {
+		return nil
+	}
+
+	bufReader := bytes.NewReader(st.buffer)
+	dec := cbor.NewDecoder(bufReader)
+	var item interface{}
+	if dec.Decode(&item) != nil {
+		return nil
+	}
+
+	bytesConsumed := len(st.buffer) - bufReader.Len()
+	msg := &Message{
+		ID:         st.key.id,
+		Bus:        st.key.bus,
+		Frames:     st.frames,
+		FrameCount: st.frameCount,
+		Raw:        st.buffer[:bytesConsumed:bytesConsumed],
+		Item:       item,
+	}
+	if len(st.frames) > 0 {
+		last := st.frames[len(st.frames)-1]
+		msg.Timestamp = last.TimestampFloat
+	}
+	for _, f := range st.frames {
+		f.Message = msg
+	}
+
+	// Remove consumed bytes; leftovers start the next message
+	st.buffer = st.buffer[bytesConsumed:]
+	if len(st.buffer) > 0 {
+		st.frames = st.frames[len(st.frames)-1:]
+	} else {
+		st.frames = nil
+	}
+	return msg
+}
+
+// Add appends a frame and returns the message it completes, if any
+func (r *Reassembler) Add(f *FrameInfo) *Message {
+	if !f.IsCBOR {
+		return nil
+	}
+	r.Append(f)
+	return r.Decode()
+}
+
+// Buffer returns the bytes of the message being assembled for the last
+// appended frame
+func (r *Reassembler) Buffer() []byte {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.buffer
+}
+
+// FrameCount returns the number of frames appended since the last START of
+// the last appended frame's bus and CAN ID
+func (r *Reassembler) FrameCount() int {
+	if r.current == nil {
+		return 0
+	}
+	return r.current.frameCount
+}
+
+// attachMessages reassembles the CBOR messages in a capture, linking each
+// frame to the message it belongs to, and returns the messages in order
+func attachMessages(frames []*FrameInfo) []*Message {
+	var reasm Reassembler
+	var messages []*Message
+	for _, f := range frames {
+		if msg := reasm.Add(f); msg != nil {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
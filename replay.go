@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// parseIDList parses a comma separated list of hex CAN IDs into a set. As
+// in candump, 123 is a standard and 00000123 an extended ID.
+func parseIDList(list string) (map[CANID]bool, error) {
+	ids := make(map[CANID]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := ParseCANID(field)
+		if err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// selectReplayFrames applies ID filters and the start/end window (seconds
+// relative to first, the first frame of the capture before any -filter) to
+// frames
+func selectReplayFrames(frames []*FrameInfo, first float64, include, exclude map[CANID]bool, start, end float64) []*FrameInfo {
+	var selected []*FrameInfo
+	for _, f := range frames {
+		offset := f.TimestampFloat - first
+		if offset < start || end > 0 && offset > end {
+			continue
+		}
+		id := f.Frame.ID
+		if len(include) > 0 && !include[id] {
+			continue
+		}
+		if exclude[id] {
+			continue
+		}
+		selected = append(selected, f)
+	}
+	return selected
+}
+
+// runReplay implements the replay subcommand: re-emit a capture with its
+// recorded timing to a SocketCAN interface or as candump text
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	iface := fs.String("iface", "vcan", "interface name prefix written in candump output or used with -send; the bus number is appended")
+	send := fs.Bool("send", false, "transmit frames on the SocketCAN interface instead of printing them")
+	speed := fs.Float64("speed", 1.0, "playback speed multiplier (0 = as fast as possible)")
+	loops := fs.Int("loop", 1, "number of times to replay the capture (0 = forever)")
+	ids := fs.String("ids", "", "comma separated CAN IDs to replay (default all)")
+	excludeIDs := fs.String("exclude-ids", "", "comma separated CAN IDs to skip")
+	start := fs.Float64("start", 0, "start offset in seconds from the first frame")
+	end := fs.Float64("end", 0, "end offset in seconds from the first frame (0 = until the end)")
+	filterExpr := fs.String("filter", "", "only replay frames matching a filter expression")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus replay [flags] [capture file]")
+		fmt.Fprintln(fs.Output(), "Reads stdin when no file is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *speed < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -speed must not be negative")
+		os.Exit(1)
+	}
+	include, err := parseIDList(*ids)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	exclude, err := parseIDList(*excludeIDs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	filter, err := ParseFilter(*filterExpr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	var input io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	frames, err := readAllFrames(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if len(frames) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no frames to replay")
+		os.Exit(1)
+	}
+	captureFirst := frames[0].TimestampFloat
+	if filter.Active() {
+		attachMessages(frames)
+		frames = filterFrames(frames, filter)
+	}
+	frames = selectReplayFrames(frames, captureFirst, include, exclude, *start, *end)
+	if len(frames) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no frames to replay")
+		os.Exit(1)
+	}
+
+	first := frames[0].TimestampFloat
+	duration := frames[len(frames)-1].TimestampFloat - first
+	pace := fmt.Sprintf("at %.2fx", *speed)
+	if *speed == 0 {
+		pace = "without timing"
+	}
+	fmt.Fprintf(os.Stderr, "▶️ Replaying %d frames (%s) %s\n", len(frames), formatDuration(duration), pace)
+
+	if err := playReplay(frames, *loops, *speed, *iface, *send); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// replayLoopGap returns the time between the last frame of a pass and the
+// first frame of the next: the median gap between frames, or 1 ms
+func replayLoopGap(frames []*FrameInfo) float64 {
+	var gaps []float64
+	for i := 1; i < len(frames); i++ {
+		if gap := frames[i].TimestampFloat - frames[i-1].TimestampFloat; gap > 0 {
+			gaps = append(gaps, gap)
+		}
+	}
+	if len(gaps) == 0 {
+		return 0.001
+	}
+	sort.Float64s(gaps)
+	return gaps[len(gaps)/2]
+}
+
+// playReplay prints the frames as candump text, or sends them, each bus on
+// its own interface named from the iface prefix, loops times (0 = forever)
+// at speed times their recorded pace
+func playReplay(frames []*FrameInfo, loops int, speed float64, iface string, send bool) error {
+	socks := make(map[int]*canSocket)
+	defer func() {
+		for _, sock := range socks {
+			sock.Close()
+		}
+	}()
+	if send {
+		for _, f := range frames {
+			if socks[f.Frame.Bus] != nil {
+				continue
+			}
+			sock, err := openCANSocket(busInterface(iface, f.Frame.Bus))
+			if err != nil {
+				return err
+			}
+			socks[f.Frame.Bus] = sock
+		}
+	}
+
+	first := frames[0].TimestampFloat
+	gap := replayLoopGap(frames)
+	period := frames[len(frames)-1].TimestampFloat - first + gap
+
+	for loop := 0; loops == 0 || loop < loops; loop++ {
+		if loop > 0 && speed > 0 {
+			time.Sleep(time.Duration(gap / speed * float64(time.Second)))
+		}
+		// Schedule against the wall clock at the start of each pass so
+		// sleep overshoot does not accumulate over long captures
+		passStart := time.Now()
+		// Shift timestamps of later passes by the capture length plus one
+		// inter-frame gap so the output stays strictly monotonic
+		shift := float64(loop) * period
+
+		for _, f := range frames {
+			offset := f.TimestampFloat - first
+			if speed > 0 {
+				target := passStart.Add(time.Duration(offset / speed * float64(time.Second)))
+				if wait := time.Until(target); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+
+			if send {
+				if err := socks[f.Frame.Bus].WriteFrame(f.Frame); err != nil {
+					return fmt.Errorf("sending frame on %s: %v", busInterface(iface, f.Frame.Bus), err)
+				}
+				continue
+			}
+			fmt.Println(formatCandumpLine(f.Frame, f.TimestampFloat+shift, busInterface(iface, f.Frame.Bus)))
+		}
+	}
+	return nil
+}
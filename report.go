@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writeComparisonJSON writes a comparison result as indented JSON
+func writeComparisonJSON(w io.Writer, result *ComparisonResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// writeComparisonCSV writes one row per pattern with a count column per file
+func writeComparisonCSV(w io.Writer, result *ComparisonResult) error {
+	cw := csv.NewWriter(w)
+	header := []string{"id", "header", "data", "category"}
+	for _, f := range result.Files {
+		header = append(header, f.Name)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, p := range result.Patterns {
+		row := []string{p.ID, fmt.Sprintf("%02X", p.Header), p.DataHex, p.Category}
+		for _, n := range result.Counts(p) {
+			row = append(row, strconv.Itoa(n))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reportRow is one pattern prepared for the HTML template
+type reportRow struct {
+	ID       string
+	Header   string
+	Data     string
+	Category string
+	Cells    []reportCell
+}
+
+// reportCell is one per-file occurrence count with its heatmap shade
+type reportCell struct {
+	Count int
+	Shade float64 // 0 (absent) to 1 (most frequent pattern of the file)
+}
+
+// writeComparisonHTML writes a self-contained HTML report with sortable
+// tables and a per-file occurrence heatmap
+func writeComparisonHTML(w io.Writer, result *ComparisonResult) error {
+	// Shade counts relative to the most frequent pattern of each file
+	maxCounts := make([]int, len(result.Files))
+	for _, p := range result.Patterns {
+		for i, n := range result.Counts(p) {
+			maxCounts[i] = max(maxCounts[i], n)
+		}
+	}
+
+	rows := make([]reportRow, 0, len(result.Patterns))
+	summary := map[string]int{}
+	for _, p := range result.Patterns {
+		row := reportRow{ID: p.ID, Header: fmt.Sprintf("%02X", p.Header), Data: p.DataHex, Category: p.Category}
+		for i, n := range result.Counts(p) {
+			cell := reportCell{Count: n}
+			if n > 0 {
+				cell.Shade = 0.15 + 0.85*float64(n)/float64(maxCounts[i])
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		rows = append(rows, row)
+		summary[p.Category]++
+	}
+
+	return reportTemplate.Execute(w, map[string]interface{}{
+		"Files":   result.Files,
+		"Mask":    result.Mask,
+		"Rows":    rows,
+		"Summary": summary,
+		"Total":   len(result.Patterns),
+		"Version": Version,
+	})
+}
+
+// comparisonReportWriter returns the exporter for a report path, chosen by
+// the file extension: .json, .csv or .html
+func comparisonReportWriter(path string) (func(io.Writer, *ComparisonResult) error, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return writeComparisonJSON, nil
+	case ".csv":
+		return writeComparisonCSV, nil
+	case ".html", ".htm":
+		return writeComparisonHTML, nil
+	}
+	return nil, fmt.Errorf("unknown report format %q (use .json, .csv or .html)", filepath.Ext(path))
+}
+
+// writeComparisonReport writes the result to path in the format given by the
+// file extension
+func writeComparisonReport(path string, result *ComparisonResult) error {
+	write, err := comparisonReportWriter(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file, result); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Unaccounted frames comparison</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 3px 8px; text-align: left; }
+th { background: #eee; cursor: pointer; user-select: none; }
+th.asc::after { content: " ▲"; }
+th.desc::after { content: " ▼"; }
+td.mono { font-family: ui-monospace, monospace; }
+td.count { text-align: right; font-family: ui-monospace, monospace; }
+tr.common td.cat { color: #2a7; }
+tr.unique td.cat { color: #c60; }
+tr.partial td.cat { color: #36c; }
+input { margin-bottom: 1em; padding: 4px; width: 24em; }
+</style>
+</head>
+<body>
+<h1>📊 Unaccounted frames comparison</h1>
+<table>
+<thead><tr><th>#</th><th>File</th><th>Unaccounted frames</th><th>Total frames</th></tr></thead>
+<tbody>
+{{range $i, $f := .Files}}<tr><td>[{{inc $i}}]</td><td>{{$f.Name}}</td><td class="count">{{$f.UnaccountedFrames}}</td><td class="count">{{$f.TotalFrames}}</td></tr>
+{{end}}</tbody>
+</table>
+<p>{{.Total}} patterns: {{index .Summary "common"}} common to all files, {{index .Summary "unique"}} unique to one file, {{index .Summary "partial"}} in some files.{{if .Mask}} Masked bytes: <code>{{.Mask}}</code>.{{end}}</p>
+<input id="search" type="search" placeholder="Filter rows, e.g. 14609460 or unique">
+<table id="patterns">
+<thead><tr><th>ID</th><th>Hdr</th><th>Data</th><th>Category</th>{{range $i, $f := .Files}}<th title="{{$f.Name}}">[{{inc $i}}]</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr class="{{.Category}}"><td class="mono">0x{{.ID}}</td><td class="mono">{{.Header}}</td><td class="mono">{{.Data}}</td><td class="cat">{{.Category}}</td>{{range .Cells}}<td class="count" style="background: rgba(220, 60, 40, {{printf "%.2f" .Shade}})">{{.Count}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+<p><small>vanmoof-canbus {{.Version}}</small></p>
+<script>
+document.querySelectorAll("th").forEach(function (th) {
+  th.addEventListener("click", function () {
+    var table = th.closest("table"), body = table.tBodies[0];
+    var col = Array.prototype.indexOf.call(th.parentNode.children, th);
+    var asc = !th.classList.contains("asc");
+    th.parentNode.querySelectorAll("th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(asc ? "asc" : "desc");
+    var rows = Array.prototype.slice.call(body.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var cmp = a.cells[col].classList.contains("count") ? x - y : x.localeCompare(y);
+      return asc ? cmp : -cmp;
+    });
+    rows.forEach(function (r) { body.appendChild(r); });
+  });
+});
+document.getElementById("search").addEventListener("input", function (e) {
+  var q = e.target.value.toLowerCase();
+  document.querySelectorAll("#patterns tbody tr").forEach(function (r) {
+    r.style.display = r.textContent.toLowerCase().indexOf(q) >= 0 ? "" : "none";
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultServeHistory is the number of decoded messages the server keeps for
+// /api/messages and for WebSocket clients catching up
+const defaultServeHistory = 10000
+
+// serveClientBuffer is the number of messages queued per WebSocket client;
+// clients falling further behind are disconnected
+const serveClientBuffer = 1024
+
+// websocketGUID is appended to the client key in the WebSocket handshake
+// (RFC 6455, section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// servedMessage is a decoded message as published by the server. The JSON
+// is encoded once and shared by the REST API and all WebSocket clients.
+type servedMessage struct {
+	Seq  int64
+	ID   string
+	JSON []byte
+}
+
+// messageJSON is the JSON form of a decoded message
+type messageJSON struct {
+	Seq       int64       `json:"seq"`
+	Timestamp float64     `json:"timestamp"`
+	ID        string      `json:"id"`
+	CANID     string      `json:"can_id"`
+	Bus       int         `json:"bus"`
+	BusName   string      `json:"bus_name"`
+	Frames    int         `json:"frames"`
+	Raw       string      `json:"raw"`
+	Data      interface{} `json:"data"`
+}
+
+// idJSON is the JSON form of the statistics of one CAN ID
+type idJSON struct {
+	ID          string      `json:"id"`
+	CANID       string      `json:"can_id"`
+	Bus         int         `json:"bus"`
+	Fields      string      `json:"fields,omitempty"`
+	Count       int         `json:"count"`
+	First       float64     `json:"first"`
+	Last        float64     `json:"last"`
+	Rate        float64     `json:"rate"`
+	Type        string      `json:"type"`
+	Data        string      `json:"data"`
+	Changed     []int       `json:"changed"`
+	LastMessage interface{} `json:"last_message,omitempty"`
+}
+
+// captureServer runs the decoder on a capture or live interface and serves
+// its state over HTTP. All fields below mu are guarded by it.
+type captureServer struct {
+	source      string
+	live        bool
+	allowOrigin string
+	history     int
+
+	mu       sync.Mutex
+	state    *CaptureState
+	messages []*servedMessage // the last history messages, oldest first
+	seq      int64
+	done     bool
+	err      error
+	clients  map[chan *servedMessage]bool
+}
+
+// newCaptureServer returns a server with an empty capture state
+func newCaptureServer(source string, live bool, filter *Filter, history int, allowOrigin string) *captureServer {
+	return &captureServer{
+		source:      source,
+		live:        live,
+		allowOrigin: allowOrigin,
+		history:     history,
+		state:       NewCaptureState(filter),
+		clients:     make(map[chan *servedMessage]bool),
+	}
+}
+
+// Add feeds a frame to the decoder and publishes the message it completes
+func (s *captureServer) Add(info *FrameInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg := s.state.Add(info)
+	if msg == nil {
+		return
+	}
+
+	s.seq++
+	data, err := json.Marshal(messageJSON{
+		Seq:       s.seq,
+		Timestamp: msg.Timestamp,
+		ID:        msg.SourceID(),
+		CANID:     msg.ID.String(),
+		Bus:       msg.Bus,
+		BusName:   busName(msg.Bus),
+		Frames:    msg.FrameCount,
+		Raw:       fmt.Sprintf("%X", msg.Raw),
+		Data:      cborJSON(msg.Item),
+	})
+	if err != nil {
+		return
+	}
+	m := &servedMessage{Seq: s.seq, ID: msg.SourceID(), JSON: data}
+	s.messages = append(s.messages, m)
+	if len(s.messages) > s.history {
+		s.messages = s.messages[len(s.messages)-s.history:]
+	}
+
+	// Clients that cannot keep up are dropped rather than stalling the decoder
+	for ch := range s.clients {
+		select {
+		case ch <- m:
+		default:
+			delete(s.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// Finish records that the input has ended, with the error that ended it
+func (s *captureServer) Finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.err = err
+}
+
+// subscribe registers a WebSocket client and returns its channel together
+// with the kept messages after since, so that none are missed in between
+func (s *captureServer) subscribe(since int64) (chan *servedMessage, []*servedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan *servedMessage, serveClientBuffer)
+	s.clients[ch] = true
+	return ch, s.messagesAfter(since)
+}
+
+// unsubscribe removes a client unless it was already dropped
+func (s *captureServer) unsubscribe(ch chan *servedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[ch] {
+		delete(s.clients, ch)
+		close(ch)
+	}
+}
+
+// messagesAfter returns the kept messages with a sequence number above since
+func (s *captureServer) messagesAfter(since int64) []*servedMessage {
+	i := sort.Search(len(s.messages), func(i int) bool { return s.messages[i].Seq > since })
+	return s.messages[i:]
+}
+
+// now returns the time rates are computed at: the wall clock for live
+// interfaces, the last frame for files
+func (s *captureServer) now() float64 {
+	if s.live {
+		return float64(time.Now().UnixMicro()) / 1_000_000
+	}
+	return s.state.Now
+}
+
+// idStats converts the statistics of one CAN ID, with its last decoded
+// message if withMessage is set
+func (s *captureServer) idStats(stats *IDStats, withMessage bool) idJSON {
+	frame := stats.LastFrame
+	out := idJSON{
+		ID:      stats.ID,
+		CANID:   frame.ID.String(),
+		Bus:     frame.Bus,
+		Fields:  idLayout.Format(frame.ID),
+		Count:   stats.Count,
+		First:   stats.First,
+		Last:    stats.Last,
+		Rate:    stats.Rate(s.now()),
+		Type:    stats.LastType,
+		Data:    fmt.Sprintf("%X", stats.LastData),
+		Changed: []int{},
+	}
+	for i, changed := range stats.ChangedBytes() {
+		if changed {
+			out.Changed = append(out.Changed, i)
+		}
+	}
+	if withMessage && stats.LastMessage != nil {
+		out.LastMessage = cborJSON(stats.LastMessage.Item)
+	}
+	return out
+}
+
+// handler returns the routes of the web UI and the API
+func (s *captureServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, serveIndexHTML)
+	})
+	mux.HandleFunc("GET /api/capture", s.handleCapture)
+	mux.HandleFunc("GET /api/stats", s.handleStats)
+	mux.HandleFunc("GET /api/ids", s.handleIDs)
+	mux.HandleFunc("GET /api/ids/{id}", s.handleID)
+	mux.HandleFunc("GET /api/messages", s.handleMessages)
+	mux.HandleFunc("GET /api/stream", s.handleStream)
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "no such endpoint")
+	})
+	return mux
+}
+
+// writeJSON writes v as the JSON response
+func (s *captureServer) writeJSON(w http.ResponseWriter, v interface{}) {
+	if s.allowOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", s.allowOrigin)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes an error response as {"error": "..."}
+func writeJSONError(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": text})
+}
+
+// handleCapture describes the capture being served
+func (s *captureServer) handleCapture(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	st := s.state
+	out := map[string]interface{}{
+		"source":   s.source,
+		"live":     s.live,
+		"done":     s.done,
+		"start":    st.Start,
+		"now":      st.Now,
+		"duration": st.Now - st.Start,
+		"frames":   st.Frames,
+		"messages": s.seq,
+		"ids":      len(st.IDs),
+	}
+	if s.err != nil {
+		out["error"] = s.err.Error()
+	}
+	s.mu.Unlock()
+	s.writeJSON(w, out)
+}
+
+// handleStats returns frame counts by class and per bus
+func (s *captureServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	st := s.state
+	buses := make([]map[string]interface{}, 0, len(st.Buses))
+	for _, b := range st.Buses {
+		buses = append(buses, map[string]interface{}{
+			"bus":        b.Bus,
+			"name":       busName(b.Bus),
+			"frames":     b.Frames,
+			"ids":        len(b.IDs),
+			"messages":   b.Messages,
+			"heartbeats": b.Heartbeats,
+		})
+	}
+	sort.Slice(buses, func(i, j int) bool { return buses[i]["bus"].(int) < buses[j]["bus"].(int) })
+	rate := 0.0
+	if duration := st.Now - st.Start; duration > 0 {
+		rate = float64(st.Frames) / duration
+	}
+	out := map[string]interface{}{
+		"frames":        st.Frames,
+		"matched":       st.Matched,
+		"cbor_messages": st.CBORMessages,
+		"heartbeats":    st.Heartbeats,
+		"unaccounted":   max(st.Frames-st.CBORMessages-st.Heartbeats, 0),
+		"frame_rate":    rate,
+		"buses":         buses,
+	}
+	s.mu.Unlock()
+	s.writeJSON(w, out)
+}
+
+// handleIDs lists the statistics of every CAN ID matching the filter
+func (s *captureServer) handleIDs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ids := make([]idJSON, 0, len(s.state.IDs))
+	for _, id := range s.state.SortedIDs() {
+		ids = append(ids, s.idStats(s.state.IDs[id], false))
+	}
+	s.mu.Unlock()
+	s.writeJSON(w, ids)
+}
+
+// handleID returns one CAN ID with its last decoded message
+func (s *captureServer) handleID(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	stats, ok := s.state.IDs[r.PathValue("id")]
+	var out idJSON
+	if ok {
+		out = s.idStats(stats, true)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("unknown CAN ID %q", r.PathValue("id")))
+		return
+	}
+	s.writeJSON(w, out)
+}
+
+// handleMessages returns kept messages, optionally of one CAN ID: the first
+// limit after sequence number since, or the last limit without since
+func (s *captureServer) handleMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, err := queryInt(query, "since", -1)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(query, "limit", 100)
+	if err != nil || limit <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "limit must be a positive number")
+		return
+	}
+	id := query.Get("id")
+
+	s.mu.Lock()
+	var selected []json.RawMessage
+	for _, m := range s.messagesAfter(since) {
+		if id != "" && m.ID != id {
+			continue
+		}
+		selected = append(selected, m.JSON)
+		if since >= 0 && int64(len(selected)) == limit {
+			break
+		}
+	}
+	last := s.seq
+	s.mu.Unlock()
+
+	if int64(len(selected)) > limit {
+		selected = selected[int64(len(selected))-limit:]
+	}
+	if selected == nil {
+		selected = []json.RawMessage{}
+	}
+	s.writeJSON(w, map[string]interface{}{"messages": selected, "last": last})
+}
+
+// queryInt parses an integer query parameter, returning def if it is absent
+func queryInt(query url.Values, name string, def int64) (int64, error) {
+	text := query.Get(name)
+	if text == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, text)
+	}
+	return n, nil
+}
+
+// handleStream upgrades to a WebSocket and sends each decoded message as a
+// text frame, optionally only those of one CAN ID and starting with the kept
+// messages after sequence number since
+func (s *captureServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeJSONError(w, http.StatusBadRequest, "expected a WebSocket upgrade")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeJSONError(w, http.StatusBadRequest, "unsupported WebSocket version")
+		return
+	}
+	if !s.originAllowed(r) {
+		writeJSONError(w, http.StatusForbidden, "origin not allowed")
+		return
+	}
+	since, err := queryInt(r.URL.Query(), "since", -1)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := r.URL.Query().Get("id")
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "connection cannot be upgraded")
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(accept[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ch, backlog := s.subscribe(since)
+	defer s.unsubscribe(ch)
+	if since < 0 {
+		backlog = nil
+	}
+
+	// The reader answers pings through the writer, which owns the connection
+	pongs := make(chan []byte, 4)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		readWebSocket(rw.Reader, pongs)
+	}()
+
+	send := func(m *servedMessage) bool {
+		if id != "" && m.ID != id {
+			return true
+		}
+		return writeWebSocketFrame(conn, wsText, m.JSON) == nil
+	}
+	for _, m := range backlog {
+		if !send(m) {
+			return
+		}
+	}
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				// Dropped for falling behind: 1008 policy violation
+				writeWebSocketFrame(conn, wsClose, append([]byte{0x03, 0xF0}, "client too slow"...))
+				return
+			}
+			if !send(m) {
+				return
+			}
+		case payload := <-pongs:
+			if writeWebSocketFrame(conn, wsPong, payload) != nil {
+				return
+			}
+		case <-closed:
+			writeWebSocketFrame(conn, wsClose, nil)
+			return
+		}
+	}
+}
+
+// originAllowed rejects WebSocket connections from pages of other origins,
+// unless allowed with -allow-origin; clients without an Origin are allowed
+func (s *captureServer) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.allowOrigin == "*" || origin == s.allowOrigin {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// headerHasToken reports whether a comma-separated header contains a token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeWebSocketFrame writes one unfragmented, unmasked server frame
+func writeWebSocketFrame(w io.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// readWebSocket reads client frames until the connection closes or the
+// client sends a close frame. Data frames are ignored; ping payloads are
+// passed on to be answered.
+func readWebSocket(r *bufio.Reader, pongs chan<- []byte) error {
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return err
+		}
+		opcode := head[0] & 0x0F
+		n := uint64(head[1] & 0x7F)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > 1<<20 {
+			return errors.New("websocket: client frame too large")
+		}
+		var mask [4]byte
+		if head[1]&0x80 != 0 {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				return err
+			}
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsClose:
+			return nil
+		case wsPing:
+			select {
+			case pongs <- payload:
+			default:
+			}
+		}
+	}
+}
+
+// playFrames feeds file frames to the server, at their recorded pace scaled
+// by speed, or all at once if speed is 0
+func playFrames(s *captureServer, frames []*FrameInfo, speed float64) {
+	start := time.Now()
+	for _, f := range frames {
+		if speed > 0 {
+			offset := (f.TimestampFloat - frames[0].TimestampFloat) / speed
+			time.Sleep(time.Until(start.Add(time.Duration(offset * float64(time.Second)))))
+		}
+		s.Add(f)
+	}
+	s.Finish(nil)
+}
+
+// runServe implements the serve subcommand
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	iface := fs.String("iface", "", "read live frames from a SocketCAN interface instead of a file")
+	speed := fs.Float64("speed", 0, "play files back at their recorded pace times this factor (0 = decode the whole capture at once)")
+	filterExpr := fs.String("filter", "", "only track frames matching a filter expression")
+	history := fs.Int("history", defaultServeHistory, "decoded messages kept for /api/messages and reconnecting clients")
+	allowOrigin := fs.String("allow-origin", "", "let pages from this origin use the API, e.g. 'http://localhost:3000' or '*'")
+	busNameSpec := fs.String("bus-names", "", "names for bus numbers, e.g. '0=main,1=battery'")
+	idLayoutSpec := fs.String("id-layout", "", "decompose extended IDs into fields: 'j1939' or 'name:shift:bits,...'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus serve [flags] capture-file")
+		fmt.Fprintln(fs.Output(), "       canbus serve -iface can0")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	names, err := parseBusNames(*busNameSpec)
+	if err != nil {
+		fail(err)
+	}
+	busNames = names
+	idLayout, err = parseIDLayout(*idLayoutSpec)
+	if err != nil {
+		fail(err)
+	}
+	filter, err := ParseFilter(*filterExpr)
+	if err != nil {
+		fail(err)
+	}
+	if *history <= 0 {
+		fail(fmt.Errorf("-history must be positive"))
+	}
+
+	var server *captureServer
+	switch {
+	case *iface != "":
+		sock, err := openCANSocket(*iface)
+		if err != nil {
+			fail(err)
+		}
+		defer sock.Close()
+		server = newCaptureServer(*iface, true, filter, *history, *allowOrigin)
+		live := make(chan *FrameInfo, 1024)
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- readLiveFrames(sock, live)
+			close(live)
+		}()
+		go func() {
+			for f := range live {
+				server.Add(f)
+			}
+			server.Finish(<-readErr)
+		}()
+	case fs.NArg() > 0:
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		frames, err := readAllFrames(file)
+		file.Close()
+		if err != nil {
+			fail(err)
+		}
+		if len(frames) == 0 {
+			fail(fmt.Errorf("no frames in %s", fs.Arg(0)))
+		}
+		server = newCaptureServer(filepath.Base(fs.Arg(0)), false, filter, *history, *allowOrigin)
+		go playFrames(server, frames, *speed)
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Fprintf(os.Stderr, "🌐 Serving %s on http://%s/ (API under /api/, Ctrl-C to stop)\n", server.source, listener.Addr())
+	if err := http.Serve(listener, server.handler()); err != nil {
+		fail(err)
+	}
+}
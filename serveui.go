@@ -0,0 +1,131 @@
+package main
+
+// serveIndexHTML is the web UI of the serve subcommand: the per-ID table
+// and statistics polled from the REST API, the selected ID's last decoded
+// message and a live message log fed by the WebSocket stream
+const serveIndexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>canbus</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: grid; grid-template-columns: 1fr 1fr; grid-template-rows: auto 1fr 1fr; height: 100vh; }
+header { grid-column: 1 / 3; padding: 8px 12px; background: #223; color: #eee; }
+section { overflow: auto; padding: 8px 12px; border-top: 1px solid #ccc; }
+#ids-pane { grid-row: 2 / 4; border-right: 1px solid #ccc; }
+table { border-collapse: collapse; width: 100%; font-size: 13px; }
+th, td { text-align: left; padding: 2px 6px; }
+tr.selected { background: #def; }
+tbody tr { cursor: pointer; }
+.mono, pre { font-family: monospace; font-size: 12px; }
+.changed { color: #c60; font-weight: bold; }
+pre { margin: 0; white-space: pre-wrap; }
+#log div { font-family: monospace; font-size: 12px; border-bottom: 1px solid #eee; }
+</style>
+</head>
+<body>
+<header><b>canbus</b> <span id="capture"></span></header>
+<section id="ids-pane">
+<table>
+<thead><tr><th>ID</th><th>Count</th><th>Rate/s</th><th>Type</th><th>Last payload</th></tr></thead>
+<tbody id="ids"></tbody>
+</table>
+<p id="stats"></p>
+</section>
+<section><h4 id="selected">Select an ID</h4><pre id="message"></pre></section>
+<section><h4>Messages <span id="stream-state"></span></h4><div id="log"></div></section>
+<script>
+var selected = null;
+var lastSeq = -1;
+
+function get(path) {
+  return fetch(path).then(function (r) { return r.json(); });
+}
+
+function text(id, value) {
+  document.getElementById(id).textContent = value;
+}
+
+function payload(hex, changed) {
+  var span = document.createElement("span");
+  span.className = "mono";
+  for (var i = 0; i < hex.length / 2; i++) {
+    var b = document.createElement("span");
+    b.textContent = hex.substr(i * 2, 2) + " ";
+    if (changed.indexOf(i) >= 0) b.className = "changed";
+    span.appendChild(b);
+  }
+  return span;
+}
+
+function refresh() {
+  get("api/capture").then(function (c) {
+    text("capture", c.source + (c.live ? " (live)" : "") + " | " + c.frames + " frames, " +
+      c.messages + " messages, " + c.duration.toFixed(3) + " s" +
+      (c.done ? " | ended" : "") + (c.error ? ": " + c.error : ""));
+  });
+  get("api/stats").then(function (s) {
+    var parts = ["CBOR messages " + s.cbor_messages, "heartbeats " + s.heartbeats,
+      "unaccounted " + s.unaccounted, s.frame_rate.toFixed(1) + " frames/s"];
+    s.buses.forEach(function (b) {
+      parts.push(b.name + ": " + b.frames + " frames, " + b.ids + " IDs");
+    });
+    text("stats", parts.join(" | "));
+  });
+  get("api/ids").then(function (ids) {
+    var body = document.getElementById("ids");
+    body.textContent = "";
+    ids.forEach(function (s) {
+      var tr = document.createElement("tr");
+      if (s.id === selected) tr.className = "selected";
+      [s.id + (s.fields ? " (" + s.fields + ")" : ""), s.count, s.rate.toFixed(1), s.type].forEach(function (v) {
+        var td = document.createElement("td");
+        td.textContent = v;
+        tr.appendChild(td);
+      });
+      var td = document.createElement("td");
+      td.appendChild(payload(s.data, s.changed));
+      tr.appendChild(td);
+      tr.onclick = function () { selected = s.id; refresh(); };
+      body.appendChild(tr);
+    });
+  });
+  if (selected !== null) {
+    get("api/ids/" + encodeURIComponent(selected)).then(function (s) {
+      text("selected", s.id);
+      text("message", s.last_message === undefined ? "no decoded message" : JSON.stringify(s.last_message, null, 2));
+    });
+  }
+}
+
+function show(m) {
+  lastSeq = m.seq;
+  var log = document.getElementById("log");
+  var line = document.createElement("div");
+  line.textContent = m.timestamp.toFixed(6) + " " + m.id + " " + JSON.stringify(m.data);
+  log.insertBefore(line, log.firstChild);
+  while (log.childNodes.length > 200) log.removeChild(log.lastChild);
+}
+
+function connect() {
+  var url = location.href.replace(/^http/, "ws").replace(/\/[^\/]*$/, "/api/stream") + "?since=" + lastSeq;
+  var ws = new WebSocket(url);
+  ws.onopen = function () { text("stream-state", "(live)"); };
+  ws.onmessage = function (e) { show(JSON.parse(e.data)); };
+  ws.onclose = function () {
+    text("stream-state", "(reconnecting)");
+    setTimeout(connect, 2000);
+  };
+}
+
+refresh();
+setInterval(refresh, 1000);
+get("api/messages?limit=200").then(function (r) {
+  r.messages.forEach(show);
+  lastSeq = r.last;
+  connect();
+});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,118 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	canRaw     = 1          // CAN_RAW protocol
+	canEFFFlag = 0x80000000 // extended frame format flag in can_id
+	canRTRFlag = 0x40000000 // remote transmission request flag in can_id
+	canEFFMask = 0x1FFFFFFF
+	canSFFMask = 0x000007FF
+	canFrameSz = 16 // sizeof(struct can_frame)
+)
+
+// sockaddrCAN mirrors struct sockaddr_can from <linux/can.h>
+type sockaddrCAN struct {
+	Family  uint16
+	_       uint16
+	Ifindex int32
+	Addr    [16]byte
+}
+
+// canSocket is a raw SocketCAN socket bound to a single interface
+type canSocket struct {
+	fd    int
+	iface string
+}
+
+// openCANSocket opens a raw CAN socket on the named interface (e.g. vcan0)
+func openCANSocket(iface string) (*canSocket, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", iface, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_CAN, syscall.SOCK_RAW, canRaw)
+	if err != nil {
+		return nil, fmt.Errorf("socket: %v", err)
+	}
+
+	addr := sockaddrCAN{Family: syscall.AF_CAN, Ifindex: int32(ifi.Index)}
+	_, _, errno := syscall.Syscall(syscall.SYS_BIND, uintptr(fd),
+		uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr))
+	if errno != 0 {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind %s: %v", iface, errno)
+	}
+
+	return &canSocket{fd: fd, iface: iface}, nil
+}
+
+// WriteFrame sends a classic CAN frame (up to 8 data bytes)
+func (s *canSocket) WriteFrame(frame *CANFrame) error {
+	if len(frame.Data) > 8 {
+		return fmt.Errorf("frame data too long: %d bytes", len(frame.Data))
+	}
+	var buf [canFrameSz]byte
+	canID := frame.ID.Value
+	if frame.ID.Extended {
+		canID = canID&canEFFMask | canEFFFlag
+	} else {
+		canID &= canSFFMask
+	}
+	binary.NativeEndian.PutUint32(buf[0:4], canID)
+	buf[4] = byte(len(frame.Data))
+	copy(buf[8:], frame.Data)
+
+	_, err := syscall.Write(s.fd, buf[:])
+	return err
+}
+
+// ReadFrame blocks until a frame is received on the socket
+func (s *canSocket) ReadFrame() (*CANFrame, error) {
+	var buf [canFrameSz]byte
+	for {
+		n, err := syscall.Read(s.fd, buf[:])
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, err
+		}
+		if n < canFrameSz {
+			return nil, fmt.Errorf("short CAN frame read: %d bytes", n)
+		}
+
+		canID := binary.NativeEndian.Uint32(buf[0:4])
+		if canID&canRTRFlag != 0 {
+			continue // remote frames carry no payload
+		}
+		length := int(buf[4])
+		if length > 8 {
+			length = 8
+		}
+
+		frame := &CANFrame{
+			ID:     CANID{Value: canID & canSFFMask},
+			Length: length,
+			Data:   append([]byte(nil), buf[8:8+length]...),
+		}
+		if canID&canEFFFlag != 0 {
+			frame.ID = CANID{Value: canID & canEFFMask, Extended: true}
+		}
+		return frame, nil
+	}
+}
+
+// Close closes the socket
+func (s *canSocket) Close() error {
+	return syscall.Close(s.fd)
+}
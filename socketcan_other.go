@@ -0,0 +1,33 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// canSocket is unavailable outside Linux; SocketCAN is a Linux kernel API
+type canSocket struct {
+	iface string
+}
+
+// openCANSocket always fails on platforms without SocketCAN
+func openCANSocket(iface string) (*canSocket, error) {
+	return nil, fmt.Errorf("SocketCAN is not supported on %s", runtime.GOOS)
+}
+
+// WriteFrame is not supported on this platform
+func (s *canSocket) WriteFrame(frame *CANFrame) error {
+	return fmt.Errorf("SocketCAN is not supported on %s", runtime.GOOS)
+}
+
+// ReadFrame is not supported on this platform
+func (s *canSocket) ReadFrame() (*CANFrame, error) {
+	return nil, fmt.Errorf("SocketCAN is not supported on %s", runtime.GOOS)
+}
+
+// Close is a no-op on this platform
+func (s *canSocket) Close() error {
+	return nil
+}
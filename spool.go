@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// defaultSpoolFrames is the number of frames -group-by-id keeps in memory
+// before spilling a sorted run to disk
+const defaultSpoolFrames = 1_000_000
+
+// spoolRecord is the part of a frame needed to display it grouped by ID
+type spoolRecord struct {
+	Key       string // bus-qualified CAN ID, with the header byte if grouped by header
+	Bus       int
+	ID        CANID
+	Direction string
+	Timestamp float64
+	Seq       int
+	FrameType string
+	Data      []byte
+}
+
+// less orders records by bus and ID, then by time and capture order
+func (r *spoolRecord) less(o *spoolRecord) bool {
+	if r.Bus != o.Bus {
+		return r.Bus < o.Bus
+	}
+	if r.Key != o.Key {
+		return r.Key < o.Key
+	}
+	if r.Timestamp != o.Timestamp {
+		return r.Timestamp < o.Timestamp
+	}
+	return r.Seq < o.Seq
+}
+
+// FrameSpool groups frames by CAN ID with bounded memory: frames are buffered
+// up to a limit, then sorted and spilled to a temporary file. Reading merges
+// the sorted runs.
+type FrameSpool struct {
+	limit    int
+	byHeader bool
+	buffer   []*spoolRecord
+	counts   map[string]int
+	dir      string
+	runs     []string
+}
+
+// NewFrameSpool returns a spool that keeps at most limit frames in memory.
+// With byHeader frames are grouped by ID and header byte ("ID:HDR").
+func NewFrameSpool(limit int, byHeader bool) *FrameSpool {
+	return &FrameSpool{limit: max(limit, 1), byHeader: byHeader, counts: make(map[string]int)}
+}
+
+// Add records a frame, spilling the buffer to disk when it is full
+func (s *FrameSpool) Add(f *FrameInfo) error {
+	rec := &spoolRecord{
+		Key:       frameSourceID(f.Frame),
+		Bus:       f.Frame.Bus,
+		ID:        f.Frame.ID,
+		Direction: f.Frame.Direction,
+		Timestamp: f.TimestampFloat,
+		Seq:       f.SequenceNum,
+		FrameType: f.FrameType,
+		Data:      f.Frame.Data,
+	}
+	if s.byHeader {
+		rec.Key = fmt.Sprintf("%s:%02X", rec.Key, f.Header)
+	}
+	s.counts[rec.Key]++
+	s.buffer = append(s.buffer, rec)
+	if len(s.buffer) >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// Count returns the number of frames recorded for a group key
+func (s *FrameSpool) Count(key string) int {
+	return s.counts[key]
+}
+
+// sortBuffer sorts the buffered records
+func (s *FrameSpool) sortBuffer() {
+	sort.Slice(s.buffer, func(i, j int) bool { return s.buffer[i].less(s.buffer[j]) })
+}
+
+// spill writes the buffer as a sorted run to the spool directory
+func (s *FrameSpool) spill() error {
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "canbus-spool-")
+		if err != nil {
+			return err
+		}
+		s.dir = dir
+	}
+	s.sortBuffer()
+	path := filepath.Join(s.dir, fmt.Sprintf("run%04d", len(s.runs)))
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, rec := range s.buffer {
+		writeSpoolRecord(w, rec)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	s.runs = append(s.runs, path)
+	s.buffer = s.buffer[:0]
+	return nil
+}
+
+// Each calls fn for every frame in bus, ID, time order
+func (s *FrameSpool) Each(fn func(*spoolRecord)) error {
+	if len(s.runs) == 0 {
+		s.sortBuffer()
+		for _, rec := range s.buffer {
+			fn(rec)
+		}
+		return nil
+	}
+	if len(s.buffer) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	var h spoolHeap
+	for _, path := range s.runs {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		run := &spoolRun{r: bufio.NewReader(file)}
+		if err := run.next(); err != nil {
+			return err
+		}
+		if run.rec != nil {
+			h = append(h, run)
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		run := h[0]
+		fn(run.rec)
+		if err := run.next(); err != nil {
+			return err
+		}
+		if run.rec == nil {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
+
+// Close removes the spilled runs
+func (s *FrameSpool) Close() error {
+	s.buffer = nil
+	if s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// spoolRun reads the records of one sorted run
+type spoolRun struct {
+	r   *bufio.Reader
+	rec *spoolRecord // nil at the end of the run
+}
+
+// next advances to the following record
+func (run *spoolRun) next() error {
+	rec, err := readSpoolRecord(run.r)
+	if err == io.EOF {
+		run.rec = nil
+		return nil
+	}
+	run.rec = rec
+	return err
+}
+
+// spoolHeap orders runs by their current record
+type spoolHeap []*spoolRun
+
+func (h spoolHeap) Len() int           { return len(h) }
+func (h spoolHeap) Less(i, j int) bool { return h[i].rec.less(h[j].rec) }
+func (h spoolHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *spoolHeap) Push(x any)        { *h = append(*h, x.(*spoolRun)) }
+func (h *spoolHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// writeSpoolRecord encodes a record as length-prefixed fields. Errors are
+// reported by the writer's Flush.
+func writeSpoolRecord(w *bufio.Writer, rec *spoolRecord) {
+	var buf [binary.MaxVarintLen64]byte
+	putUint := func(v uint64) {
+		w.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	putString := func(s string) {
+		putUint(uint64(len(s)))
+		w.WriteString(s)
+	}
+	putString(rec.Key)
+	putUint(uint64(rec.Bus))
+	putUint(uint64(rec.ID.Value))
+	extended := uint64(0)
+	if rec.ID.Extended {
+		extended = 1
+	}
+	putUint(extended)
+	putString(rec.Direction)
+	putUint(math.Float64bits(rec.Timestamp))
+	putUint(uint64(rec.Seq))
+	putString(rec.FrameType)
+	putString(string(rec.Data))
+}
+
+// readSpoolRecord decodes a record written by writeSpoolRecord
+func readSpoolRecord(r *bufio.Reader) (*spoolRecord, error) {
+	var err error
+	getUint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(r)
+		return v
+	}
+	getString := func() string {
+		n := getUint()
+		if err != nil {
+			return ""
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b)
+	}
+
+	rec := &spoolRecord{Key: getString()}
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	rec.Bus = int(getUint())
+	rec.ID.Value = uint32(getUint())
+	rec.ID.Extended = getUint() == 1
+	rec.Direction = getString()
+	rec.Timestamp = math.Float64frombits(getUint())
+	rec.Seq = int(getUint())
+	rec.FrameType = getString()
+	rec.Data = []byte(getString())
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("spool: %v", err)
+	}
+	return rec, nil
+}
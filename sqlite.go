@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// A minimal SQLite database writer: rowid tables only, written once in rowid
+// order, with leaf pages streamed to disk as they fill up and interior pages
+// built when a table is closed. No indexes, no free pages and no journal.
+// That is all the database export needs and keeps the tool free of cgo.
+
+// sqlitePageSize is the page size of written databases
+const sqlitePageSize = 4096
+
+// SQLite b-tree page types
+const (
+	sqliteTableInterior = 0x05
+	sqliteTableLeaf     = 0x0D
+)
+
+// sqliteMaxChildren is the number of children that always fit on an
+// interior page: a cell is at most a 4-byte page number, a 9-byte rowid and
+// a 2-byte cell pointer
+const sqliteMaxChildren = (sqlitePageSize-12)/15 + 1
+
+// sqliteWriter writes a database file page by page
+type sqliteWriter struct {
+	file   *os.File
+	pages  uint32 // pages allocated so far; page 1 holds the schema
+	tables []*sqliteTable
+}
+
+// sqliteTable is a table being written. Rows must be added in increasing
+// order of positive rowids.
+type sqliteTable struct {
+	w        *sqliteWriter
+	name     string
+	sql      string
+	cells    [][]byte      // cells of the current leaf page
+	size     int           // bytes the current leaf page uses
+	children []sqliteChild // finished pages of the current level
+	last     int64         // last rowid added
+	root     uint32
+}
+
+// sqliteChild is a finished b-tree page and the largest rowid below it
+type sqliteChild struct {
+	page uint32
+	max  int64
+}
+
+// newSQLiteWriter creates a database file, replacing an existing one
+func newSQLiteWriter(path string) (*sqliteWriter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteWriter{file: file, pages: 1}, nil
+}
+
+// Table starts a table with its CREATE TABLE statement
+func (w *sqliteWriter) Table(name, sql string) *sqliteTable {
+	t := &sqliteTable{w: w, name: name, sql: sql, size: 8}
+	w.tables = append(w.tables, t)
+	return t
+}
+
+// allocate returns the number of a new page
+func (w *sqliteWriter) allocate() uint32 {
+	w.pages++
+	return w.pages
+}
+
+// writePage writes a full page
+func (w *sqliteWriter) writePage(page uint32, data []byte) error {
+	_, err := w.file.WriteAt(data, int64(page-1)*sqlitePageSize)
+	return err
+}
+
+// Insert adds a row. values are nil, int64, float64, string or []byte; the
+// rowid is also the value of an INTEGER PRIMARY KEY column, which must be
+// given as nil.
+func (t *sqliteTable) Insert(rowid int64, values ...any) error {
+	if rowid <= t.last {
+		return fmt.Errorf("sqlite: %s: rowid %d not increasing", t.name, rowid)
+	}
+	record, err := sqliteRecord(values)
+	if err != nil {
+		return fmt.Errorf("sqlite: %s: %v", t.name, err)
+	}
+	cell, err := t.w.leafCell(rowid, record)
+	if err != nil {
+		return err
+	}
+	if t.size+len(cell)+2 > sqlitePageSize {
+		if err := t.flushLeaf(); err != nil {
+			return err
+		}
+	}
+	t.cells = append(t.cells, cell)
+	t.size += len(cell) + 2
+	t.last = rowid
+	return nil
+}
+
+// flushLeaf writes the current leaf page
+func (t *sqliteTable) flushLeaf() error {
+	page := t.w.allocate()
+	if err := t.w.writePage(page, sqliteBTreePage(sqliteTableLeaf, t.cells, 0, 0)); err != nil {
+		return err
+	}
+	t.children = append(t.children, sqliteChild{page: page, max: t.last})
+	t.cells, t.size = nil, 8
+	return nil
+}
+
+// close writes the remaining leaf and the interior pages above the leaves
+func (t *sqliteTable) close() error {
+	if len(t.cells) > 0 || len(t.children) == 0 {
+		if err := t.flushLeaf(); err != nil {
+			return err
+		}
+	}
+	// Each interior page points to up to sqliteMaxChildren pages of the level
+	// below, spread evenly so that no page is left with a single child
+	level := t.children
+	for len(level) > 1 {
+		groups := (len(level) + sqliteMaxChildren - 1) / sqliteMaxChildren
+		var next []sqliteChild
+		for g := 0; g < groups; g++ {
+			group := level[g*len(level)/groups : (g+1)*len(level)/groups]
+			var cells [][]byte
+			for _, child := range group[:len(group)-1] {
+				cell := binary.BigEndian.AppendUint32(nil, child.page)
+				cells = append(cells, appendSQLiteVarint(cell, uint64(child.max)))
+			}
+			last := group[len(group)-1]
+			page := t.w.allocate()
+			if err := t.w.writePage(page, sqliteBTreePage(sqliteTableInterior, cells, last.page, 0)); err != nil {
+				return err
+			}
+			next = append(next, sqliteChild{page: page, max: last.max})
+		}
+		level = next
+	}
+	t.root = level[0].page
+	return nil
+}
+
+// leafCell builds a table leaf cell, moving the end of a large record to
+// overflow pages
+func (w *sqliteWriter) leafCell(rowid int64, record []byte) ([]byte, error) {
+	cell := appendSQLiteVarint(nil, uint64(len(record)))
+	cell = appendSQLiteVarint(cell, uint64(rowid))
+
+	const usable = sqlitePageSize
+	maxLocal := usable - 35
+	if len(record) <= maxLocal {
+		return append(cell, record...), nil
+	}
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (len(record)-minLocal)%(usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	cell = append(cell, record[:local]...)
+
+	// Overflow pages are chained by a 4-byte next page number
+	rest := record[local:]
+	first := w.allocate()
+	cell = binary.BigEndian.AppendUint32(cell, first)
+	for page := first; len(rest) > 0; {
+		n := min(len(rest), usable-4)
+		var next uint32
+		if n < len(rest) {
+			next = w.allocate()
+		}
+		data := make([]byte, sqlitePageSize)
+		binary.BigEndian.PutUint32(data, next)
+		copy(data[4:], rest[:n])
+		if err := w.writePage(page, data); err != nil {
+			return nil, err
+		}
+		rest, page = rest[n:], next
+	}
+	return cell, nil
+}
+
+// sqliteBTreePage lays out a b-tree page: the header at offset (100 on page
+// 1), the cell pointer array after it and the cells at the end of the page
+func sqliteBTreePage(kind byte, cells [][]byte, rightmost uint32, offset int) []byte {
+	data := make([]byte, sqlitePageSize)
+	header := 8
+	if kind == sqliteTableInterior {
+		header = 12
+		binary.BigEndian.PutUint32(data[offset+8:], rightmost)
+	}
+	data[offset] = kind
+	binary.BigEndian.PutUint16(data[offset+3:], uint16(len(cells)))
+	content := sqlitePageSize
+	for i, cell := range cells {
+		content -= len(cell)
+		copy(data[content:], cell)
+		binary.BigEndian.PutUint16(data[offset+header+2*i:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(data[offset+5:], uint16(content))
+	return data
+}
+
+// Close finishes every table, writes the schema and the file header on page
+// 1 and closes the file
+func (w *sqliteWriter) Close() error {
+	defer w.file.Close()
+	var schema [][]byte
+	for i, t := range w.tables {
+		if err := t.close(); err != nil {
+			return err
+		}
+		record, err := sqliteRecord([]any{"table", t.name, t.name, int64(t.root), t.sql})
+		if err != nil {
+			return err
+		}
+		cell := appendSQLiteVarint(nil, uint64(len(record)))
+		cell = appendSQLiteVarint(cell, uint64(i+1))
+		schema = append(schema, append(cell, record...))
+	}
+	size := 100 + 8
+	for _, cell := range schema {
+		size += len(cell) + 2
+	}
+	if size > sqlitePageSize {
+		return fmt.Errorf("sqlite: schema does not fit on the first page")
+	}
+
+	page := sqliteBTreePage(sqliteTableLeaf, schema, 0, 100)
+	copy(page, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(page[16:], sqlitePageSize)
+	page[18], page[19] = 1, 1                      // legacy file format versions
+	page[21], page[22], page[23] = 64, 32, 32      // payload fractions
+	binary.BigEndian.PutUint32(page[24:], 1)       // file change counter
+	binary.BigEndian.PutUint32(page[28:], w.pages) // database size in pages
+	binary.BigEndian.PutUint32(page[40:], 1)       // schema cookie
+	binary.BigEndian.PutUint32(page[44:], 4)       // schema format
+	binary.BigEndian.PutUint32(page[56:], 1)       // UTF-8
+	binary.BigEndian.PutUint32(page[92:], 1)       // version-valid-for
+	binary.BigEndian.PutUint32(page[96:], 3045000) // SQLite version number
+	if err := w.writePage(1, page); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// sqliteRecord encodes values in the SQLite record format
+func sqliteRecord(values []any) ([]byte, error) {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = appendSQLiteVarint(types, 0)
+		case int64:
+			switch {
+			case v == 0:
+				types = appendSQLiteVarint(types, 8)
+			case v == 1:
+				types = appendSQLiteVarint(types, 9)
+			case v >= math.MinInt8 && v <= math.MaxInt8:
+				types = appendSQLiteVarint(types, 1)
+				body = append(body, byte(v))
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				types = appendSQLiteVarint(types, 2)
+				body = binary.BigEndian.AppendUint16(body, uint16(v))
+			case v >= math.MinInt32 && v <= math.MaxInt32:
+				types = appendSQLiteVarint(types, 4)
+				body = binary.BigEndian.AppendUint32(body, uint32(v))
+			default:
+				types = appendSQLiteVarint(types, 6)
+				body = binary.BigEndian.AppendUint64(body, uint64(v))
+			}
+		case float64:
+			types = appendSQLiteVarint(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			types = appendSQLiteVarint(types, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case []byte:
+			types = appendSQLiteVarint(types, uint64(2*len(v)+12))
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value %T", v)
+		}
+	}
+	// The header size counts itself, which may take a second byte
+	headerSize := len(types) + 1
+	if headerSize > 127 {
+		headerSize++
+	}
+	record := appendSQLiteVarint(nil, uint64(headerSize))
+	record = append(record, types...)
+	return append(record, body...), nil
+}
+
+// appendSQLiteVarint appends a big-endian SQLite varint: 7 bits per byte with
+// the high bit set on all but the last, and a full 8-bit ninth byte
+func appendSQLiteVarint(buf []byte, v uint64) []byte {
+	if v > 0x00FFFFFFFFFFFFFF {
+		var b [9]byte
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7F) | 0x80
+			v >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+	var b [9]byte
+	n := 0
+	for {
+		b[8-n] = byte(v & 0x7F)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := 9 - n; i < 8; i++ {
+		b[i] |= 0x80
+	}
+	return append(buf, b[9-n:]...)
+}
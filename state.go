@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+)
+
+// rateWindow is the time span in seconds used for per-ID frame rates
+const rateWindow = 1.0
+
+// maxRecentMessages bounds the decoded message history kept by CaptureState
+const maxRecentMessages = 200
+
+// IDStats tracks the activity of a single CAN ID as frames stream in
+type IDStats struct {
+	ID          string
+	Count       int
+	First       float64
+	Last        float64
+	LastType    string
+	LastFrame   *CANFrame
+	LastData    []byte
+	PrevData    []byte
+	LastMessage *Message
+	recent      []float64 // timestamps within the rate window
+}
+
+// Rate returns frames per second over the last rate window ending at now
+func (s *IDStats) Rate(now float64) float64 {
+	n := 0
+	for _, ts := range s.recent {
+		if ts > now-rateWindow && ts <= now {
+			n++
+		}
+	}
+	return float64(n) / rateWindow
+}
+
+// ChangedBytes reports which bytes of the last payload differ from the previous one
+func (s *IDStats) ChangedBytes() []bool {
+	changed := make([]bool, len(s.LastData))
+	if s.PrevData == nil {
+		return changed
+	}
+	for i := range s.LastData {
+		changed[i] = i >= len(s.PrevData) || s.LastData[i] != s.PrevData[i]
+	}
+	return changed
+}
+
+// CaptureState is the incremental view of a capture built by the decoder
+// engine: per-ID statistics, counts and recently decoded messages. It is
+// what interactive front ends render.
+type CaptureState struct {
+	IDs          map[string]*IDStats // by bus-qualified CAN ID
+	Messages     []*Message
+	Frames       int
+	Matched      int
+	CBORMessages int
+	Heartbeats   int
+	Buses        BusStatsTable
+	Start        float64
+	Now          float64
+
+	filter  *Filter
+	reasm   Reassembler
+	started bool
+}
+
+// NewCaptureState returns an empty state; frames not matching the filter are
+// still reassembled but not counted in the per-ID statistics
+func NewCaptureState(filter *Filter) *CaptureState {
+	return &CaptureState{
+		IDs:    make(map[string]*IDStats),
+		Buses:  make(BusStatsTable),
+		filter: filter,
+	}
+}
+
+// Add feeds one classified frame into the state and returns the message it
+// completed if the frame matches the filter
+func (s *CaptureState) Add(info *FrameInfo) *Message {
+	if !s.started {
+		s.Start = info.TimestampFloat
+		s.started = true
+	}
+	s.Now = info.TimestampFloat
+	s.Frames++
+
+	msg := s.reasm.Add(info)
+	if msg != nil {
+		s.CBORMessages++
+	}
+	if info.IsHeartbeat {
+		s.Heartbeats++
+	}
+	s.Buses.Add(info, msg)
+
+	if !s.filter.Match(info, s.Start) {
+		return nil
+	}
+	s.Matched++
+
+	id := frameSourceID(info.Frame)
+	stats, ok := s.IDs[id]
+	if !ok {
+		stats = &IDStats{ID: id, First: info.TimestampFloat}
+		s.IDs[id] = stats
+	}
+	stats.Count++
+	stats.Last = info.TimestampFloat
+	stats.LastType = info.FrameType
+	stats.LastFrame = info.Frame
+	stats.PrevData = stats.LastData
+	stats.LastData = info.Frame.Data
+
+	// Keep only timestamps inside the rate window
+	stats.recent = append(stats.recent, info.TimestampFloat)
+	cut := 0
+	for cut < len(stats.recent) && stats.recent[cut] <= info.TimestampFloat-rateWindow {
+		cut++
+	}
+	stats.recent = stats.recent[cut:]
+
+	if msg != nil {
+		stats.LastMessage = msg
+		s.Messages = append(s.Messages, msg)
+		if len(s.Messages) > maxRecentMessages {
+			s.Messages = s.Messages[len(s.Messages)-maxRecentMessages:]
+		}
+	}
+	return msg
+}
+
+// SortedIDs returns the tracked CAN IDs in sorted order
+func (s *CaptureState) SortedIDs() []string {
+	ids := make([]string, 0, len(s.IDs))
+	for id := range s.IDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
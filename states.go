@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Bus states recognized from frame activity
+const (
+	busStateUnknown  = "unknown"  // before the first frame
+	busStateSilent   = "silent"   // no frames for the silence time
+	busStateWaking   = "waking"   // traffic after silence or sleep, for the wake time
+	busStateNormal   = "normal"   // regular traffic
+	busStateSleeping = "sleeping" // only heartbeats for the sleep time
+)
+
+// StateRules configure the bus state thresholds and the sequences to
+// recognize
+type StateRules struct {
+	Silence   float64 // seconds without frames before the bus is silent
+	Wake      float64 // seconds of traffic before a waking bus is normal
+	Sleep     float64 // seconds of heartbeats only before the bus is sleeping
+	Sequences []*SequenceRule
+}
+
+// SequenceRule is a named series of frames, each matching a filter
+// expression, that completes within a time limit and may set a state
+type SequenceRule struct {
+	Name   string
+	Within float64 // seconds from the first to the last step, 0 for no limit
+	State  string  // state entered when complete, "" to keep the state
+	Steps  []*Filter
+}
+
+// StateEvent is a bus state transition or a completed sequence
+type StateEvent struct {
+	Timestamp float64
+	Bus       int
+	From, To  string        // bus states, or sequence states ("" if unset)
+	Sequence  *SequenceRule // nil for bus state transitions
+	Start     float64       // timestamp of the first step of a sequence
+}
+
+// defaultStateRules returns the thresholds used without a rules file
+func defaultStateRules() *StateRules {
+	return &StateRules{Silence: 1.0, Wake: 0.5, Sleep: 2.0}
+}
+
+// readStateRules reads a rules file. Lines set a threshold in seconds
+// ("silence 1.0", "wake 0.5", "sleep 2") or define a sequence whose steps are
+// filter expressions separated by ';':
+//
+//	sequence unlock within 2 -> unlocked: id==18209820 && msg.1==4; id==14609460
+//
+// Blank lines and lines starting with # are ignored.
+func readStateRules(path string) (*StateRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := defaultStateRules()
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch keyword := strings.ToLower(fields[0]); keyword {
+		case "silence", "wake", "sleep":
+			var seconds float64
+			if len(fields) == 2 {
+				seconds, err = strconv.ParseFloat(fields[1], 64)
+			}
+			if len(fields) != 2 || err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("%s:%d: expected '%s SECONDS' with a positive number", path, lineNum, keyword)
+			}
+			switch keyword {
+			case "silence":
+				rules.Silence = seconds
+			case "wake":
+				rules.Wake = seconds
+			case "sleep":
+				rules.Sleep = seconds
+			}
+		case "sequence":
+			seq, err := parseSequenceRule(strings.TrimSpace(line[len(fields[0]):]))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+			}
+			rules.Sequences = append(rules.Sequences, seq)
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown rule %q (use silence, wake, sleep or sequence)", path, lineNum, fields[0])
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseSequenceRule parses "NAME [within SECONDS] [-> STATE]: STEP; STEP..."
+func parseSequenceRule(text string) (*SequenceRule, error) {
+	head, body, ok := strings.Cut(text, ":")
+	if !ok {
+		return nil, fmt.Errorf("sequence: expected 'NAME [within SECONDS] [-> STATE]: STEP; STEP...'")
+	}
+	head, state, _ := strings.Cut(head, "->")
+	words := strings.Fields(head)
+	if len(words) != 1 && !(len(words) == 3 && strings.EqualFold(words[1], "within")) {
+		return nil, fmt.Errorf("sequence: expected 'NAME [within SECONDS]', got %q", strings.TrimSpace(head))
+	}
+	seq := &SequenceRule{Name: words[0], State: strings.TrimSpace(state)}
+	if len(words) == 3 {
+		within, err := strconv.ParseFloat(words[2], 64)
+		if err != nil || within <= 0 {
+			return nil, fmt.Errorf("sequence %s: invalid time limit %q", seq.Name, words[2])
+		}
+		seq.Within = within
+	}
+	if strings.ContainsAny(seq.State, " \t") {
+		return nil, fmt.Errorf("sequence %s: state names cannot contain spaces", seq.Name)
+	}
+	for _, step := range strings.Split(body, ";") {
+		if strings.TrimSpace(step) == "" {
+			continue
+		}
+		f, err := ParseFilter(step)
+		if err != nil {
+			return nil, fmt.Errorf("sequence %s: %v", seq.Name, err)
+		}
+		seq.Steps = append(seq.Steps, f)
+	}
+	if len(seq.Steps) == 0 {
+		return nil, fmt.Errorf("sequence %s: no steps", seq.Name)
+	}
+	return seq, nil
+}
+
+// busActivity is the state of one bus in a StateTracker
+type busActivity struct {
+	state      string
+	last       float64 // last frame
+	lastActive float64 // last frame that was not a heartbeat
+	wakeStart  float64
+	quietStart float64 // first frame after the latest silence
+}
+
+// StateTracker follows the bus states and sequences of a frame stream
+type StateTracker struct {
+	rules   *StateRules
+	buses   map[int]*busActivity
+	state   string
+	started bool
+	start   float64
+	// partial[i][k] is the start time of the latest partial match of
+	// sequence i that has matched k steps, if ok[i][k]
+	partial [][]float64
+	ok      [][]bool
+	reasm   Reassembler // decodes messages for msg.* steps
+}
+
+// NewStateTracker creates a tracker for the given rules
+func NewStateTracker(rules *StateRules) *StateTracker {
+	t := &StateTracker{rules: rules, buses: make(map[int]*busActivity)}
+	for _, seq := range rules.Sequences {
+		t.partial = append(t.partial, make([]float64, len(seq.Steps)))
+		t.ok = append(t.ok, make([]bool, len(seq.Steps)))
+	}
+	return t
+}
+
+// Observe advances the tracker by one frame, in capture order, annotates the
+// frame with the bus and sequence state and returns the events it caused.
+// Bus transitions detected from a gap are dated when the threshold passed.
+func (t *StateTracker) Observe(info *FrameInfo) []*StateEvent {
+	ts := info.TimestampFloat
+	if !t.started {
+		t.start, t.started = ts, true
+	}
+	bus := info.Frame.Bus
+	b, ok := t.buses[bus]
+	if !ok {
+		b = &busActivity{state: busStateUnknown, last: ts, lastActive: ts}
+		t.buses[bus] = b
+	}
+
+	var events []*StateEvent
+	move := func(at float64, to string) {
+		if b.state != to {
+			events = append(events, &StateEvent{Timestamp: at, Bus: bus, From: b.state, To: to})
+			b.state = to
+		}
+	}
+
+	active := !info.IsHeartbeat
+	if b.state != busStateUnknown && ts-b.last >= t.rules.Silence {
+		move(b.last+t.rules.Silence, busStateSilent)
+		b.quietStart = ts
+	}
+	switch b.state {
+	case busStateUnknown:
+		if active {
+			move(ts, busStateNormal)
+		}
+	case busStateSilent, busStateSleeping:
+		if active {
+			b.wakeStart = ts
+			move(ts, busStateWaking)
+		} else if b.state == busStateSilent && ts-b.quietStart >= t.rules.Sleep {
+			// Heartbeats alone since the silence ended
+			move(b.quietStart+t.rules.Sleep, busStateSleeping)
+		}
+	case busStateWaking:
+		if active && ts-b.wakeStart >= t.rules.Wake {
+			move(ts, busStateNormal)
+		}
+	}
+	if !active && b.state != busStateSleeping && b.state != busStateSilent && ts-b.lastActive >= t.rules.Sleep {
+		move(b.lastActive+t.rules.Sleep, busStateSleeping)
+	}
+	b.last = ts
+	if active {
+		b.lastActive = ts
+	}
+
+	info.BusState = b.state
+	info.State = t.state
+	events = append(events, t.matchSequences(info)...)
+	info.State = t.state
+	return events
+}
+
+// matchSequences advances the partial matches of every sequence. A frame
+// advances each partial match by at most one step.
+func (t *StateTracker) matchSequences(info *FrameInfo) []*StateEvent {
+	if len(t.rules.Sequences) == 0 {
+		return nil
+	}
+	// Match against a copy so decoding for msg.* steps leaves the frame alone
+	probe := *info
+	if probe.IsCBOR && probe.Message == nil {
+		t.reasm.Add(&probe)
+	}
+
+	ts := info.TimestampFloat
+	var events []*StateEvent
+	for i, seq := range t.rules.Sequences {
+		partial, ok := t.partial[i], t.ok[i]
+		completed := false
+		for k := len(seq.Steps) - 1; k >= 0; k-- {
+			start := ts
+			if k > 0 {
+				if !ok[k] {
+					continue
+				}
+				start = partial[k]
+				if seq.Within > 0 && ts-start > seq.Within {
+					ok[k] = false
+					continue
+				}
+			}
+			if !seq.Steps[k].Match(&probe, t.start) {
+				continue
+			}
+			if k == len(seq.Steps)-1 {
+				completed = true
+				events = append(events, &StateEvent{Timestamp: ts, Bus: info.Frame.Bus, From: t.state,
+					To: seq.State, Sequence: seq, Start: start})
+				if seq.State != "" {
+					t.state = seq.State
+				}
+				break
+			}
+			partial[k+1], ok[k+1] = start, true
+		}
+		if completed {
+			for k := range ok {
+				ok[k] = false
+			}
+		}
+	}
+	return events
+}
+
+// formatStateEvent formats an event for the frame stream and the timeline
+func formatStateEvent(ev *StateEvent) string {
+	if ev.Sequence == nil {
+		return fmt.Sprintf("🔄 [%.6f] %s: %s → %s", ev.Timestamp, busName(ev.Bus), ev.From, ev.To)
+	}
+	steps := fmt.Sprintf("%d steps", len(ev.Sequence.Steps))
+	if len(ev.Sequence.Steps) == 1 {
+		steps = "1 step"
+	}
+	line := fmt.Sprintf("🔗 [%.6f] sequence %s (%s in %s)", ev.Timestamp, ev.Sequence.Name,
+		steps, formatDuration(ev.Timestamp-ev.Start))
+	if ev.Sequence.State != "" {
+		from := ev.From
+		if from == "" {
+			from = "-"
+		}
+		line += fmt.Sprintf(", state %s → %s", from, ev.To)
+	}
+	return line
+}
+
+// displayStateTimeline prints the events of a capture and the time each bus
+// spent in each state. withEvents is false when they were already printed in
+// the frame stream.
+func displayStateTimeline(events []*StateEvent, end float64, withEvents bool) {
+	fmt.Println("\n===================================================")
+	fmt.Println("🔄 STATE TIMELINE")
+	fmt.Println("===================================================")
+
+	sequences := 0
+	for _, ev := range events {
+		if ev.Sequence != nil {
+			sequences++
+		}
+	}
+	if withEvents {
+		if len(events) == 0 {
+			fmt.Println("No state changes")
+		}
+		for _, ev := range events {
+			fmt.Println(formatStateEvent(ev))
+		}
+	}
+
+	// Time per state, from each transition to the next or the capture end
+	type span struct {
+		state string
+		since float64
+	}
+	current := make(map[int]*span)
+	spent := make(map[int]map[string]float64)
+	var buses []int
+	for _, ev := range events {
+		if ev.Sequence != nil {
+			continue
+		}
+		if _, ok := spent[ev.Bus]; !ok {
+			spent[ev.Bus] = make(map[string]float64)
+			buses = append(buses, ev.Bus)
+		}
+		if s := current[ev.Bus]; s != nil {
+			spent[ev.Bus][s.state] += ev.Timestamp - s.since
+		}
+		current[ev.Bus] = &span{ev.To, ev.Timestamp}
+	}
+	sort.Ints(buses)
+
+	fmt.Println("\n⏱️  Time per bus state:")
+	fmt.Println(strings.Repeat("-", 60))
+	for _, bus := range buses {
+		if s := current[bus]; s != nil && end > s.since {
+			spent[bus][s.state] += end - s.since
+		}
+		var parts []string
+		for _, state := range []string{busStateWaking, busStateNormal, busStateSleeping, busStateSilent} {
+			if d, ok := spent[bus][state]; ok {
+				parts = append(parts, fmt.Sprintf("%s %s", state, formatDuration(d)))
+			}
+		}
+		fmt.Printf("  %s: %s\n", busName(bus), strings.Join(parts, ", "))
+	}
+
+	fmt.Println("\n===================================================")
+	fmt.Printf("📈 Summary:\n")
+	fmt.Printf("   Bus state changes: %d\n", len(events)-sequences)
+	fmt.Printf("   Sequences recognized: %d\n", sequences)
+	fmt.Println("===================================================")
+}
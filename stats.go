@@ -0,0 +1,580 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Thresholds for statistical capture comparison
+const (
+	statsMinRateRatio = 2.0 // per-ID rate ratio between files reported as a difference
+	statsMinDistance  = 0.5 // total variation distance between byte value distributions
+)
+
+// ByteMask lists payload byte positions to ignore per bus-qualified CAN ID
+// (see busQualifiedID), e.g. known rolling counters. "ID@*" applies to an ID
+// on every bus and "*" to every ID.
+type ByteMask map[string]map[int]bool
+
+// ParseByteMask parses a mask such as "14609460:6,7 123@1:1 7E8@*:2 *:0".
+// An ID without a bus is on bus 0. Byte positions count from 0, the header
+// byte.
+func ParseByteMask(spec string) (ByteMask, error) {
+	mask := make(ByteMask)
+	for _, entry := range strings.Fields(strings.ReplaceAll(spec, ";", " ")) {
+		id, positions, ok := strings.Cut(entry, ":")
+		if !ok || positions == "" {
+			return nil, fmt.Errorf("mask: expected ID[@bus]:byte[,byte...], got %q", entry)
+		}
+		key, err := maskKey(id)
+		if err != nil {
+			return nil, fmt.Errorf("mask: %v", err)
+		}
+		for _, p := range strings.Split(positions, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || n < 0 || n > 63 {
+				return nil, fmt.Errorf("mask: invalid byte position %q", p)
+			}
+			mask.Add(key, n)
+		}
+	}
+	return mask, nil
+}
+
+// maskKey returns the ByteMask key of an ID given as ID, ID@bus, ID@* or *
+func maskKey(text string) (string, error) {
+	if text == "*" {
+		return text, nil
+	}
+	idText, busText, qualified := strings.Cut(text, "@")
+	id, err := ParseCANID(idText)
+	if err != nil {
+		return "", err
+	}
+	if busText == "*" {
+		return idKey(id) + "@*", nil
+	}
+	bus := 0
+	if qualified {
+		if bus, err = parseBus(busText); err != nil {
+			return "", err
+		}
+	}
+	return busQualifiedID(id, bus), nil
+}
+
+// canonicalID normalizes a user-given ID so that "0x123" and "123" match.
+// As in candump, "00000123" is the extended ID and stays distinct.
+func canonicalID(id string) string {
+	if id == "*" {
+		return id
+	}
+	parsed, err := ParseCANID(id)
+	if err != nil {
+		return strings.ToUpper(id)
+	}
+	return idKey(parsed)
+}
+
+// idKey returns the key of an ID used by masks and selectors given on the
+// command line, which keeps standard and extended IDs apart
+func idKey(id CANID) string {
+	return id.String()
+}
+
+// Add masks one byte position of a mask key
+func (m ByteMask) Add(key string, pos int) {
+	if m[key] == nil {
+		m[key] = make(map[int]bool)
+	}
+	m[key][pos] = true
+}
+
+// Merge adds every masked position of o
+func (m ByteMask) Merge(o ByteMask) {
+	for id, positions := range o {
+		for pos := range positions {
+			m.Add(id, pos)
+		}
+	}
+}
+
+// Masked reports whether a byte position of an ID on a bus is masked
+func (m ByteMask) Masked(id CANID, bus int, pos int) bool {
+	return m[busQualifiedID(id, bus)][pos] || m[idKey(id)+"@*"][pos] || m["*"][pos]
+}
+
+// Format returns the payload as hex with masked bytes shown as XX
+func (m ByteMask) Format(id CANID, bus int, data []byte) string {
+	var sb strings.Builder
+	for i, b := range data {
+		if m.Masked(id, bus, i) {
+			sb.WriteString("XX")
+		} else {
+			fmt.Fprintf(&sb, "%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// String lists the masked positions per ID
+func (m ByteMask) String() string {
+	var ids []string
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var parts []string
+	for _, id := range ids {
+		var positions []int
+		for p := range m[id] {
+			positions = append(positions, p)
+		}
+		sort.Ints(positions)
+		strs := make([]string, len(positions))
+		for i, p := range positions {
+			strs[i] = strconv.Itoa(p)
+		}
+		parts = append(parts, id+":"+strings.Join(strs, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// CounterDetector finds rolling counters in the unaccounted frames of a
+// capture as it streams, using the byte and nibble counter tests of signal
+// discovery. It keeps per-byte step histograms per ID and header instead of
+// the frames, so memory does not grow with the capture. Unlike -discover it
+// does not first rule out checksum bytes, which change with the counter
+// anyway.
+type CounterDetector struct {
+	groups map[counterGroupKey]*counterGroup
+}
+
+// counterGroupKey identifies the frames of one CAN ID and header byte
+type counterGroupKey struct {
+	bus    int
+	id     CANID
+	header byte
+}
+
+// counterGroup follows the payload bytes of one CAN ID and header byte
+type counterGroup struct {
+	frames  int
+	columns []*counterColumn // by byte position
+}
+
+// counterColumn follows one payload byte: its distinct values, maximum and
+// the steps between consecutive values, for the whole byte modulo 256 and
+// for each nibble modulo 16
+type counterColumn struct {
+	values      int
+	prev        byte
+	max         byte
+	distinct    [4]uint64
+	steps       [256]int32
+	nibbleMax   [2]byte
+	nibbleSeen  [2]uint16
+	nibbleSteps [2][16]int32
+}
+
+// NewCounterDetector returns an empty detector
+func NewCounterDetector() *CounterDetector {
+	return &CounterDetector{groups: make(map[counterGroupKey]*counterGroup)}
+}
+
+// Add feeds one frame; CBOR and heartbeat frames are ignored
+func (d *CounterDetector) Add(f *FrameInfo) {
+	if f.IsCBOR || f.IsHeartbeat {
+		return
+	}
+	key := counterGroupKey{bus: f.Frame.Bus, id: f.Frame.ID, header: f.Header}
+	g, ok := d.groups[key]
+	if !ok {
+		g = &counterGroup{}
+		d.groups[key] = g
+	}
+	g.frames++
+	data := f.Frame.Data
+	for len(g.columns) < len(data) {
+		g.columns = append(g.columns, &counterColumn{})
+	}
+	// Byte 0 is the header and the group key
+	for i := 1; i < len(data); i++ {
+		g.columns[i].add(data[i])
+	}
+}
+
+// add records the next value of the byte
+func (c *counterColumn) add(v byte) {
+	if c.values > 0 {
+		c.steps[v-c.prev]++
+		for n, shift := range []int{0, 4} {
+			c.nibbleSteps[n][(v>>shift-c.prev>>shift)&0x0F]++
+		}
+	}
+	c.values++
+	c.prev = v
+	c.max = max(c.max, v)
+	c.distinct[v/64] |= 1 << (v % 64)
+	for n, shift := range []int{0, 4} {
+		nibble := v >> shift & 0x0F
+		c.nibbleMax[n] = max(c.nibbleMax[n], nibble)
+		c.nibbleSeen[n] |= 1 << nibble
+	}
+}
+
+// isCounter applies the test of detectCounter to a field with the given
+// number of values, distinct values, maximum and step histogram
+func isCounter(values, distinct int, maxValue byte, steps []int32, fieldBits int) bool {
+	if values <= counterMinTransitions || distinct < 3 {
+		return false
+	}
+	width := bits.Len8(maxValue)
+	if width == 0 || width > fieldBits {
+		return false
+	}
+	// Steps modulo a smaller power of two fold onto the wider histogram
+	modulus := 1 << width
+	folded := make([]int, modulus)
+	for step, count := range steps {
+		folded[step%modulus] += int(count)
+	}
+	best := 0
+	for step := 1; step < modulus; step++ {
+		best = max(best, folded[step])
+	}
+	return float64(best)/float64(values-1) >= counterMinRatio
+}
+
+// Mask adds the bytes with a byte or nibble counter to the mask
+func (d *CounterDetector) Mask(mask ByteMask) {
+	for key, g := range d.groups {
+		if g.frames < discoveryMinFrames {
+			continue
+		}
+		for i, c := range g.columns {
+			if c.values == 0 {
+				continue
+			}
+			distinct := 0
+			for _, word := range c.distinct {
+				distinct += bits.OnesCount64(word)
+			}
+			counter := isCounter(c.values, distinct, c.max, c.steps[:], 8)
+			for n := range c.nibbleSteps {
+				nibbleCounter := isCounter(c.values, bits.OnesCount16(c.nibbleSeen[n]), c.nibbleMax[n], c.nibbleSteps[n][:], 4)
+				counter = counter || nibbleCounter
+			}
+			if counter {
+				mask.Add(busQualifiedID(key.id, key.bus), i)
+			}
+		}
+	}
+}
+
+// unaccountedFrames returns the frames that are neither CBOR nor heartbeat
+func unaccountedFrames(frames []*FrameInfo) []*FrameInfo {
+	var out []*FrameInfo
+	for _, f := range frames {
+		if !f.IsCBOR && !f.IsHeartbeat {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// CaptureStats accumulates the statistics of one capture that the
+// statistical comparison needs, one frame at a time
+type CaptureStats struct {
+	Start, End  float64
+	Unaccounted int
+	IDCounts    map[string]int        // unaccounted frames per bus-qualified ID
+	Groups      map[string]*ByteGroup // unaccounted frames per "ID:HDR"
+	seen        bool
+}
+
+// ByteGroup holds the byte statistics of one CAN ID and header byte
+type ByteGroup struct {
+	ID     CANID
+	Bus    int
+	Source string // bus-qualified ID
+	Header byte
+	Bytes  ByteStatsAccumulator
+}
+
+// NewCaptureStats returns empty capture statistics
+func NewCaptureStats() *CaptureStats {
+	return &CaptureStats{IDCounts: make(map[string]int), Groups: make(map[string]*ByteGroup)}
+}
+
+// Add records one frame of the capture
+func (c *CaptureStats) Add(f *FrameInfo) {
+	if !c.seen || f.TimestampFloat < c.Start {
+		c.Start = f.TimestampFloat
+	}
+	if !c.seen || f.TimestampFloat > c.End {
+		c.End = f.TimestampFloat
+	}
+	c.seen = true
+	if f.IsCBOR || f.IsHeartbeat {
+		return
+	}
+	c.Unaccounted++
+	source := frameSourceID(f.Frame)
+	c.IDCounts[source]++
+	key := fmt.Sprintf("%s:%02X", source, f.Header)
+	g, ok := c.Groups[key]
+	if !ok {
+		g = &ByteGroup{ID: f.Frame.ID, Bus: f.Frame.Bus, Source: source, Header: f.Header}
+		c.Groups[key] = g
+	}
+	g.Bytes.Add(f.Frame.Data)
+}
+
+// CompareCaptureStatistics compares unaccounted frames across files by CAN ID
+// rate and by the value distribution of every byte position per ID and header
+func CompareCaptureStatistics(filenames []string, captures []*CaptureStats, mask ByteMask) {
+	fmt.Println("\n===================================================")
+	fmt.Println("📊 STATISTICAL COMPARISON (unaccounted frames)")
+	fmt.Println("===================================================")
+
+	durations := make([]float64, len(filenames))
+	idCounts := make([]map[string]int, len(filenames))
+	allIDs := make(map[string]bool)
+	allGroups := make(map[string]bool)
+
+	fmt.Println("Files analyzed:")
+	for i, fn := range filenames {
+		c := captures[i]
+		durations[i] = c.End - c.Start
+		idCounts[i] = c.IDCounts
+		for id := range c.IDCounts {
+			allIDs[id] = true
+		}
+		for key := range c.Groups {
+			allGroups[key] = true
+		}
+		fmt.Printf("  [%d] %s (%d unaccounted frames, %d IDs, %.3f s)\n",
+			i+1, fn, c.Unaccounted, len(idCounts[i]), durations[i])
+	}
+	if len(mask) > 0 {
+		fmt.Printf("Masked bytes: %s\n", mask)
+	}
+
+	// rate returns frames per second, or the plain count for captures
+	// without a usable time span
+	rate := func(i int, count int) float64 {
+		if durations[i] <= 0 {
+			return float64(count)
+		}
+		return float64(count) / durations[i]
+	}
+
+	// --- ID presence and rates ---
+	var appearing, rateChanges []string
+	for _, id := range sortedKeys(allIDs) {
+		present := 0
+		lo, hi := math.Inf(1), 0.0
+		for i := range filenames {
+			if idCounts[i][id] > 0 {
+				present++
+			}
+			r := rate(i, idCounts[i][id])
+			lo, hi = math.Min(lo, r), math.Max(hi, r)
+		}
+		switch {
+		case present < len(filenames):
+			appearing = append(appearing, id)
+		case hi >= statsMinRateRatio*lo:
+			rateChanges = append(rateChanges, id)
+		}
+	}
+
+	printIDRates := func(id string) {
+		cells := make([]string, len(filenames))
+		for i := range filenames {
+			if idCounts[i][id] == 0 {
+				cells[i] = fmt.Sprintf("[%d] -", i+1)
+			} else {
+				cells[i] = fmt.Sprintf("[%d] %d (%.1f/s)", i+1, idCounts[i][id], rate(i, idCounts[i][id]))
+			}
+		}
+		fmt.Printf("  ID:0x%-10s %s\n", id, strings.Join(cells, "  "))
+	}
+
+	fmt.Printf("\n🆕 IDs present in only some files (%d):\n", len(appearing))
+	fmt.Println(strings.Repeat("-", 70))
+	for _, id := range appearing {
+		printIDRates(id)
+	}
+
+	fmt.Printf("\n⏱️  IDs with rate differences of %.0fx or more (%d):\n", statsMinRateRatio, len(rateChanges))
+	fmt.Println(strings.Repeat("-", 70))
+	for _, id := range rateChanges {
+		printIDRates(id)
+	}
+
+	// --- Per-byte behavior per ID and header ---
+	fmt.Println("\n🔢 Byte positions that behave differently:")
+	fmt.Println(strings.Repeat("-", 70))
+	differing := 0
+	for _, key := range sortedKeys(allGroups) {
+		stats := make([][]*ByteStats, len(filenames))
+		present := 0
+		var id CANID
+		var bus int
+		var source string
+		var header byte
+		width := 0
+		for i := range filenames {
+			group := captures[i].Groups[key]
+			if group == nil {
+				continue
+			}
+			present++
+			id, bus, header, source = group.ID, group.Bus, group.Header, group.Source
+			stats[i] = group.Bytes.Stats
+			width = max(width, len(stats[i]))
+		}
+		// Groups missing from some files are already covered by the ID presence
+		if present < 2 {
+			continue
+		}
+
+		var lines []string
+		for pos := 1; pos < width; pos++ {
+			if mask.Masked(id, bus, pos) {
+				continue
+			}
+			if reason := compareBytePosition(stats, pos); reason != "" {
+				lines = append(lines, fmt.Sprintf("    byte %d: %s", pos, reason))
+				for i, s := range stats {
+					if s != nil && pos < len(s) {
+						lines = append(lines, fmt.Sprintf("      [%d] %s", i+1, describeByteStats(s[pos])))
+					}
+				}
+			}
+		}
+		if len(lines) > 0 {
+			differing++
+			fmt.Printf("  ID:0x%s Hdr:%02X\n", source, header)
+			for _, l := range lines {
+				fmt.Println(l)
+			}
+		}
+	}
+	if differing == 0 {
+		fmt.Println("  None")
+	}
+
+	fmt.Println("\n===================================================")
+	fmt.Printf("📈 Summary:\n")
+	fmt.Printf("   IDs seen: %d\n", len(allIDs))
+	fmt.Printf("   IDs present in only some files: %d\n", len(appearing))
+	fmt.Printf("   IDs with rate differences: %d\n", len(rateChanges))
+	fmt.Printf("   ID/header groups with differing bytes: %d\n", differing)
+	fmt.Println("===================================================")
+}
+
+// compareBytePosition explains how one byte position differs between files,
+// or returns "" if it behaves alike
+func compareBytePosition(stats [][]*ByteStats, pos int) string {
+	var present []*ByteStats
+	constant, varying := 0, 0
+	for _, s := range stats {
+		if s == nil || pos >= len(s) {
+			continue
+		}
+		present = append(present, s[pos])
+		if s[pos].Distinct() == 1 {
+			constant++
+		} else {
+			varying++
+		}
+	}
+	if len(present) < 2 {
+		return ""
+	}
+
+	switch {
+	case constant > 0 && varying > 0:
+		return "constant in some files, varying in others"
+	case varying == 0:
+		for _, s := range present[1:] {
+			if s.Min != present[0].Min {
+				return "different constant values"
+			}
+		}
+		return ""
+	}
+
+	// Both vary: compare the value distributions
+	maxDist := 0.0
+	for i := 0; i < len(present); i++ {
+		for j := i + 1; j < len(present); j++ {
+			maxDist = math.Max(maxDist, valueDistance(present[i], present[j]))
+		}
+	}
+	if maxDist >= statsMinDistance {
+		return fmt.Sprintf("value distributions differ (distance %.2f)", maxDist)
+	}
+	return ""
+}
+
+// valueDistance is the total variation distance between the value
+// distributions of two byte positions: 0 for identical, 1 for disjoint
+func valueDistance(a, b *ByteStats) float64 {
+	totalA, totalB := 0, 0
+	for _, n := range a.Values {
+		totalA += n
+	}
+	for _, n := range b.Values {
+		totalB += n
+	}
+	dist := 0.0
+	for v := 0; v < 256; v++ {
+		pa := float64(a.Values[byte(v)]) / float64(totalA)
+		pb := float64(b.Values[byte(v)]) / float64(totalB)
+		dist += math.Abs(pa - pb)
+	}
+	return dist / 2
+}
+
+// describeByteStats summarizes one byte position: its constant value, or its
+// range with the most common values
+func describeByteStats(s *ByteStats) string {
+	if s.Distinct() == 1 {
+		return fmt.Sprintf("always %02X", s.Min)
+	}
+	values := make([]int, 0, len(s.Values))
+	for v := range s.Values {
+		values = append(values, int(v))
+	}
+	sort.Slice(values, func(i, j int) bool {
+		ci, cj := s.Values[byte(values[i])], s.Values[byte(values[j])]
+		if ci != cj {
+			return ci > cj
+		}
+		return values[i] < values[j]
+	})
+	var top []string
+	for _, v := range values[:min(len(values), 4)] {
+		top = append(top, fmt.Sprintf("%02X×%d", v, s.Values[byte(v)]))
+	}
+	return fmt.Sprintf("%02X..%02X, %d distinct, %d changes, top %s",
+		s.Min, s.Max, s.Distinct(), s.Changes, strings.Join(top, " "))
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
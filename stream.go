@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// frameGate reassembles CBOR messages while a capture is streamed and holds
+// back the frames of a message until it is complete, so that decoded-field
+// filters see the same frames as when the whole capture is loaded
+type frameGate struct {
+	reasm Reassembler
+	held  map[reassemblyKey][]*FrameInfo
+}
+
+// Add feeds one frame and passes every frame that can no longer change to
+// release, in capture order per bus and CAN ID. It returns the message the
+// frame completes, if any.
+func (g *frameGate) Add(f *FrameInfo, release func(*FrameInfo)) *Message {
+	if !f.IsCBOR {
+		release(f)
+		return nil
+	}
+	if g.held == nil {
+		g.held = make(map[reassemblyKey][]*FrameInfo)
+	}
+	key := reassemblyKey{bus: f.Frame.Bus, id: f.Frame.ID}
+	if f.FrameType == "START" {
+		// The previous message of this stream can no longer complete
+		g.release(key, release)
+	}
+	g.held[key] = append(g.held[key], f)
+
+	msg := g.reasm.Add(f)
+	if msg != nil {
+		// Bytes left over belong to the next message, as does their frame
+		var keep []*FrameInfo
+		if len(g.reasm.Buffer()) > 0 {
+			keep = []*FrameInfo{f}
+			g.held[key] = g.held[key][:len(g.held[key])-1]
+		}
+		g.release(key, release)
+		g.held[key] = keep
+	}
+	return msg
+}
+
+// release passes the held frames of one stream on
+func (g *frameGate) release(key reassemblyKey, release func(*FrameInfo)) {
+	for _, f := range g.held[key] {
+		release(f)
+	}
+	delete(g.held, key)
+}
+
+// Flush passes on the frames of messages left incomplete at the end of the capture
+func (g *frameGate) Flush(release func(*FrameInfo)) {
+	var frames []*FrameInfo
+	for _, held := range g.held {
+		frames = append(frames, held...)
+	}
+	sortFramesByTime(frames)
+	for _, f := range frames {
+		release(f)
+	}
+	g.held = nil
+}
+
+// filteredStream applies a filter to streamed frames. The relative time field
+// counts from the first frame of the capture.
+type filteredStream struct {
+	filter  *Filter
+	start   float64
+	started bool
+}
+
+// Match reports whether a released frame passes the filter
+func (s *filteredStream) Match(f *FrameInfo) bool {
+	return s.filter.Match(f, s.start)
+}
+
+// Observe records the timestamp of a frame read from the capture
+func (s *filteredStream) Observe(f *FrameInfo) {
+	if !s.started {
+		s.start, s.started = f.TimestampFloat, true
+	}
+}
+
+// streamFile reads a capture file frame by frame and passes every frame
+// that matches the filter to fn, with decoded messages attached. Only the
+// frames of incomplete messages are kept in memory.
+func streamFile(path string, filter *Filter, progress bool, fn func(*FrameInfo)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if progress {
+		pr := newProgressReader(file, path)
+		defer pr.Done()
+		r = pr
+	}
+
+	reader := NewFrameReader(r)
+	var gate frameGate
+	stream := filteredStream{filter: filter}
+	release := func(f *FrameInfo) {
+		if stream.Match(f) {
+			fn(f)
+		}
+	}
+	for {
+		info, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		stream.Observe(info)
+		gate.Add(info, release)
+	}
+	gate.Flush(release)
+	if n := reader.SkippedLines(); n > 0 {
+		fmt.Fprintf(os.Stderr, "⚠️  %s: skipped %d lines longer than %d bytes\n", path, n, maxLineLength)
+	}
+	return nil
+}
+
+// progressInterval is the minimum time between progress updates
+const progressInterval = time.Second
+
+// progressReader reports how much of an input has been read on stderr, with
+// the percentage and remaining time when the input size is known
+type progressReader struct {
+	r       io.Reader
+	name    string
+	total   int64 // 0 if unknown
+	read    int64
+	start   time.Time
+	last    time.Time
+	printed bool
+}
+
+// newProgressReader wraps r. The size is taken from r if it is a regular file.
+func newProgressReader(r io.Reader, name string) *progressReader {
+	pr := &progressReader{r: r, name: name, start: time.Now()}
+	if f, ok := r.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			pr.total = st.Size()
+		}
+	}
+	pr.last = pr.start
+	return pr
+}
+
+// Read reads from the wrapped reader and updates the progress line
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.read += int64(n)
+	if now := time.Now(); now.Sub(pr.last) >= progressInterval {
+		pr.last = now
+		pr.print(now)
+	}
+	return n, err
+}
+
+// print writes the progress line, overwriting the previous one
+func (pr *progressReader) print(now time.Time) {
+	elapsed := now.Sub(pr.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(pr.read) / elapsed
+	}
+	line := fmt.Sprintf("⏳ %s: %s", pr.name, formatBytes(pr.read))
+	if pr.total > 0 {
+		line += fmt.Sprintf(" / %s (%.1f%%)", formatBytes(pr.total), 100*float64(pr.read)/float64(pr.total))
+	}
+	line += fmt.Sprintf(", %s/s", formatBytes(int64(rate)))
+	if pr.total > 0 && rate > 0 && pr.read < pr.total {
+		eta := time.Duration(float64(pr.total-pr.read) / rate * float64(time.Second))
+		line += fmt.Sprintf(", %s left", eta.Round(time.Second))
+	}
+	fmt.Fprintf(os.Stderr, "\r%s%s", line, strings.Repeat(" ", 8))
+	pr.printed = true
+}
+
+// Done prints the final state and ends the progress line
+func (pr *progressReader) Done() {
+	if !pr.printed {
+		return
+	}
+	pr.print(time.Now())
+	fmt.Fprintln(os.Stderr)
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
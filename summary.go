@@ -0,0 +1,75 @@
+package main
+
+import "fmt"
+
+// CaptureSummary counts what a capture contains as frames stream in: its
+// time span, frames per class, decoded messages and per-bus traffic
+type CaptureSummary struct {
+	Start, End  float64
+	Timestamped bool // at least one frame had a timestamp
+	Frames      int
+	Classes     map[string]int // frames per START, CONT, HEARTBEAT, UNACCOUNTED
+	Messages    int
+	Heartbeats  int
+	Buses       BusStatsTable
+}
+
+// NewCaptureSummary returns an empty summary
+func NewCaptureSummary() *CaptureSummary {
+	return &CaptureSummary{Classes: make(map[string]int), Buses: make(BusStatsTable)}
+}
+
+// Observe counts a frame read from the capture and tracks the time span
+func (s *CaptureSummary) Observe(info *FrameInfo) {
+	s.Frames++
+	s.Classes[info.FrameType]++
+	if info.Frame.Timestamp == "" {
+		return
+	}
+	if !s.Timestamped || info.TimestampFloat < s.Start {
+		s.Start = info.TimestampFloat
+	}
+	if !s.Timestamped || info.TimestampFloat > s.End {
+		s.End = info.TimestampFloat
+	}
+	s.Timestamped = true
+}
+
+// Add counts a processed frame and, if not nil, the message it completed
+func (s *CaptureSummary) Add(info *FrameInfo, msg *Message) {
+	if msg != nil {
+		s.Messages++
+	}
+	if info.IsHeartbeat {
+		s.Heartbeats++
+	}
+	s.Buses.Add(info, msg)
+}
+
+// Duration returns the time span of the capture in seconds
+func (s *CaptureSummary) Duration() float64 {
+	return s.End - s.Start
+}
+
+// Unaccounted returns the frames not explained by messages or heartbeats
+func (s *CaptureSummary) Unaccounted() int {
+	return max(s.Frames-s.Messages-s.Heartbeats, 0)
+}
+
+// display prints the capture summary; nothing for captures without a time span
+func (s *CaptureSummary) display() {
+	if !s.Timestamped || s.End <= s.Start {
+		return
+	}
+	durationSeconds := s.Duration()
+	fmt.Println("\n===================================================")
+	fmt.Printf("📊 Capture Summary\n")
+	fmt.Printf("   Duration: %s (%.3f sec)\n", formatDuration(durationSeconds), durationSeconds)
+	fmt.Printf("   From: %.6f to %.6f seconds\n", s.Start, s.End)
+	fmt.Printf("   CBOR Messages Found: %d\n", s.Messages)
+	fmt.Printf("   Heartbeat/Keep-Alive Frames: %d\n", s.Heartbeats)
+	fmt.Printf("   Unaccounted Frames: %d\n", s.Unaccounted())
+	fmt.Printf("   Total Frames Processed: %d\n", s.Frames)
+	s.Buses.display()
+	fmt.Println("===================================================")
+}
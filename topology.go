@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Thresholds for request/response detection
+const (
+	responseMinRequests = 3   // events of an ID needed before pairing it
+	responseMinRatio    = 0.8 // share of requests followed by the response
+	responseMinReactive = 0.8 // share of responses that follow a request
+	responseMinLift     = 0.5 // ratio above what the response rate gives by chance
+)
+
+// nodePattern matches CAN IDs for one named node, e.g. "146094??@battery"
+type nodePattern struct {
+	Value    uint32
+	Mask     uint32 // bits that must match; ? nibbles are 0
+	Extended bool
+	Bus      int // -1 matches any bus
+}
+
+// NodeConfig assigns names to nodes by ID pattern
+type NodeConfig struct {
+	Names    []string // in file order, which is also the matching order
+	Patterns map[string][]nodePattern
+}
+
+// parseNodePattern parses a hex ID pattern where ? matches any nibble,
+// optionally followed by @bus (number or -bus-names name)
+func parseNodePattern(text string) (nodePattern, error) {
+	p := nodePattern{Bus: -1}
+	idText, busText, hasBus := strings.Cut(text, "@")
+	if hasBus {
+		bus, err := parseBus(busText)
+		if err != nil {
+			return p, err
+		}
+		p.Bus = bus
+	}
+	digits := strings.TrimPrefix(strings.TrimPrefix(idText, "0x"), "0X")
+	if digits == "" || len(digits) > 8 {
+		return p, fmt.Errorf("invalid ID pattern %q", text)
+	}
+	p.Extended = len(digits) > 3
+	for _, c := range digits {
+		p.Value <<= 4
+		p.Mask <<= 4
+		if c == '?' {
+			continue
+		}
+		n, err := strconv.ParseUint(string(c), 16, 8)
+		if err != nil {
+			return p, fmt.Errorf("invalid ID pattern %q", text)
+		}
+		p.Value |= uint32(n)
+		p.Mask |= 0xF
+	}
+	return p, nil
+}
+
+// matches reports whether an ID on a bus belongs to the pattern
+func (p nodePattern) matches(id CANID, bus int) bool {
+	return id.Extended == p.Extended && id.Value&p.Mask == p.Value &&
+		(p.Bus < 0 || p.Bus == bus)
+}
+
+// parseNodeMask parses the hex mask of the ID bits that identify a node
+func parseNodeMask(text string) (uint32, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")
+	n, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || n > canExtMaxID {
+		return 0, fmt.Errorf("invalid node mask %q", text)
+	}
+	return uint32(n), nil
+}
+
+// readNodeConfig reads a node file with one "name pattern [pattern...]" per
+// line, e.g. "motor 146094?? 18209820@battery". Blank lines and lines
+// starting with # are ignored.
+func readNodeConfig(path string) (*NodeConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg := &NodeConfig{Patterns: make(map[string][]nodePattern)}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected a node name and ID patterns", path, lineNum)
+		}
+		name := fields[0]
+		if _, ok := cfg.Patterns[name]; !ok {
+			cfg.Names = append(cfg.Names, name)
+		}
+		for _, text := range fields[1:] {
+			p, err := parseNodePattern(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+			}
+			cfg.Patterns[name] = append(cfg.Patterns[name], p)
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// nodeOf returns the node an ID belongs to: the first configured node whose
+// pattern matches, else the source field of the -id-layout, else the ID
+// with the bits outside mask shown as ? (e.g. 146094??). configured reports
+// whether the name came from the node file. Standard IDs that are not
+// configured are nodes of their own.
+func (cfg *NodeConfig) nodeOf(id CANID, bus int, mask uint32) (name string, configured bool) {
+	if cfg != nil {
+		for _, n := range cfg.Names {
+			for _, p := range cfg.Patterns[n] {
+				if p.matches(id, bus) {
+					return n, true
+				}
+			}
+		}
+	}
+	suffix := ""
+	if _, named := busNames[bus]; bus != 0 || named {
+		suffix = "@" + busName(bus)
+	}
+	for _, field := range []string{"source", "src", "node"} {
+		if v, ok := idLayout.Field(id, field); ok {
+			return fmt.Sprintf("%s=%02X%s", field, v, suffix), false
+		}
+	}
+	if !id.Extended {
+		// Standard IDs rarely encode their sender, so each is its own node
+		return id.String() + suffix, false
+	}
+	text := []byte(id.String())
+	for i := range text {
+		shift := 4 * (len(text) - 1 - i)
+		bits := uint32(canExtMaxID) >> shift & 0xF
+		if mask>>shift&bits != bits {
+			text[i] = '?'
+		}
+	}
+	return string(text) + suffix, false
+}
+
+// IDActivity counts the traffic of one bus-qualified CAN ID
+type IDActivity struct {
+	Source   string
+	ID       CANID
+	Bus      int
+	Frames   int
+	Messages int
+	events   []float64 // frame bursts, see idEvents
+}
+
+// Node is an ECU with the IDs it sends
+type Node struct {
+	Name       string
+	Configured bool
+	IDs        []*IDActivity
+	Frames     int
+	Messages   int
+}
+
+// ResponsePair is an ID that reliably follows another within the window
+type ResponsePair struct {
+	Request, Response *IDActivity
+	Requests          int       // request events
+	Answered          int       // requests followed by a response
+	Latencies         []float64 // seconds from request to the first response
+}
+
+// Topology is the inferred set of nodes and their request/response links
+type Topology struct {
+	Nodes    []*Node
+	NodeOf   map[string]*Node // by bus-qualified CAN ID
+	Pairs    []*ResponsePair
+	Duration float64
+	Window   float64
+}
+
+// idEvents collapses the time-sorted frames of one ID into events: a frame
+// starts a new event unless it follows the previous frame of the ID within
+// a tenth of the response window, so that a multi-frame message counts once
+func idEvents(times []float64, window float64) []float64 {
+	var events []float64
+	for i, t := range times {
+		if i == 0 || t-times[i-1] > window/10 {
+			events = append(events, t)
+		}
+	}
+	return events
+}
+
+// buildTopology groups the IDs of a capture into nodes and detects
+// request/response pairs on the same bus
+func buildTopology(frames []*FrameInfo, cfg *NodeConfig, mask uint32, window float64) *Topology {
+	topo := &Topology{
+		NodeOf:   make(map[string]*Node),
+		Duration: captureEnd(frames) - captureStart(frames),
+		Window:   window,
+	}
+
+	keys, grouped := groupFramesByID(frames, false)
+	activities := make([]*IDActivity, 0, len(keys))
+	byName := make(map[string]*Node)
+	for _, key := range keys {
+		group := grouped[key]
+		a := &IDActivity{Source: key, ID: group[0].Frame.ID, Bus: group[0].Frame.Bus, Frames: len(group)}
+		times := make([]float64, len(group))
+		for i, f := range group {
+			times[i] = f.TimestampFloat
+		}
+		a.events = idEvents(times, window)
+		activities = append(activities, a)
+
+		name, configured := cfg.nodeOf(a.ID, a.Bus, mask)
+		node, ok := byName[name]
+		if !ok {
+			node = &Node{Name: name, Configured: configured}
+			byName[name] = node
+			topo.Nodes = append(topo.Nodes, node)
+		}
+		node.IDs = append(node.IDs, a)
+		node.Frames += a.Frames
+		topo.NodeOf[key] = node
+	}
+
+	byKey := make(map[string]*IDActivity, len(activities))
+	for _, a := range activities {
+		byKey[a.Source] = a
+	}
+	for _, msg := range captureMessages(frames) {
+		if a := byKey[msg.SourceID()]; a != nil {
+			a.Messages++
+			topo.NodeOf[a.Source].Messages++
+		}
+	}
+
+	// Configured nodes first in file order, then inferred ones by traffic
+	order := make(map[string]int)
+	if cfg != nil {
+		for i, name := range cfg.Names {
+			order[name] = i
+		}
+	}
+	sort.SliceStable(topo.Nodes, func(i, j int) bool {
+		a, b := topo.Nodes[i], topo.Nodes[j]
+		if a.Configured != b.Configured {
+			return a.Configured
+		}
+		if a.Configured {
+			return order[a.Name] < order[b.Name]
+		}
+		return a.Frames > b.Frames
+	})
+
+	for _, req := range activities {
+		if len(req.events) < responseMinRequests {
+			continue
+		}
+		for _, resp := range activities {
+			if resp == req || resp.Bus != req.Bus || len(resp.events) == 0 {
+				continue
+			}
+			if pair := matchResponses(req, resp, window, topo.Duration); pair != nil {
+				topo.Pairs = append(topo.Pairs, pair)
+			}
+		}
+	}
+	sort.SliceStable(topo.Pairs, func(i, j int) bool {
+		return topo.Pairs[i].Answered > topo.Pairs[j].Answered
+	})
+	return topo
+}
+
+// matchResponses checks whether resp reliably follows req within the window,
+// more often than its own event rate explains, and rarely occurs otherwise
+func matchResponses(req, resp *IDActivity, window, duration float64) *ResponsePair {
+	pair := &ResponsePair{Request: req, Response: resp, Requests: len(req.events)}
+	for _, t := range req.events {
+		// First response event strictly after the request
+		i := sort.SearchFloat64s(resp.events, t)
+		for i < len(resp.events) && resp.events[i] <= t {
+			i++
+		}
+		if i < len(resp.events) && resp.events[i]-t <= window {
+			pair.Answered++
+			pair.Latencies = append(pair.Latencies, resp.events[i]-t)
+		}
+	}
+	ratio := float64(pair.Answered) / float64(pair.Requests)
+	if ratio < responseMinRatio {
+		return nil
+	}
+	if duration > 0 {
+		chance := min(1, float64(len(resp.events))*window/duration)
+		if ratio-chance < responseMinLift {
+			return nil
+		}
+	}
+
+	// Most responses must follow a request
+	reactive := 0
+	for _, t := range resp.events {
+		i := sort.SearchFloat64s(req.events, t)
+		if i > 0 && t-req.events[i-1] <= window && t > req.events[i-1] {
+			reactive++
+		}
+	}
+	if float64(reactive)/float64(len(resp.events)) < responseMinReactive {
+		return nil
+	}
+	return pair
+}
+
+// medianLatency returns the median request/response latency in seconds
+func (p *ResponsePair) medianLatency() float64 {
+	l := append([]float64(nil), p.Latencies...)
+	sort.Float64s(l)
+	if len(l) == 0 {
+		return 0
+	}
+	return l[len(l)/2]
+}
+
+// displayTopology prints nodes with their IDs and the request/response links
+func displayTopology(topo *Topology) {
+	fmt.Println("\n===================================================")
+	fmt.Println("🕸️  BUS TOPOLOGY")
+	fmt.Println("===================================================")
+
+	rate := func(n int) string {
+		if topo.Duration <= 0 {
+			return ""
+		}
+		return fmt.Sprintf(", %.1f/s", float64(n)/topo.Duration)
+	}
+
+	fmt.Printf("Nodes (%d):\n", len(topo.Nodes))
+	for _, node := range topo.Nodes {
+		origin := "inferred"
+		if node.Configured {
+			origin = "configured"
+		}
+		fmt.Printf("\n📦 %s (%s): %d IDs, %d frames%s, %d CBOR messages\n",
+			node.Name, origin, len(node.IDs), node.Frames, rate(node.Frames), node.Messages)
+		fmt.Println(strings.Repeat("-", 60))
+		for _, a := range node.IDs {
+			fmt.Printf("  sends 0x%-20s %6d frames%s, %d messages\n", a.Source, a.Frames, rate(a.Frames), a.Messages)
+		}
+	}
+
+	fmt.Printf("\n🔁 Request/response pairs (response within %s):\n", formatDuration(topo.Window))
+	fmt.Println(strings.Repeat("-", 60))
+	if len(topo.Pairs) == 0 {
+		fmt.Println("  None")
+	}
+	links := make(map[[2]string]int)
+	var linkOrder [][2]string
+	for _, p := range topo.Pairs {
+		from, to := topo.NodeOf[p.Request.Source].Name, topo.NodeOf[p.Response.Source].Name
+		fmt.Printf("  0x%s (%s) → 0x%s (%s): %d/%d answered, median %s\n",
+			p.Request.Source, from, p.Response.Source, to, p.Answered, p.Requests, formatDuration(p.medianLatency()))
+		if from == to {
+			continue
+		}
+		link := [2]string{from, to}
+		if links[link] == 0 {
+			linkOrder = append(linkOrder, link)
+		}
+		links[link]++
+	}
+
+	if len(linkOrder) > 0 {
+		fmt.Println("\n🔗 Node links:")
+		fmt.Println(strings.Repeat("-", 60))
+		for _, link := range linkOrder {
+			fmt.Printf("  %s → %s (%d ID pairs)\n", link[0], link[1], links[link])
+		}
+	}
+	fmt.Println("\n===================================================")
+}
+
+// writeTopologyDOT writes the topology as a Graphviz graph: nodes with the
+// IDs they send, and an edge per request/response pair
+func writeTopologyDOT(w io.Writer, topo *Topology) error {
+	var sb strings.Builder
+	sb.WriteString("digraph canbus {\n  rankdir=LR;\n  node [shape=box, fontname=\"monospace\"];\n")
+	for _, node := range topo.Nodes {
+		ids := make([]string, len(node.IDs))
+		for i, a := range node.IDs {
+			ids[i] = "0x" + a.Source
+		}
+		fmt.Fprintf(&sb, "  %q [label=%q];\n", node.Name, fmt.Sprintf("%s\n%d frames\n%s", node.Name, node.Frames, strings.Join(ids, "\n")))
+	}
+	for _, p := range topo.Pairs {
+		from, to := topo.NodeOf[p.Request.Source].Name, topo.NodeOf[p.Response.Source].Name
+		fmt.Fprintf(&sb, "  %q -> %q [label=%q];\n", from, to,
+			fmt.Sprintf("0x%s → 0x%s\n%d/%d, %s", p.Request.Source, p.Response.Source, p.Answered, p.Requests, formatDuration(p.medianLatency())))
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeTopologyFile writes the Graphviz topology to path
+func writeTopologyFile(path string, topo *Topology) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeTopologyDOT(file, topo); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Thresholds for transfer reconstruction
+const (
+	transferKeyMinShare   = 0.8 // share of chunk pairs an offset or index key must explain
+	transferProgressSteps = 4   // progress lines per session, at equal shares of the bytes
+	transferMaxListed     = 10  // gaps listed per session
+	transferMaxImage      = 64 << 20
+)
+
+// Kinds of transfer sessions
+const (
+	transferMessages = "CBOR chunks"      // decoded messages carrying byte strings
+	transferChain    = "START/CONT chain" // long chains that do not decode as CBOR
+	transferRaw      = "raw frames"       // frames of -transfer-ids without framing
+)
+
+// transferUnit is one piece of a transfer: a decoded message's byte string,
+// an undecoded START/CONT chain or a raw frame
+type transferUnit struct {
+	Kind   string
+	Start  float64 // timestamp of the first frame
+	Time   float64 // timestamp of the last frame
+	Data   []byte  // missing frames of a chain are filled with zeros
+	Gaps   []TransferGap
+	Frames int
+	Msg    *Message
+}
+
+// TransferGap is a range of the image that was not received
+type TransferGap struct {
+	Offset, Length int
+}
+
+// transferProgress is the amount of data received by a point in time
+type transferProgress struct {
+	Time  float64
+	Bytes int
+}
+
+// TransferSession is a reconstructed bulk transfer on one bus and CAN ID
+type TransferSession struct {
+	Source      string
+	Kind        string
+	Start, End  float64
+	Units       int
+	Frames      int
+	Received    int // bytes received, including retransmissions
+	Data        []byte
+	Gaps        []TransferGap
+	OffsetKey   string // top-level key giving each chunk's offset or index
+	OffsetIndex bool   // OffsetKey counts chunks rather than bytes
+	BaseOffset  uint64 // offset of the first chunk
+	SizeKey     string // top-level key announcing the image size
+	Announced   int
+	Retransmits int
+	Progress    []transferProgress
+	Checks      []string
+}
+
+// transferUnits splits the frames of one bus and CAN ID into transfer units.
+// Frames that are neither CBOR framed nor on a forced ID are skipped.
+func transferUnits(group []*FrameInfo, forced bool) []*transferUnit {
+	var units []*transferUnit
+	seen := make(map[*Message]bool)
+	var chain *transferUnit
+	var nextSeq byte
+	flush := func() {
+		if chain != nil {
+			units = append(units, chain)
+			chain = nil
+		}
+	}
+
+	for _, f := range group {
+		switch {
+		case f.Message != nil:
+			flush()
+			if seen[f.Message] {
+				continue
+			}
+			seen[f.Message] = true
+			if chunk := largestByteString(f.Message.Item); len(chunk) > 0 {
+				units = append(units, &transferUnit{Kind: transferMessages, Start: f.TimestampFloat,
+					Time: f.Message.Timestamp, Data: chunk, Frames: len(f.Message.Frames), Msg: f.Message})
+			}
+		case f.IsCBOR && len(f.Frame.Data) > 0:
+			payload := f.Frame.Data[1:]
+			if f.FrameType == "START" || chain == nil {
+				flush()
+				chain = &transferUnit{Kind: transferChain, Start: f.TimestampFloat}
+				nextSeq = 1
+			}
+			if f.FrameType == "CONT" {
+				// CONT headers count 1, 2, ... F, 0, 1 in their low nibble
+				missing := int((f.Header&0x0F - nextSeq) & 0x0F)
+				if missing > 0 {
+					chain.Gaps = append(chain.Gaps, TransferGap{Offset: len(chain.Data), Length: missing * maxFramePayload})
+					chain.Data = append(chain.Data, make([]byte, missing*maxFramePayload)...)
+				}
+				nextSeq = (f.Header + 1) & 0x0F
+			}
+			chain.Data = append(chain.Data, payload...)
+			chain.Frames++
+			chain.Time = f.TimestampFloat
+		case forced:
+			flush()
+			units = append(units, &transferUnit{Kind: transferRaw, Start: f.TimestampFloat,
+				Time: f.TimestampFloat, Data: f.Frame.Data, Frames: 1})
+		}
+	}
+	flush()
+	return units
+}
+
+// largestByteString returns the longest byte string in a decoded item
+func largestByteString(item interface{}) []byte {
+	var best []byte
+	walkFields(item, "", func(_ string, v interface{}) {
+		if b, ok := v.([]byte); ok && len(b) > len(best) {
+			best = b
+		}
+	})
+	return best
+}
+
+// findTransfers groups the units of every bus and CAN ID into sessions of
+// one kind separated by at most maxGap seconds and keeps those with at least
+// minBytes, or all of them on the forced IDs
+func findTransfers(frames []*FrameInfo, forced map[CANID]bool, minBytes int, maxGap float64) []*TransferSession {
+	keys, grouped := groupFramesByID(frames, false)
+	var sessions []*TransferSession
+	for _, key := range keys {
+		group := grouped[key]
+		isForced := forced[group[0].Frame.ID]
+		units := transferUnits(group, isForced)
+
+		var current []*transferUnit
+		finish := func() {
+			size := 0
+			for _, u := range current {
+				size += len(u.Data)
+			}
+			if len(current) > 0 && (size >= minBytes || isForced) {
+				sessions = append(sessions, assembleTransfer(key, current))
+			}
+			current = nil
+		}
+		for _, u := range units {
+			if len(current) > 0 {
+				last := current[len(current)-1]
+				if u.Kind != last.Kind || u.Time-last.Time > maxGap {
+					finish()
+				}
+			}
+			current = append(current, u)
+		}
+		finish()
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Start < sessions[j].Start })
+	return sessions
+}
+
+// assembleTransfer places the units of a session into one image, by offset
+// or index field where the messages have one and in capture order otherwise
+func assembleTransfer(source string, units []*transferUnit) *TransferSession {
+	s := &TransferSession{
+		Source: source,
+		Kind:   units[0].Kind,
+		Start:  units[0].Start,
+		End:    units[len(units)-1].Time,
+		Units:  len(units),
+	}
+
+	var positions []int
+	if s.Kind == transferMessages {
+		positions = s.detectOffsets(units)
+	}
+	if positions == nil {
+		positions = make([]int, len(units))
+		pos := 0
+		for i, u := range units {
+			positions[i] = pos
+			pos += len(u.Data)
+		}
+	}
+
+	size := 0
+	for i, u := range units {
+		size = max(size, positions[i]+len(u.Data))
+	}
+	s.Data = make([]byte, size)
+	covered := make([]bool, size)
+	for i, u := range units {
+		pos := positions[i]
+		if len(u.Data) > 0 && covered[pos] {
+			s.Retransmits++
+		}
+		copy(s.Data[pos:], u.Data)
+		for j := range u.Data {
+			covered[pos+j] = true
+		}
+		// Zero-filled frames of a chain are gaps, not data
+		received := len(u.Data)
+		for _, g := range u.Gaps {
+			for j := g.Offset; j < g.Offset+g.Length; j++ {
+				covered[pos+j] = false
+			}
+			received -= g.Length
+		}
+		s.Frames += u.Frames
+		s.Received += received
+		s.Progress = append(s.Progress, transferProgress{Time: u.Time, Bytes: s.Received})
+	}
+
+	if s.Kind == transferMessages {
+		s.detectAnnouncedSize(units)
+	}
+	if s.Announced > size {
+		covered = append(covered, make([]bool, s.Announced-size)...)
+	}
+	for i := 0; i < len(covered); {
+		if covered[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(covered) && !covered[i] {
+			i++
+		}
+		s.Gaps = append(s.Gaps, TransferGap{Offset: start, Length: i - start})
+	}
+
+	s.Checks = s.verifyChecksums(units)
+	return s
+}
+
+// messageUints returns the unsigned integer top-level fields of a message
+func messageUints(msg *Message) map[string]uint64 {
+	values := make(map[string]uint64)
+	if m, ok := msg.Item.(map[interface{}]interface{}); ok {
+		for k, v := range m {
+			if n, ok := v.(uint64); ok {
+				values[formatFieldKey(k)] = n
+			}
+		}
+	}
+	return values
+}
+
+// detectOffsets looks for a top-level key that mostly increases by the chunk
+// length (a byte offset) or by one (a chunk index) from message to message,
+// and returns the position of each chunk, or nil if there is none. Other
+// steps are lost or retransmitted chunks.
+func (s *TransferSession) detectOffsets(units []*transferUnit) []int {
+	if len(units) < 2 {
+		return nil
+	}
+	fields := make([]map[string]uint64, len(units))
+	for i, u := range units {
+		fields[i] = messageUints(u.Msg)
+	}
+
+	var candidates []string
+	for key := range fields[0] {
+		candidates = append(candidates, key)
+	}
+	sortFieldKeys(candidates)
+
+	pairs := float64(len(units) - 1)
+	for _, index := range []bool{false, true} {
+		for _, key := range candidates {
+			matches, ok := 0, true
+			for i := 1; i < len(units) && ok; i++ {
+				prev, has1 := fields[i-1][key]
+				cur, has2 := fields[i][key]
+				if !has1 || !has2 {
+					ok = false
+					break
+				}
+				step := uint64(len(units[i-1].Data))
+				if index {
+					step = 1
+				}
+				if cur-prev == step {
+					matches++
+				}
+			}
+			if !ok || float64(matches) < transferKeyMinShare*pairs {
+				continue
+			}
+
+			s.OffsetKey, s.OffsetIndex = key, index
+			s.BaseOffset = fields[0][key]
+			for i := range units {
+				s.BaseOffset = min(s.BaseOffset, fields[i][key])
+			}
+			chunkSize := uint64(len(units[0].Data))
+			positions := make([]int, len(units))
+			for i, u := range units {
+				pos := fields[i][key] - s.BaseOffset
+				if index {
+					pos *= chunkSize
+				}
+				if pos+uint64(len(u.Data)) > transferMaxImage {
+					return nil
+				}
+				positions[i] = int(pos)
+			}
+			return positions
+		}
+	}
+	return nil
+}
+
+// detectAnnouncedSize looks for a top-level key with the same value in every
+// message that holds it, at least the image end and at most twice as large
+func (s *TransferSession) detectAnnouncedSize(units []*transferUnit) {
+	end := uint64(len(s.Data))
+	values := make(map[string]uint64)
+	consistent := make(map[string]bool)
+	for _, u := range units {
+		for key, v := range messageUints(u.Msg) {
+			if prev, ok := values[key]; ok {
+				consistent[key] = consistent[key] && prev == v
+				continue
+			}
+			values[key], consistent[key] = v, true
+		}
+	}
+	keys := sortedKeys(consistent)
+	sortFieldKeys(keys)
+	for _, key := range keys {
+		v := values[key]
+		if key == s.OffsetKey || !consistent[key] || v < end || v > 2*end {
+			continue
+		}
+		s.SizeKey, s.Announced = key, int(v)
+		return
+	}
+}
+
+// verifyChecksums compares image checksums with the integer fields of the
+// session's messages and with a trailer at the end of the image
+func (s *TransferSession) verifyChecksums(units []*transferUnit) []string {
+	var checks []string
+	if len(s.Data) == 0 {
+		return nil
+	}
+	if len(s.Gaps) > 0 {
+		checks = append(checks, fmt.Sprintf("⚠️  %d gaps, checksums cover zero-filled bytes", len(s.Gaps)))
+	}
+
+	type field struct {
+		key   string
+		value uint64
+	}
+	var fields []field
+	seen := make(map[field]bool)
+	for _, u := range units {
+		if u.Msg == nil {
+			continue
+		}
+		values := messageUints(u.Msg)
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sortFieldKeys(keys)
+		for _, k := range keys {
+			f := field{k, values[k]}
+			if k != s.OffsetKey && k != s.SizeKey && !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	found := false
+	for _, alg := range imageChecksums() {
+		sum := alg.Sum(s.Data)
+		for _, f := range fields {
+			if f.value == uint64(sum) {
+				checks = append(checks, fmt.Sprintf("✅ %s of the image matches field %s (0x%0*X)", alg.Name, f.key, 2*alg.Size, sum))
+				found = true
+			}
+		}
+		if len(s.Data) > alg.Size {
+			body, trailer := s.Data[:len(s.Data)-alg.Size], s.Data[len(s.Data)-alg.Size:]
+			sum := alg.Sum(body)
+			var le, be uint32
+			if alg.Size == 4 {
+				le, be = binary.LittleEndian.Uint32(trailer), binary.BigEndian.Uint32(trailer)
+			} else {
+				le, be = uint32(binary.LittleEndian.Uint16(trailer)), uint32(binary.BigEndian.Uint16(trailer))
+			}
+			for _, endian := range []struct {
+				name  string
+				value uint32
+			}{{"little-endian", le}, {"big-endian", be}} {
+				if endian.value == sum {
+					checks = append(checks, fmt.Sprintf("✅ %s of the image matches its last %d bytes (%s 0x%0*X)",
+						alg.Name, alg.Size, endian.name, 2*alg.Size, sum))
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		checks = append(checks, "❔ No checksum found in the transfer to verify against")
+	}
+	return checks
+}
+
+// transferFileName returns the file name of a session's image
+func transferFileName(s *TransferSession, n int) string {
+	return fmt.Sprintf("%s_%d.bin", strings.ReplaceAll(s.Source, "@", "_"), n)
+}
+
+// formatRate formats a transfer rate in bytes per second
+func formatRate(bytes int, seconds float64) string {
+	if seconds <= 0 {
+		return "-"
+	}
+	rate := float64(bytes) / seconds
+	if rate >= 1024 {
+		return fmt.Sprintf("%.1f KB/s", rate/1024)
+	}
+	return fmt.Sprintf("%.0f B/s", rate)
+}
+
+// displayTransfers prints each session and writes its image to dir, if set
+func displayTransfers(sessions []*TransferSession, dir string) error {
+	fmt.Println("\n===================================================")
+	fmt.Println("📦 BULK TRANSFERS")
+	fmt.Println("===================================================")
+	fmt.Printf("Sessions: %d\n", len(sessions))
+	if dir != "" && len(sessions) > 0 {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	totalGaps, written := 0, 0
+	for i, s := range sessions {
+		duration := s.End - s.Start
+		fmt.Printf("\n🚚 Session %d: 0x%s, %s, %.6f → %.6f (%s)\n", i+1, s.Source, s.Kind, s.Start, s.End, formatDuration(duration))
+		fmt.Println(strings.Repeat("-", 60))
+		fmt.Printf("  Received: %d bytes in %d units (%d frames), %s\n", s.Received, s.Units, s.Frames, formatRate(s.Received, duration))
+		fmt.Printf("  Image: %d bytes\n", len(s.Data))
+		if s.OffsetKey != "" {
+			what := "byte offset"
+			if s.OffsetIndex {
+				what = "chunk index"
+			}
+			fmt.Printf("  Placement: %s in field %s, first 0x%X\n", what, s.OffsetKey, s.BaseOffset)
+		} else {
+			fmt.Println("  Placement: in capture order")
+		}
+		if s.SizeKey != "" {
+			fmt.Printf("  Announced size: %d bytes (field %s)\n", s.Announced, s.SizeKey)
+		}
+		if s.Retransmits > 0 {
+			fmt.Printf("  Retransmitted chunks: %d\n", s.Retransmits)
+		}
+
+		// Progress at equal shares of the expected bytes, and at the end
+		fmt.Println("  Progress:")
+		total := max(s.Received, s.Announced)
+		step := 1
+		for j, p := range s.Progress {
+			if j < len(s.Progress)-1 && p.Bytes*transferProgressSteps < step*total {
+				continue
+			}
+			for p.Bytes*transferProgressSteps >= step*total {
+				step++
+			}
+			fmt.Printf("    +%-10s %8d bytes %5.1f%%  %s\n", formatDuration(p.Time-s.Start), p.Bytes,
+				100*float64(p.Bytes)/float64(total), formatRate(p.Bytes, p.Time-s.Start))
+		}
+
+		if len(s.Gaps) > 0 {
+			missing := 0
+			for _, g := range s.Gaps {
+				missing += g.Length
+			}
+			totalGaps += len(s.Gaps)
+			fmt.Printf("  Gaps (%d, %d bytes):\n", len(s.Gaps), missing)
+			for _, g := range s.Gaps[:min(len(s.Gaps), transferMaxListed)] {
+				fmt.Printf("    0x%08X-0x%08X (%d bytes)\n", g.Offset, g.Offset+g.Length, g.Length)
+			}
+			if len(s.Gaps) > transferMaxListed {
+				fmt.Printf("    ... and %d more\n", len(s.Gaps)-transferMaxListed)
+			}
+		} else {
+			fmt.Println("  Gaps: none")
+		}
+
+		fmt.Println("  Checks:")
+		for _, c := range s.Checks {
+			fmt.Printf("    %s\n", c)
+		}
+		fmt.Printf("  SHA-256: %x\n", sha256.Sum256(s.Data))
+
+		if dir != "" {
+			path := filepath.Join(dir, transferFileName(s, i+1))
+			if err := os.WriteFile(path, s.Data, 0o644); err != nil {
+				return err
+			}
+			written++
+			fmt.Printf("  📝 Written to %s\n", path)
+		}
+	}
+
+	fmt.Println("\n===================================================")
+	fmt.Printf("📈 Summary:\n")
+	fmt.Printf("   Transfer sessions: %d\n", len(sessions))
+	fmt.Printf("   Sessions with gaps: %d\n", countGapSessions(sessions))
+	fmt.Printf("   Gaps: %d\n", totalGaps)
+	if dir != "" {
+		fmt.Printf("   Images written: %d\n", written)
+	}
+	fmt.Println("===================================================")
+	return nil
+}
+
+// countGapSessions returns the number of sessions with missing data
+func countGapSessions(sessions []*TransferSession) int {
+	n := 0
+	for _, s := range sessions {
+		if len(s.Gaps) > 0 {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences used by the terminal UI
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiReverse   = "\x1b[7m"
+	ansiChanged   = "\x1b[1;33m"
+	ansiDim       = "\x1b[2m"
+	ansiClear     = "\x1b[H\x1b[2J"
+	ansiAltScreen = "\x1b[?1049h\x1b[?25l"
+	ansiMainScr   = "\x1b[?25h\x1b[?1049l"
+)
+
+// tuiRefresh is the redraw interval of the terminal UI
+const tuiRefresh = 100 * time.Millisecond
+
+// tuiMaxPending is the number of live frames held back while paused. Later
+// frames are dropped so that a busy bus cannot exhaust memory.
+const tuiMaxPending = 100000
+
+// explorer is the interactive capture browser. It feeds frames from a file
+// or a live interface into a CaptureState and renders it.
+type explorer struct {
+	source string
+
+	// File mode: the whole capture, how far it has been applied and the
+	// playback position in seconds relative to the first frame
+	frames   []*FrameInfo
+	pos      int
+	cursor   float64
+	duration float64
+	speed    float64
+
+	// Live mode: incoming frames, the error that ended them and frames held
+	// back or dropped while paused
+	live    <-chan *FrameInfo
+	liveErr <-chan error
+	closed  error
+	pending []*FrameInfo
+	dropped int
+
+	filter *Filter
+	state  *CaptureState
+	paused bool
+
+	// labelsPath is the label file segment annotations are appended to
+	labelsPath string
+
+	selected int
+	scroll   int
+	prompt   string // "filter", "goto" or "label" while the user is typing
+	input    string
+	status   string
+	width    int
+	height   int
+}
+
+// runTUI implements the tui subcommand
+func runTUI(args []string) {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	iface := fs.String("iface", "", "read live frames from a SocketCAN interface instead of a file")
+	speed := fs.Float64("speed", 0, "playback speed for files (0 = show the whole capture at once)")
+	filterExpr := fs.String("filter", "", "only track frames matching a filter expression")
+	labelsPath := fs.String("labels", "", "append segment labels entered with [l] to this file, for use with canbus -labels")
+	busNameSpec := fs.String("bus-names", "", "names for bus numbers, e.g. '0=main,1=battery'")
+	idLayoutSpec := fs.String("id-layout", "", "decompose extended IDs into fields: 'j1939' or 'name:shift:bits,...'")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: canbus tui [flags] capture-file")
+		fmt.Fprintln(fs.Output(), "       canbus tui -iface can0")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	names, err := parseBusNames(*busNameSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	busNames = names
+
+	idLayout, err = parseIDLayout(*idLayoutSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	filter, err := ParseFilter(*filterExpr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	ex := &explorer{filter: filter, speed: *speed, labelsPath: *labelsPath}
+
+	switch {
+	case *iface != "":
+		sock, err := openCANSocket(*iface)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		defer sock.Close()
+		live := make(chan *FrameInfo, 1024)
+		liveErr := make(chan error, 1)
+		go func() {
+			liveErr <- readLiveFrames(sock, live)
+			close(live)
+		}()
+		ex.live, ex.liveErr = live, liveErr
+		ex.source = *iface
+	case fs.NArg() > 0:
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		ex.frames, err = readAllFrames(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		if len(ex.frames) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: no frames in", fs.Arg(0))
+			os.Exit(1)
+		}
+		ex.source = filepath.Base(fs.Arg(0))
+		first := ex.frames[0].TimestampFloat
+		ex.duration = ex.frames[len(ex.frames)-1].TimestampFloat - first
+		if ex.speed == 0 {
+			ex.cursor = ex.duration
+			ex.paused = true
+		}
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	restore, err := enterRawMode()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer restore()
+
+	ex.rebuild()
+	ex.run()
+}
+
+// run is the event loop: keys, periodic playback/refresh and Ctrl-C
+func (ex *explorer) run() {
+	keys := make(chan string, 16)
+	go readKeys(keys)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	lastTick := time.Now()
+	lastSize := time.Time{}
+
+	for {
+		if time.Since(lastSize) > time.Second {
+			ex.height, ex.width = terminalSize()
+			lastSize = time.Now()
+		}
+		os.Stdout.WriteString(ex.render())
+
+		select {
+		case key := <-keys:
+			if !ex.handleKey(key) {
+				return
+			}
+		case <-interrupt:
+			return
+		case now := <-ticker.C:
+			ex.tick(now.Sub(lastTick).Seconds())
+			lastTick = now
+		}
+	}
+}
+
+// rebuild replays the capture into a fresh state up to the cursor
+func (ex *explorer) rebuild() {
+	ex.state = NewCaptureState(ex.filter)
+	ex.pos = 0
+	ex.advance()
+}
+
+// advance applies file frames up to the cursor
+func (ex *explorer) advance() {
+	if len(ex.frames) == 0 {
+		return
+	}
+	first := ex.frames[0].TimestampFloat
+	for ex.pos < len(ex.frames) && ex.frames[ex.pos].TimestampFloat-first <= ex.cursor {
+		ex.state.Add(ex.frames[ex.pos])
+		ex.pos++
+	}
+}
+
+// seek moves the file cursor to t seconds after the first frame
+func (ex *explorer) seek(t float64) {
+	if t < 0 {
+		t = 0
+	}
+	if t > ex.duration {
+		t = ex.duration
+	}
+	back := t < ex.cursor
+	ex.cursor = t
+	if back {
+		ex.rebuild()
+	} else {
+		ex.advance()
+	}
+}
+
+// tick advances playback (file) or drains received frames (live)
+func (ex *explorer) tick(elapsed float64) {
+	if ex.live == nil {
+		if !ex.paused && ex.speed > 0 {
+			ex.seek(ex.cursor + elapsed*ex.speed)
+			if ex.cursor >= ex.duration {
+				ex.paused = true
+				ex.status = "End of capture"
+			}
+		}
+		return
+	}
+
+	for {
+		select {
+		case f, ok := <-ex.live:
+			if !ok {
+				ex.live = make(chan *FrameInfo)
+				ex.closed = <-ex.liveErr
+				ex.status = "Interface closed: " + ex.closed.Error()
+				return
+			}
+			if ex.paused && len(ex.pending) >= tuiMaxPending {
+				ex.dropped++
+			} else if ex.paused {
+				ex.pending = append(ex.pending, f)
+			} else {
+				ex.state.Add(f)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// handleKey processes one key press and returns false to quit
+func (ex *explorer) handleKey(key string) bool {
+	if ex.prompt != "" {
+		switch key {
+		case "enter":
+			ex.applyPrompt()
+		case "esc":
+			ex.prompt = ""
+		case "backspace":
+			if len(ex.input) > 0 {
+				_, size := utf8.DecodeLastRuneInString(ex.input)
+				ex.input = ex.input[:len(ex.input)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				ex.input += key
+			}
+		}
+		return true
+	}
+
+	ids := ex.state.SortedIDs()
+	switch key {
+	case "q", "ctrl-c":
+		return false
+	case " ", "p":
+		ex.paused = !ex.paused
+		if !ex.paused {
+			for _, f := range ex.pending {
+				ex.state.Add(f)
+			}
+			ex.pending = nil
+			if ex.dropped > 0 {
+				ex.status = fmt.Sprintf("Dropped %d frames while paused", ex.dropped)
+				ex.dropped = 0
+			}
+			if ex.live == nil && ex.speed == 0 {
+				ex.speed = 1
+			}
+			if ex.live == nil && ex.cursor >= ex.duration {
+				ex.seek(0)
+			}
+		}
+	case "up", "k":
+		if ex.selected > 0 {
+			ex.selected--
+		}
+	case "down", "j":
+		if ex.selected < len(ids)-1 {
+			ex.selected++
+		}
+	case "left":
+		ex.seek(ex.cursor - 1)
+	case "right":
+		ex.seek(ex.cursor + 1)
+	case "+":
+		if ex.speed == 0 {
+			ex.speed = 1
+		} else {
+			ex.speed *= 2
+		}
+	case "-":
+		ex.speed /= 2
+	case "/":
+		ex.prompt, ex.input = "filter", ex.filter.String()
+	case "g":
+		if ex.live == nil {
+			ex.prompt, ex.input = "goto", ""
+		}
+	case "c":
+		ex.state = NewCaptureState(ex.filter)
+		ex.status = "Statistics cleared"
+	case "l":
+		if ex.labelsPath == "" {
+			ex.status = "Start with -labels FILE to label segments"
+		} else {
+			ex.prompt, ex.input = "label", ""
+		}
+	}
+	return true
+}
+
+// applyPrompt applies the filter or goto input
+func (ex *explorer) applyPrompt() {
+	prompt, input := ex.prompt, strings.TrimSpace(ex.input)
+	ex.prompt = ""
+
+	switch prompt {
+	case "filter":
+		filter, err := ParseFilter(input)
+		if err != nil {
+			ex.status = err.Error()
+			return
+		}
+		ex.filter = filter
+		ex.selected = 0
+		if ex.live == nil {
+			ex.rebuild()
+		} else {
+			ex.state = NewCaptureState(filter)
+		}
+		ex.status = "Filter applied"
+	case "goto":
+		t, err := strconv.ParseFloat(input, 64)
+		if err != nil {
+			ex.status = "Invalid time: " + input
+			return
+		}
+		ex.seek(t)
+		ex.status = fmt.Sprintf("Jumped to %.3f s", ex.cursor)
+	case "label":
+		if input == "" {
+			return
+		}
+		// Label timestamps use the capture clock: wall clock for live
+		// frames, the playback position for files
+		ts := float64(time.Now().UnixMicro()) / 1_000_000
+		if ex.live == nil {
+			ts = ex.frames[0].TimestampFloat + ex.cursor
+		}
+		if err := appendLabel(ex.labelsPath, ts, input); err != nil {
+			ex.status = err.Error()
+			return
+		}
+		if input == labelEnd {
+			ex.status = fmt.Sprintf("Segment ended at %.6f", ts)
+		} else {
+			ex.status = fmt.Sprintf("Segment %q started at %.6f", input, ts)
+		}
+	}
+}
+
+// render draws the whole screen
+func (ex *explorer) render() string {
+	width, height := ex.width, ex.height
+	if width <= 0 {
+		width = 100
+	}
+	if height <= 0 {
+		height = 30
+	}
+
+	var sb strings.Builder
+	sb.WriteString(ansiClear)
+	line := func(s string) {
+		sb.WriteString(s)
+		sb.WriteString(ansiReset + "\x1b[K\r\n")
+	}
+
+	// Status bar
+	var mode string
+	switch {
+	case ex.live != nil && ex.paused && ex.dropped > 0:
+		mode = fmt.Sprintf("⏸ PAUSED (%d queued, %d dropped)", len(ex.pending), ex.dropped)
+	case ex.live != nil && ex.paused:
+		mode = fmt.Sprintf("⏸ PAUSED (%d queued)", len(ex.pending))
+	case ex.closed != nil:
+		mode = "■ CLOSED: " + ex.closed.Error()
+	case ex.live != nil:
+		mode = "● LIVE"
+	case ex.paused:
+		mode = fmt.Sprintf("⏸ t=%.3f / %.3f s", ex.cursor, ex.duration)
+	default:
+		mode = fmt.Sprintf("▶ %.2gx t=%.3f / %.3f s", ex.speed, ex.cursor, ex.duration)
+	}
+	title := fmt.Sprintf(" VanMoof CAN Explorer — %s | %s | %d frames | %d CBOR msgs | %d heartbeats",
+		ex.source, mode, ex.state.Frames, ex.state.CBORMessages, ex.state.Heartbeats)
+	if ex.filter.Active() {
+		title += " | filter: " + ex.filter.String()
+	}
+	line(ansiReverse + truncateVisible(padVisible(title, width), width))
+
+	// Per-ID table
+	ids := ex.state.SortedIDs()
+	if ex.selected >= len(ids) {
+		ex.selected = len(ids) - 1
+	}
+	if ex.selected < 0 {
+		ex.selected = 0
+	}
+	tableRows := (height - 4) / 2
+	if tableRows < 3 {
+		tableRows = 3
+	}
+	if ex.selected < ex.scroll {
+		ex.scroll = ex.selected
+	}
+	if ex.selected >= ex.scroll+tableRows {
+		ex.scroll = ex.selected - tableRows + 1
+	}
+
+	line(ansiBold + fmt.Sprintf("  %-10s %-12s %8s %8s  %s", "CAN ID", "Last type", "Count", "Rate/s", "Last payload"))
+	now := ex.state.Now
+	for row := 0; row < tableRows; row++ {
+		i := ex.scroll + row
+		if i >= len(ids) {
+			line("")
+			continue
+		}
+		stats := ex.state.IDs[ids[i]]
+		marker := "  "
+		if i == ex.selected {
+			marker = ansiBold + "> "
+		}
+		line(fmt.Sprintf("%s%-10s %-12s %8d %8.1f  %s", marker, stats.ID, stats.LastType,
+			stats.Count, stats.Rate(now), highlightPayload(stats.LastData, stats.ChangedBytes())))
+	}
+
+	// Message pane for the selected ID
+	paneRows := height - tableRows - 4
+	var pane []string
+	if len(ids) > 0 {
+		stats := ex.state.IDs[ids[ex.selected]]
+		pane = append(pane, formatFrameHeader(stats.LastFrame,
+			firstByte(stats.LastData), stats.LastType))
+		if msg := stats.LastMessage; msg != nil {
+			pane = append(pane, fmt.Sprintf("✅ CBOR MESSAGE at %.6f (CAN ID: 0x%s, %d frames, %d bytes)",
+				msg.Timestamp, msg.SourceID(), msg.FrameCount, len(msg.Raw)))
+			pane = append(pane, fmt.Sprintf("Raw CBOR: %X", msg.Raw))
+			var buf bytes.Buffer
+			decodeAndFprint(&buf, msg.Item, 0)
+			pane = append(pane, strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")...)
+		} else {
+			pane = append(pane, ansiDim+"No decoded CBOR message for this ID")
+		}
+	}
+	line(ansiDim + strings.Repeat("─", width))
+	for row := 0; row < paneRows; row++ {
+		if row < len(pane) {
+			line(truncateVisible(pane[row], width))
+		} else {
+			line("")
+		}
+	}
+
+	// Prompt or help line
+	switch {
+	case ex.prompt != "":
+		sb.WriteString(fmt.Sprintf("%s: %s\x1b[K", ex.prompt, ex.input))
+	case ex.status != "":
+		sb.WriteString(ansiDim + truncateVisible(ex.status, width) + ansiReset + "\x1b[K")
+		ex.status = ""
+	default:
+		help := "[q]uit [space]pause [↑↓]select [/]filter [c]lear [l]abel"
+		if ex.live == nil {
+			help += " [g]oto [←→]seek ±1s [+/-]speed"
+		}
+		sb.WriteString(ansiDim + truncateVisible(help, width) + ansiReset + "\x1b[K")
+	}
+	return sb.String()
+}
+
+// highlightPayload formats bytes as hex, highlighting changed ones
+func highlightPayload(data []byte, changed []bool) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		if i < len(changed) && changed[i] {
+			parts[i] = fmt.Sprintf("%s%02X%s", ansiChanged, b, ansiReset)
+		} else {
+			parts[i] = fmt.Sprintf("%02X", b)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func firstByte(data []byte) byte {
+	if len(data) == 0 {
+		return 0
+	}
+	return data[0]
+}
+
+// truncateVisible cuts s to width visible runes, skipping ANSI sequences
+func truncateVisible(s string, width int) string {
+	visible := 0
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			end := strings.IndexByte(s[i:], 'm')
+			if end == -1 {
+				return s[:i]
+			}
+			i += end + 1
+			continue
+		}
+		if visible >= width {
+			return s[:i]
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		visible++
+	}
+	return s
+}
+
+// padVisible pads s with spaces to width runes
+func padVisible(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// readKeys decodes key presses from stdin
+func readKeys(keys chan<- string) {
+	buf := make([]byte, 32)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			keys <- "q"
+			return
+		}
+		in := buf[:n]
+		for len(in) > 0 {
+			switch {
+			case len(in) >= 3 && in[0] == 0x1b && in[1] == '[':
+				switch in[2] {
+				case 'A':
+					keys <- "up"
+				case 'B':
+					keys <- "down"
+				case 'C':
+					keys <- "right"
+				case 'D':
+					keys <- "left"
+				}
+				in = in[3:]
+			case in[0] == 0x1b:
+				keys <- "esc"
+				in = in[1:]
+			case in[0] == '\r' || in[0] == '\n':
+				keys <- "enter"
+				in = in[1:]
+			case in[0] == 127 || in[0] == 8:
+				keys <- "backspace"
+				in = in[1:]
+			case in[0] == 3:
+				keys <- "ctrl-c"
+				in = in[1:]
+			default:
+				r, size := utf8.DecodeRune(in)
+				keys <- string(r)
+				in = in[size:]
+			}
+		}
+	}
+}
+
+// enterRawMode switches the terminal to unbuffered input on the alternate
+// screen and returns a function restoring the previous settings
+func enterRawMode() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("interactive mode needs a terminal: %v", err)
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, fmt.Errorf("could not configure terminal: %v", err)
+	}
+	os.Stdout.WriteString(ansiAltScreen)
+	return func() {
+		os.Stdout.WriteString(ansiMainScr)
+		stty(strings.TrimSpace(saved))
+	}, nil
+}
+
+// terminalSize returns the terminal rows and columns, or zeros if unknown
+func terminalSize() (int, int) {
+	out, err := stty("size")
+	if err != nil {
+		return 0, 0
+	}
+	var rows, cols int
+	fmt.Sscan(out, &rows, &cols)
+	return rows, cols
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
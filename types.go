@@ -2,13 +2,12 @@ package main
 
 // CANFrame represents a parsed CAN bus frame
 type CANFrame struct {
-	Timestamp  string
-	ID         string
-	IsExtended bool
-	Direction  string
-	Bus        int
-	Length     int
-	Data       []byte
+	Timestamp string
+	ID        CANID
+	Direction string
+	Bus       int
+	Length    int
+	Data      []byte
 }
 
 // FrameInfo stores frame with metadata for grouping
@@ -19,5 +18,19 @@ type FrameInfo struct {
 	FrameType      string
 	IsHeartbeat    bool
 	IsCBOR         bool
-	SequenceNum    int // For maintaining order when timestamps are identical
+	SequenceNum    int      // For maintaining order when timestamps are identical
+	Message        *Message // Decoded CBOR message this frame belongs to, once complete
+	BusState       string   // bus state when the frame was seen, with -states
+	State          string   // state set by the last completed -states sequence
+}
+
+// Message is a CBOR message reassembled from START/CONT frames
+type Message struct {
+	ID         CANID
+	Bus        int
+	Frames     []*FrameInfo // frames that contributed to the message
+	FrameCount int          // frames appended since the START frame
+	Timestamp  float64      // timestamp of the frame that completed the message
+	Raw        []byte
+	Item       interface{}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileError is a capture file that could not be processed
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// runFiles calls fn for every file on up to jobs goroutines. Files that
+// already failed are skipped; the returned slice holds a *FileError for
+// each file that failed so far. fn must only write state of its own index.
+// With progress, a single worker reports the bytes read of each file and
+// several workers report the number of files done.
+func runFiles(paths []string, jobs int, progress bool, failed []error, fn func(i int, path string, progress bool) error) []error {
+	errs := make([]error, len(paths))
+	copy(errs, failed)
+
+	var pending []int
+	for i := range paths {
+		if errs[i] == nil {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return errs
+	}
+	jobs = min(max(jobs, 1), len(pending))
+
+	var counter *fileProgress
+	if progress && jobs > 1 {
+		counter = &fileProgress{total: len(pending), start: time.Now()}
+		defer counter.Finish()
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(i, paths[i], progress && jobs == 1); err != nil {
+					errs[i] = &FileError{Path: paths[i], Err: err}
+				}
+				if counter != nil {
+					counter.Done(paths[i])
+				}
+			}
+		}()
+	}
+	for _, i := range pending {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// fileProgress reports on stderr how many of a set of files are done, with
+// the remaining time estimated from the files done so far
+type fileProgress struct {
+	mu    sync.Mutex
+	total int
+	done  int
+	start time.Time
+}
+
+// Done counts one finished file and updates the progress line
+func (p *fileProgress) Done(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	line := fmt.Sprintf("⏳ %d/%d files (%.0f%%), last %s", p.done, p.total, 100*float64(p.done)/float64(p.total), path)
+	if p.done < p.total {
+		perFile := time.Since(p.start) / time.Duration(p.done)
+		line += fmt.Sprintf(", %s left", (perFile * time.Duration(p.total-p.done)).Round(time.Second))
+	}
+	fmt.Fprintf(os.Stderr, "\r%s%s", line, strings.Repeat(" ", 8))
+}
+
+// Finish ends the progress line
+func (p *fileProgress) Finish() {
+	fmt.Fprintln(os.Stderr)
+}
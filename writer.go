@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+)
+
+// formatCandumpLine formats a frame in candump log format (candump -L):
+// (timestamp) interface ID#PAYLOAD
+func formatCandumpLine(frame *CANFrame, timestamp float64, iface string) string {
+	return fmt.Sprintf("(%.6f) %s %s#%X", timestamp, iface, frame.ID, frame.Data)
+}